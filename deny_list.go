@@ -1,33 +1,516 @@
 package main
 
-import "golang.org/x/exp/maps"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/xerrors"
+)
 
 const (
 	InfernalPublicKey = "d17eef211f510479ee6696495a2589f7e9fb055c2576749747d93444883e0123"
+
+	// DefaultDenyListPollInterval is how often a FileDenyList or HTTPDenyList
+	// re-reads its source absent a SIGHUP, if the operator doesn't configure
+	// a different interval.
+	DefaultDenyListPollInterval = 5 * time.Minute
+
+	// denyListSigSuffix is appended to a FileDenyList/HTTPDenyList's
+	// configured path or URL to find its detached signature, if one is
+	// expected (i.e. an admin public key is configured).
+	denyListSigSuffix = ".sig"
 )
 
+var (
+	// ErrDenyListSignatureMissing is returned by Reload when an admin public
+	// key is configured but no signature could be found alongside the deny
+	// list source.
+	ErrDenyListSignatureMissing = xerrors.New("deny list admin key is configured, but no signature was found")
+
+	// ErrDenyListSignatureInvalid is returned by Reload when a deny list's
+	// signature doesn't verify against the configured admin public key.
+	ErrDenyListSignatureInvalid = xerrors.New("deny list signature is invalid")
+)
+
+// DenyEntry is a single banned key, as encoded one-per-line in a deny list
+// file or URL.
+type DenyEntry struct {
+	PublicKey string     `json:"public_key"`
+	Reason    string     `json:"reason"`
+	AddedAt   time.Time  `json:"added_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
 // A base deny list containing the infernal key listed in the specification.
-// Deny list implementations should always start with this bsae list and augment
+// Deny list implementations should always start with this base list and augment
 // it from there.
-var baseDenyList = map[string]struct{}{
-	InfernalPublicKey: {},
+var baseDenyList = map[string]DenyEntry{
+	InfernalPublicKey: {
+		PublicKey: InfernalPublicKey,
+		Reason:    "Always-denied key defined by the Spring '83 specification.",
+	},
 }
 
 type DenyList interface {
 	Contains(key string) bool
 }
 
+// reloadLooper is implemented by a DenyList that runs its own periodic
+// ReloadLoop (FileDenyList and HTTPDenyList, via reloadableDenyList).
+// runServe starts it on a goroutine the same way it does a BoardStore's
+// ReapLoop.
+type reloadLooper interface {
+	ReloadLoop(ctx context.Context, shutdown <-chan struct{})
+}
+
+// Reloadable is implemented by a DenyList that can refresh its entries from
+// a backing source without restarting the process. runServe starts a
+// ReloadLoop for any configured DenyList that's Reloadable, and the admin
+// reload endpoint uses it to trigger an immediate, on-demand refresh.
+type Reloadable interface {
+	Reload(ctx context.Context) error
+}
+
 type MemoryDenyList struct {
 	denied map[string]struct{}
 }
 
 func NewMemoryDenyList() *MemoryDenyList {
-	return &MemoryDenyList{
-		denied: maps.Clone(baseDenyList),
+	denied := make(map[string]struct{}, len(baseDenyList))
+	for key := range baseDenyList {
+		denied[key] = struct{}{}
 	}
+
+	return &MemoryDenyList{denied: denied}
 }
 
 func (l *MemoryDenyList) Contains(key string) bool {
 	_, ok := l.denied[key]
 	return ok
 }
+
+// denyListSource fetches one or more payloads making up a deny list --
+// normally just one, but an HTTPDenyList configured with several URLs
+// returns one per URL, each verified and parsed independently before their
+// entries are merged together.
+type denyListSource interface {
+	fetch(ctx context.Context) ([]denyListPayload, error)
+}
+
+// denyListPayload is a single fetched deny list body plus its detached
+// signature, if one was found. sig is nil if no signature is available.
+type denyListPayload struct {
+	origin    string
+	data, sig []byte
+}
+
+// reloadableDenyList loads entries from a denyListSource on startup and
+// again on every Reload, merging them with baseDenyList so the always-on
+// baseline entries can never be dropped by a bad or stale source. It's
+// embedded by both FileDenyList and HTTPDenyList, which supply only the
+// source and a name for logging.
+type reloadableDenyList struct {
+	adminPublicKey ed25519.PublicKey
+	cachePath      string
+	logger         *logrus.Logger
+	name           string
+	pollInterval   time.Duration
+	source         denyListSource
+	timeNow        func() time.Time
+
+	mu                sync.RWMutex
+	denied            map[string]DenyEntry
+	reloadLoopStarted bool
+}
+
+// newReloadableDenyList builds and does the initial load for a
+// reloadableDenyList. cachePath, if non-empty, is where the last
+// successfully merged list is cached to disk; if the initial load fails
+// (e.g. source is unreachable), the cache is loaded instead so startup isn't
+// blocked on network, rather than failing outright. Pass "" to skip caching.
+func newReloadableDenyList(logger *logrus.Logger, name string, source denyListSource, adminPublicKeyHex, cachePath string, pollInterval time.Duration) (*reloadableDenyList, error) {
+	var adminPublicKey ed25519.PublicKey
+	if adminPublicKeyHex != "" {
+		publicKeyBytes, err := hex.DecodeString(adminPublicKeyHex)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing admin public key: %w", err)
+		}
+		if len(publicKeyBytes) != ed25519.PublicKeySize {
+			return nil, xerrors.Errorf("admin public key's length is %d, but should be %d", len(publicKeyBytes), ed25519.PublicKeySize)
+		}
+		adminPublicKey = publicKeyBytes
+	}
+
+	if pollInterval <= 0 {
+		pollInterval = DefaultDenyListPollInterval
+	}
+
+	l := &reloadableDenyList{
+		adminPublicKey: adminPublicKey,
+		cachePath:      cachePath,
+		logger:         logger,
+		name:           name,
+		pollInterval:   pollInterval,
+		source:         source,
+		timeNow:        time.Now,
+		denied:         maps.Clone(baseDenyList),
+	}
+
+	if err := l.Reload(context.Background()); err != nil {
+		if cachePath == "" {
+			return nil, xerrors.Errorf("error loading initial deny list: %w", err)
+		}
+
+		logger.Infof(name+": Error loading initial deny list (%v), falling back to cache at %q", err, cachePath)
+		if cacheErr := l.loadCache(); cacheErr != nil {
+			return nil, xerrors.Errorf("error loading initial deny list (%v), and error loading cache: %w", err, cacheErr)
+		}
+	}
+
+	return l, nil
+}
+
+func (l *reloadableDenyList) Contains(key string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.denied[key]
+	if !ok {
+		return false
+	}
+
+	return entry.ExpiresAt == nil || l.timeNow().Before(*entry.ExpiresAt)
+}
+
+// Reload re-fetches entries from the source and, on success, atomically
+// replaces the in-memory set. A failure (unreadable source, bad signature,
+// unparseable entry) leaves the previously loaded entries in place rather
+// than emptying the deny list.
+func (l *reloadableDenyList) Reload(ctx context.Context) error {
+	payloads, err := l.source.fetch(ctx)
+	if err != nil {
+		return xerrors.Errorf("error fetching deny list: %w", err)
+	}
+
+	denied := maps.Clone(baseDenyList)
+	var numEntries int
+
+	for _, payload := range payloads {
+		if l.adminPublicKey != nil {
+			if payload.sig == nil {
+				return xerrors.Errorf("%s: %w", payload.origin, ErrDenyListSignatureMissing)
+			}
+			if !ed25519.Verify(l.adminPublicKey, payload.data, payload.sig) {
+				return xerrors.Errorf("%s: %w", payload.origin, ErrDenyListSignatureInvalid)
+			}
+		}
+
+		entries, err := parseDenyListEntries(payload.data)
+		if err != nil {
+			return xerrors.Errorf("%s: error parsing deny list: %w", payload.origin, err)
+		}
+
+		for _, entry := range entries {
+			denied[entry.PublicKey] = entry
+		}
+		numEntries += len(entries)
+	}
+
+	l.mu.Lock()
+	l.denied = denied
+	l.mu.Unlock()
+
+	if l.cachePath != "" {
+		if err := writeDenyListCache(l.cachePath, denied); err != nil {
+			l.logger.Infof(l.name+": Error writing deny list cache: %v", err)
+		}
+	}
+
+	l.logger.Infof(l.name+": Reloaded deny list with %d entr(ies) from %d source(s), plus %d baseline",
+		numEntries, len(payloads), len(baseDenyList))
+
+	return nil
+}
+
+// loadCache loads the last-good merged list from cachePath, for use when the
+// initial Reload on startup fails because the configured source (typically
+// an HTTPDenyList's URLs) is unreachable.
+func (l *reloadableDenyList) loadCache() error {
+	data, err := os.ReadFile(l.cachePath)
+	if err != nil {
+		return xerrors.Errorf("error reading %q: %w", l.cachePath, err)
+	}
+
+	entries, err := parseDenyListEntries(data)
+	if err != nil {
+		return xerrors.Errorf("error parsing cached deny list: %w", err)
+	}
+
+	denied := maps.Clone(baseDenyList)
+	for _, entry := range entries {
+		denied[entry.PublicKey] = entry
+	}
+
+	l.mu.Lock()
+	l.denied = denied
+	l.mu.Unlock()
+
+	l.logger.Infof(l.name+": Loaded %d entr(ies) from cache at %q, plus %d baseline", len(entries), l.cachePath, len(baseDenyList))
+
+	return nil
+}
+
+// writeDenyListCache writes denied (minus the always-present baseDenyList
+// entries) to path as JSON lines, the same format parseDenyListEntries
+// reads, via a write-then-rename so a crash mid-write can't leave a
+// truncated cache behind.
+func writeDenyListCache(path string, denied map[string]DenyEntry) error {
+	var buf bytes.Buffer
+	for key, entry := range denied {
+		if _, ok := baseDenyList[key]; ok {
+			continue
+		}
+
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return xerrors.Errorf("error marshaling cache entry: %w", err)
+		}
+		buf.Write(encoded)
+		buf.WriteByte('\n')
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o600); err != nil {
+		return xerrors.Errorf("error writing %q: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return xerrors.Errorf("error renaming %q to %q: %w", tmpPath, path, err)
+	}
+
+	return nil
+}
+
+// ReloadLoop blocks, reloading on every tick of pollInterval and
+// additionally whenever the process receives SIGHUP, which lets an operator
+// force an immediate refresh (e.g. after pushing a new ban) without waiting
+// out the poll interval.
+func (l *reloadableDenyList) ReloadLoop(ctx context.Context, shutdown <-chan struct{}) {
+	if l.reloadLoopStarted {
+		panic("ReloadLoop already started -- should only be run once")
+	}
+	l.reloadLoopStarted = true
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	ticker := time.NewTicker(l.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			l.logger.Info(l.name + ": Received shutdown signal")
+			return
+
+		case <-sighup:
+			l.logger.Info(l.name + ": Received SIGHUP, reloading")
+
+		case <-ticker.C:
+		}
+
+		if err := l.Reload(ctx); err != nil {
+			l.logger.Infof(l.name+": Error reloading: %v", err)
+		}
+	}
+}
+
+// parseDenyListEntries parses data as JSON lines, one DenyEntry per
+// non-empty line.
+func parseDenyListEntries(data []byte) ([]DenyEntry, error) {
+	var entries []DenyEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry DenyEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, xerrors.Errorf("error parsing line %d: %w", lineNum, err)
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("error scanning deny list: %w", err)
+	}
+
+	return entries, nil
+}
+
+// FileDenyList is a DenyList whose entries are loaded from a local file,
+// re-read on every Reload. If an admin public key is configured, a detached
+// signature is expected at path+".sig".
+type FileDenyList struct {
+	*reloadableDenyList
+}
+
+// NewFileDenyList loads and returns a FileDenyList backed by path.
+// adminPublicKeyHex may be empty to skip signature verification entirely.
+func NewFileDenyList(logger *logrus.Logger, path, adminPublicKeyHex string, pollInterval time.Duration) (*FileDenyList, error) {
+	core, err := newReloadableDenyList(logger, "FileDenyList", &fileDenyListSource{path: path}, adminPublicKeyHex, "", pollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDenyList{reloadableDenyList: core}, nil
+}
+
+type fileDenyListSource struct {
+	path string
+}
+
+func (s *fileDenyListSource) fetch(context.Context) ([]denyListPayload, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading %q: %w", s.path, err)
+	}
+
+	var sig []byte
+	rawSig, err := os.ReadFile(s.path + denyListSigSuffix)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, xerrors.Errorf("error reading %q: %w", s.path+denyListSigSuffix, err)
+		}
+	} else {
+		sig = decodeHexSignature(rawSig)
+	}
+
+	return []denyListPayload{{origin: s.path, data: data, sig: sig}}, nil
+}
+
+// HTTPDenyList is a DenyList whose entries are loaded from one or more
+// remote URLs, re-read on every Reload and merged together. If an admin
+// public key is configured, a detached signature is expected at each
+// url+".sig".
+type HTTPDenyList struct {
+	*reloadableDenyList
+}
+
+// NewHTTPDenyList loads and returns an HTTPDenyList backed by urls, merging
+// their entries together on every Reload. adminPublicKeyHex may be empty to
+// skip signature verification entirely. cachePath, if non-empty, caches the
+// last successfully merged list to disk so a restart isn't blocked on
+// network if every url is briefly unreachable; pass "" to skip caching.
+func NewHTTPDenyList(logger *logrus.Logger, httpClient *http.Client, urls []string, adminPublicKeyHex, cachePath string, pollInterval time.Duration) (*HTTPDenyList, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	core, err := newReloadableDenyList(logger, "HTTPDenyList", &httpDenyListSource{httpClient: httpClient, urls: urls}, adminPublicKeyHex, cachePath, pollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPDenyList{reloadableDenyList: core}, nil
+}
+
+type httpDenyListSource struct {
+	httpClient *http.Client
+	urls       []string
+}
+
+func (s *httpDenyListSource) fetch(ctx context.Context) ([]denyListPayload, error) {
+	payloads := make([]denyListPayload, 0, len(s.urls))
+
+	for _, url := range s.urls {
+		data, err := s.get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		var sig []byte
+		rawSig, err := s.get(ctx, url+denyListSigSuffix)
+		if err != nil {
+			var notFound *httpDenyListNotFoundError
+			if !errors.As(err, &notFound) {
+				return nil, err
+			}
+		} else {
+			sig = decodeHexSignature(rawSig)
+		}
+
+		payloads = append(payloads, denyListPayload{origin: url, data: data, sig: sig})
+	}
+
+	return payloads, nil
+}
+
+func (s *httpDenyListSource) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("error building request for %q: %w", url, err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("error fetching %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &httpDenyListNotFoundError{url: url}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("%q responded with unexpected status %d", url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading response body from %q: %w", url, err)
+	}
+
+	return body, nil
+}
+
+// httpDenyListNotFoundError distinguishes a 404 (the signature simply isn't
+// published) from every other kind of fetch failure.
+type httpDenyListNotFoundError struct {
+	url string
+}
+
+func (e *httpDenyListNotFoundError) Error() string {
+	return fmt.Sprintf("%q not found", e.url)
+}
+
+// decodeHexSignature decodes a signature file's contents as hex, trimming
+// surrounding whitespace first since it's common for one to be saved with a
+// trailing newline. Returns nil (rather than an error) on malformed input,
+// which Reload then treats the same as a missing signature.
+func decodeHexSignature(raw []byte) []byte {
+	sig, err := hex.DecodeString(string(bytes.TrimSpace(raw)))
+	if err != nil {
+		return nil
+	}
+
+	return sig
+}
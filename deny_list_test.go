@@ -1,8 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -11,3 +25,184 @@ func TestMemoryDenyList(t *testing.T) {
 	require.True(t, denyList.Contains(InfernalPublicKey))
 	require.False(t, denyList.Contains(samplePublicKey))
 }
+
+func denyListLines(entries ...DenyEntry) []byte {
+	var lines []string
+	for _, entry := range entries {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			panic(err)
+		}
+		lines = append(lines, string(encoded))
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func TestFileDenyList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.jsonl")
+	require.NoError(t, os.WriteFile(path, denyListLines(DenyEntry{PublicKey: samplePublicKey, Reason: "testing"}), 0o600))
+
+	denyList, err := NewFileDenyList(logrus.New(), path, "", 0)
+	require.NoError(t, err)
+
+	require.True(t, denyList.Contains(InfernalPublicKey))
+	require.True(t, denyList.Contains(samplePublicKey))
+	require.False(t, denyList.Contains("some-other-key"))
+}
+
+func TestFileDenyListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.jsonl")
+	require.NoError(t, os.WriteFile(path, denyListLines(), 0o600))
+
+	denyList, err := NewFileDenyList(logrus.New(), path, "", 0)
+	require.NoError(t, err)
+	require.False(t, denyList.Contains(samplePublicKey))
+
+	require.NoError(t, os.WriteFile(path, denyListLines(DenyEntry{PublicKey: samplePublicKey}), 0o600))
+	require.NoError(t, denyList.Reload(context.Background()))
+	require.True(t, denyList.Contains(samplePublicKey))
+}
+
+func TestFileDenyListExpiredEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.jsonl")
+	expiresAt := stableTime.Add(-1 * time.Hour)
+	require.NoError(t, os.WriteFile(path, denyListLines(DenyEntry{PublicKey: samplePublicKey, ExpiresAt: &expiresAt}), 0o600))
+
+	denyList, err := NewFileDenyList(logrus.New(), path, "", 0)
+	require.NoError(t, err)
+	denyList.timeNow = func() time.Time { return stableTime }
+
+	require.False(t, denyList.Contains(samplePublicKey))
+}
+
+func TestFileDenyListSignatureVerification(t *testing.T) {
+	adminPublicKey, adminPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	adminPublicKeyHex := hex.EncodeToString(adminPublicKey)
+
+	path := filepath.Join(t.TempDir(), "deny.jsonl")
+	data := denyListLines(DenyEntry{PublicKey: samplePublicKey})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	t.Run("MissingSignatureRejected", func(t *testing.T) {
+		_, err := NewFileDenyList(logrus.New(), path, adminPublicKeyHex, 0)
+		require.Error(t, err)
+		require.ErrorIs(t, err, ErrDenyListSignatureMissing)
+	})
+
+	t.Run("ValidSignatureAccepted", func(t *testing.T) {
+		sig := ed25519.Sign(adminPrivateKey, data)
+		require.NoError(t, os.WriteFile(path+denyListSigSuffix, []byte(hex.EncodeToString(sig)), 0o600))
+
+		denyList, err := NewFileDenyList(logrus.New(), path, adminPublicKeyHex, 0)
+		require.NoError(t, err)
+		require.True(t, denyList.Contains(samplePublicKey))
+	})
+
+	t.Run("InvalidSignatureRejected", func(t *testing.T) {
+		badSig := make([]byte, ed25519.SignatureSize)
+		require.NoError(t, os.WriteFile(path+denyListSigSuffix, []byte(hex.EncodeToString(badSig)), 0o600))
+
+		_, err := NewFileDenyList(logrus.New(), path, adminPublicKeyHex, 0)
+		require.Error(t, err)
+		require.ErrorIs(t, err, ErrDenyListSignatureInvalid)
+	})
+}
+
+func TestHTTPDenyList(t *testing.T) {
+	var mu sync.Mutex
+	data := denyListLines(DenyEntry{PublicKey: samplePublicKey})
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, denyListSigSuffix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(data)
+	}))
+	defer host.Close()
+
+	denyList, err := NewHTTPDenyList(logrus.New(), nil, []string{host.URL + "/deny.jsonl"}, "", "", 0)
+	require.NoError(t, err)
+	require.True(t, denyList.Contains(samplePublicKey))
+
+	mu.Lock()
+	data = denyListLines()
+	mu.Unlock()
+	require.NoError(t, denyList.Reload(context.Background()))
+	require.False(t, denyList.Contains(samplePublicKey))
+}
+
+func TestHTTPDenyListSignatureVerification(t *testing.T) {
+	adminPublicKey, adminPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	adminPublicKeyHex := hex.EncodeToString(adminPublicKey)
+
+	data := denyListLines(DenyEntry{PublicKey: samplePublicKey})
+	sig := ed25519.Sign(adminPrivateKey, data)
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, denyListSigSuffix) {
+			_, _ = fmt.Fprint(w, hex.EncodeToString(sig))
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer host.Close()
+
+	denyList, err := NewHTTPDenyList(logrus.New(), nil, []string{host.URL + "/deny.jsonl"}, adminPublicKeyHex, "", 0)
+	require.NoError(t, err)
+	require.True(t, denyList.Contains(samplePublicKey))
+}
+
+func TestHTTPDenyListMultipleURLsMerged(t *testing.T) {
+	const otherKey = "ab589f4dde9fce4180fcf42c7b05185b0a02a5d682e353fa39177995083e0519"
+
+	newHost := func(data []byte) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, denyListSigSuffix) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		}))
+	}
+
+	hostA := newHost(denyListLines(DenyEntry{PublicKey: samplePublicKey}))
+	defer hostA.Close()
+	hostB := newHost(denyListLines(DenyEntry{PublicKey: otherKey}))
+	defer hostB.Close()
+
+	denyList, err := NewHTTPDenyList(logrus.New(), nil, []string{hostA.URL + "/deny.jsonl", hostB.URL + "/deny.jsonl"}, "", "", 0)
+	require.NoError(t, err)
+	require.True(t, denyList.Contains(samplePublicKey))
+	require.True(t, denyList.Contains(otherKey))
+}
+
+func TestHTTPDenyListFallsBackToCacheOnStartup(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "deny-cache.jsonl")
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, denyListSigSuffix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(denyListLines(DenyEntry{PublicKey: samplePublicKey}))
+	}))
+
+	denyList, err := NewHTTPDenyList(logrus.New(), nil, []string{host.URL + "/deny.jsonl"}, "", cachePath, 0)
+	require.NoError(t, err)
+	require.True(t, denyList.Contains(samplePublicKey))
+
+	// Take the source down entirely and start a fresh HTTPDenyList against
+	// it -- the cache written by the instance above should let it come up
+	// populated anyway.
+	host.Close()
+
+	fallback, err := NewHTTPDenyList(logrus.New(), nil, []string{host.URL + "/deny.jsonl"}, "", cachePath, 0)
+	require.NoError(t, err)
+	require.True(t, fallback.Contains(samplePublicKey))
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+// ForwardedByHeader is attached to a federated PUT listing the peers that
+// have already forwarded it (as a comma-separated list), so that a peer
+// propagating it onward knows not to bounce it back to somewhere it's
+// already been.
+const ForwardedByHeader = "X-Neospring-Forwarded-By"
+
+const (
+	// forwardWorkerCount bounds the number of forwards that can be in flight
+	// across all peers at once, so a burst of PUTs (or a realm with many
+	// peers) can't spawn unbounded goroutines each making outbound HTTP
+	// requests.
+	forwardWorkerCount = 8
+
+	// forwardQueueSize bounds how many forward jobs can be queued behind the
+	// worker pool before Forward starts dropping the oldest ones. Sized
+	// generously relative to forwardWorkerCount since a job is cheap to hold
+	// (it's just the board content) and we'd rather buffer briefly during a
+	// burst than drop pushes.
+	forwardQueueSize = 256
+
+	// forwardMaxAttempts is how many times push will attempt a single peer
+	// before giving up, once for the initial attempt plus retries on top.
+	forwardMaxAttempts = 3
+
+	// forwardRetryBaseDelay is the backoff applied after the first failed
+	// attempt; it doubles on each subsequent retry.
+	forwardRetryBaseDelay = 200 * time.Millisecond
+)
+
+// PeerStatus summarizes the health of a single federation peer, as last
+// observed while forwarding board updates to it.
+type PeerStatus struct {
+	LastAttempt         time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// forwardJob is a single peer push queued onto the worker pool by Forward.
+type forwardJob struct {
+	peer        string
+	key         string
+	signature   string
+	content     []byte
+	forwardedBy string
+}
+
+// digestEntry is a single (public_key, timestamp) tuple as exchanged during
+// a federation anti-entropy sweep, representing the most recent update a
+// server has recorded for a key.
+type digestEntry struct {
+	PublicKey string    `json:"public_key"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Federator propagates successful board updates out to a fixed set of peer
+// neospring servers, pulls boards this server doesn't have from those peers,
+// and periodically reconciles against them so that a temporarily
+// unreachable peer doesn't cause an update to be missed forever.
+//
+// It knows nothing about `Server` beyond the peer URLs it's given; `Server`
+// is what wires it into the request lifecycle.
+type Federator struct {
+	denyList   DenyList
+	httpClient *http.Client
+	logger     *logrus.Logger
+	peers      []string
+	selfURL    string
+	timeNow    func() time.Time
+
+	forwardQueue chan forwardJob
+
+	statusMu sync.Mutex
+	status   map[string]*PeerStatus
+}
+
+// NewFederator initializes a Federator that forwards to and pulls from the
+// given peer base URLs (e.g. "https://peer.example.com"). selfURL identifies
+// this server in the forwarded-by chain attached to federated PUTs; peers
+// use it to avoid forwarding an update back here. denyList is consulted for
+// every board pulled in from a peer, the same as it is for an inbound PUT.
+//
+// A fixed pool of forwardWorkerCount goroutines is started to drain the
+// forward queue; callers don't need to do anything further to make Forward
+// work.
+func NewFederator(logger *logrus.Logger, selfURL string, peers []string, denyList DenyList) *Federator {
+	f := &Federator{
+		denyList:     denyList,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		logger:       logger,
+		peers:        peers,
+		selfURL:      selfURL,
+		timeNow:      time.Now,
+		forwardQueue: make(chan forwardJob, forwardQueueSize),
+		status:       make(map[string]*PeerStatus, len(peers)),
+	}
+
+	for _, peer := range peers {
+		f.status[peer] = &PeerStatus{}
+	}
+
+	for i := 0; i < forwardWorkerCount; i++ {
+		go f.forwardWorker()
+	}
+
+	return f
+}
+
+// PeerStatuses returns a snapshot of this server's view of every configured
+// peer's health, keyed by peer base URL.
+func (f *Federator) PeerStatuses() map[string]PeerStatus {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+
+	out := make(map[string]PeerStatus, len(f.status))
+	for peer, status := range f.status {
+		out[peer] = *status
+	}
+	return out
+}
+
+// forwardWorker drains forwardQueue forever, running pushes one at a time so
+// that the pool as a whole bounds how many forwards are in flight. Meant to
+// be started on a goroutine; there are forwardWorkerCount of these running
+// concurrently.
+func (f *Federator) forwardWorker() {
+	for job := range f.forwardQueue {
+		f.pushWithRetry(context.Background(), job)
+	}
+}
+
+// Forward queues a board update to be pushed out to every peer that isn't
+// already in forwardedBy, attaching selfURL to the list it sends along so
+// that a peer forwarding it onward in turn doesn't send it back here. It
+// never returns an error: a peer that's down or slow to respond shouldn't be
+// able to hold up the client who made the original PUT, so failures are
+// logged and otherwise swallowed.
+//
+// Jobs are handed off to a bounded worker pool (see forwardWorkerCount)
+// rather than run on their own goroutine, so a burst of PUTs can't spawn an
+// unbounded number of concurrent outbound requests. If the queue is already
+// full, the job is dropped and logged rather than blocking the caller --
+// Forward is called from the request path, so it must never stall a PUT
+// response waiting on federation.
+func (f *Federator) Forward(key, signature string, content []byte, forwardedBy []string) {
+	visited := make(map[string]bool, len(forwardedBy)+1)
+	for _, peer := range forwardedBy {
+		visited[peer] = true
+	}
+	visited[f.selfURL] = true
+
+	nextForwardedBy := strings.Join(append(append([]string(nil), forwardedBy...), f.selfURL), ",")
+
+	for _, peer := range f.peers {
+		if visited[peer] {
+			continue
+		}
+
+		job := forwardJob{peer: peer, key: key, signature: signature, content: content, forwardedBy: nextForwardedBy}
+
+		select {
+		case f.forwardQueue <- job:
+		default:
+			f.logger.Infof("Federator: Forward queue full; dropping forward of key %q to peer %q", key, peer)
+		}
+	}
+}
+
+// pushWithRetry attempts to push job to its peer, retrying with exponential
+// backoff on network errors or a 5xx response, but giving up immediately on
+// a 4xx -- a peer that actively rejects the push isn't going to accept it on
+// a later attempt either. Updates the peer's PeerStatus regardless of
+// outcome.
+func (f *Federator) pushWithRetry(ctx context.Context, job forwardJob) {
+	f.recordAttempt(job.peer)
+
+	var lastErr error
+
+	for attempt := 0; attempt < forwardMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(forwardRetryBaseDelay * time.Duration(1<<(attempt-1)))
+		}
+
+		err := f.push(ctx, job.peer, job.key, job.signature, job.content, job.forwardedBy)
+		if err == nil {
+			f.recordSuccess(job.peer)
+			return
+		}
+
+		lastErr = err
+
+		var permErr *forwardRejectedError
+		if errors.As(err, &permErr) {
+			break
+		}
+	}
+
+	f.recordFailure(job.peer, lastErr)
+	f.logger.Infof("Federator: Error forwarding key %q to peer %q: %v", job.key, job.peer, lastErr)
+}
+
+// forwardRejectedError marks a push failure as a peer-side rejection (a 4xx
+// response) that retrying won't fix, as distinct from a network error or 5xx
+// that's worth another attempt.
+type forwardRejectedError struct {
+	err error
+}
+
+func (e *forwardRejectedError) Error() string { return e.err.Error() }
+func (e *forwardRejectedError) Unwrap() error { return e.err }
+
+func (f *Federator) push(ctx context.Context, peer, key, signature string, content []byte, forwardedBy string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, peer+"/"+key, bytes.NewReader(content))
+	if err != nil {
+		return xerrors.Errorf("error building forward request: %w", err)
+	}
+	req.Header.Set("Spring-Signature", signature)
+	req.Header.Set(ForwardedByHeader, forwardedBy)
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("error making forward request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	// A 409 just means the peer already has this update or something newer,
+	// which isn't a forwarding failure.
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode < 300 {
+		return nil
+	}
+
+	baseErr := xerrors.Errorf("peer %q responded to forward with unexpected status %d", peer, resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &forwardRejectedError{err: baseErr}
+	}
+
+	return baseErr
+}
+
+func (f *Federator) recordAttempt(peer string) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+
+	status := f.peerStatusLocked(peer)
+	status.LastAttempt = time.Now()
+}
+
+func (f *Federator) recordSuccess(peer string) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+
+	status := f.peerStatusLocked(peer)
+	status.LastSuccess = time.Now()
+	status.LastError = ""
+	status.ConsecutiveFailures = 0
+}
+
+func (f *Federator) recordFailure(peer string, err error) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+
+	status := f.peerStatusLocked(peer)
+	status.ConsecutiveFailures++
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// peerStatusLocked returns peer's status entry, creating it if this is the
+// first time we've recorded anything for it. Callers must hold statusMu.
+func (f *Federator) peerStatusLocked(peer string) *PeerStatus {
+	status, ok := f.status[peer]
+	if !ok {
+		status = &PeerStatus{}
+		f.status[peer] = status
+	}
+	return status
+}
+
+// Pull tries each peer in turn looking for key, returning the first board
+// found. Callers are expected to have already confirmed key isn't present
+// locally, and that it's otherwise eligible to be returned (i.e. it's passed
+// denylist and key validity checks).
+func (f *Federator) Pull(ctx context.Context, key string) (*nsstore.Board, error) {
+	for _, peer := range f.peers {
+		board, err := f.pullFrom(ctx, peer, key)
+		if err != nil {
+			f.logger.Infof("Federator: Error pulling key %q from peer %q: %v", key, peer, err)
+			continue
+		}
+
+		if board != nil {
+			return board, nil
+		}
+	}
+
+	return nil, nsstore.ErrKeyNotFound
+}
+
+// pullFrom fetches key from a single peer, returning a nil board (and nil
+// error) if the peer doesn't have it.
+//
+// A peer is untrusted input exactly like an inbound PUT, so the response is
+// subjected to the same checks handlePutKey applies: key validity/expiry,
+// the denylist, the content size limit, and -- critically -- the Ed25519
+// signature, which is what actually proves the content came from key's
+// owner rather than from a malicious or compromised peer.
+func (f *Federator) pullFrom(ctx context.Context, peer, key string) (*nsstore.Board, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+"/"+key, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("error building pull request: %w", err)
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("error making pull request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("peer %q responded to pull with unexpected status %d", peer, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading pull response body: %w", err)
+	}
+
+	if len(content) > MaxContentSize {
+		return nil, xerrors.Errorf("peer %q returned content for key %q larger than the maximum allowed size", peer, key)
+	}
+
+	timestamp, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing peer's Last-Modified header: %w", err)
+	}
+
+	board, err := f.verifyPulledBoard(key, content, resp.Header.Get("Spring-Signature"), timestamp)
+	if err != nil {
+		return nil, xerrors.Errorf("peer %q returned an unverifiable board for key %q: %w", peer, key, err)
+	}
+
+	return board, nil
+}
+
+// verifyPulledBoard checks key, content, and sigStr the same way
+// handlePutKey checks an inbound PUT, since content pulled from a peer is
+// otherwise untrusted input.
+func (f *Federator) verifyPulledBoard(key string, content []byte, sigStr string, timestamp time.Time) (*nsstore.Board, error) {
+	keyObj, err := nskey.ParseKey(key, f.timeNow())
+	if err != nil {
+		return nil, xerrors.Errorf("key failed validation: %w", err)
+	}
+
+	if f.denyList.Contains(key) {
+		return nil, xerrors.New("key is on the denylist")
+	}
+
+	if sigStr == "" {
+		return nil, xerrors.New("response is missing Spring-Signature header")
+	}
+
+	sig, err := hex.DecodeString(sigStr)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, xerrors.New("Spring-Signature header is not a validly-encoded signature")
+	}
+
+	if !keyObj.Verify(content, sig) {
+		return nil, xerrors.New("signature does not verify against key")
+	}
+
+	return &nsstore.Board{
+		Content:   content,
+		Signature: sigStr,
+		Timestamp: timestamp,
+	}, nil
+}
+
+// SweepLoop runs forever, periodically exchanging digests with every peer
+// and handing anything newer than what's stored locally off to store. It
+// blocks, so should be started on a goroutine.
+func (f *Federator) SweepLoop(
+	ctx context.Context,
+	localDigest func() map[string]time.Time,
+	store func(ctx context.Context, key string, board *nsstore.Board) error,
+	shutdown <-chan struct{},
+) {
+	for {
+		f.sweep(ctx, localDigest(), store)
+
+		select {
+		case <-shutdown:
+			f.logger.Infof("Federator: Received shutdown signal")
+			return
+
+		case <-time.After(5 * time.Minute):
+		}
+	}
+}
+
+func (f *Federator) sweep(ctx context.Context, local map[string]time.Time, store func(ctx context.Context, key string, board *nsstore.Board) error) { //nolint:lll
+	for _, peer := range f.peers {
+		remote, err := f.fetchDigest(ctx, peer)
+		if err != nil {
+			f.logger.Infof("Federator: Error fetching digest from peer %q: %v", peer, err)
+			continue
+		}
+
+		for _, entry := range remote {
+			if localTimestamp, ok := local[entry.PublicKey]; ok && !entry.Timestamp.After(localTimestamp) {
+				continue
+			}
+
+			board, err := f.pullFrom(ctx, peer, entry.PublicKey)
+			if err != nil || board == nil {
+				f.logger.Infof("Federator: Error pulling newer key %q found via peer %q: %v", entry.PublicKey, peer, err)
+				continue
+			}
+
+			if err := store(ctx, entry.PublicKey, board); err != nil {
+				f.logger.Infof("Federator: Error storing key %q pulled via peer %q: %v", entry.PublicKey, peer, err)
+			}
+		}
+	}
+}
+
+func (f *Federator) fetchDigest(ctx context.Context, peer string) ([]digestEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+"/federation/digest", nil)
+	if err != nil {
+		return nil, xerrors.Errorf("error building digest request: %w", err)
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("error making digest request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("peer %q responded to digest request with unexpected status %d", peer, resp.StatusCode)
+	}
+
+	var entries []digestEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, xerrors.Errorf("error decoding digest response: %w", err)
+	}
+
+	return entries, nil
+}
+
+// parseForwardedBy splits a ForwardedByHeader value into its component peer
+// identifiers.
+func parseForwardedBy(header string) []string {
+	if header == "" {
+		return nil
+	}
+
+	parts := strings.Split(header, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
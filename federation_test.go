@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederatorPushWithRetry(t *testing.T) {
+	t.Run("SuccessRecordsStatus", func(t *testing.T) {
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer peer.Close()
+
+		f := NewFederator(logrus.New(), "https://self.example.com", []string{peer.URL}, NewMemoryDenyList())
+		f.pushWithRetry(context.Background(), forwardJob{peer: peer.URL, key: "somekey", signature: "sig", content: []byte("content")})
+
+		status := f.PeerStatuses()[peer.URL]
+		require.False(t, status.LastSuccess.IsZero())
+		require.Equal(t, 0, status.ConsecutiveFailures)
+	})
+
+	t.Run("ClientErrorDoesNotRetry", func(t *testing.T) {
+		var numRequests atomic.Int32
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			numRequests.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer peer.Close()
+
+		f := NewFederator(logrus.New(), "https://self.example.com", []string{peer.URL}, NewMemoryDenyList())
+		f.pushWithRetry(context.Background(), forwardJob{peer: peer.URL, key: "somekey", signature: "sig", content: []byte("content")})
+
+		require.Equal(t, int32(1), numRequests.Load())
+
+		status := f.PeerStatuses()[peer.URL]
+		require.True(t, status.LastSuccess.IsZero())
+		require.Equal(t, 1, status.ConsecutiveFailures)
+		require.NotEmpty(t, status.LastError)
+	})
+
+	t.Run("ServerErrorRetriesUpToMaxAttempts", func(t *testing.T) {
+		var numRequests atomic.Int32
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			numRequests.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer peer.Close()
+
+		f := NewFederator(logrus.New(), "https://self.example.com", []string{peer.URL}, NewMemoryDenyList())
+		f.pushWithRetry(context.Background(), forwardJob{peer: peer.URL, key: "somekey", signature: "sig", content: []byte("content")})
+
+		require.Equal(t, int32(forwardMaxAttempts), numRequests.Load())
+	})
+}
+
+func TestFederatorForwardQueueFull(t *testing.T) {
+	// A peer that blocks forever so the queue backs up behind it.
+	block := make(chan struct{})
+	defer close(block)
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer peer.Close()
+
+	f := NewFederator(logrus.New(), "https://self.example.com", []string{peer.URL}, NewMemoryDenyList())
+
+	// Enough forwards to fill the queue and the worker pool many times over;
+	// Forward must not block regardless.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < forwardQueueSize+forwardWorkerCount+10; i++ {
+			f.Forward("somekey", "sig", []byte("content"), nil)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Forward blocked instead of dropping once the queue was full")
+	}
+}
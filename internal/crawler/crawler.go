@@ -0,0 +1,452 @@
+// Package crawler implements open-web discovery of Spring '83 boards, as an
+// alternative to learning about boards only through peer federation pushes
+// (see Federator in the main package). Starting from an arbitrary homepage
+// URL, it follows the spec's suggested `<link rel="alternate"
+// type="text/board+html">` convention to find boards, verifies each one's
+// signature before trusting it, and can optionally seed a local BoardStore
+// with what it finds.
+package crawler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+const (
+	// DefaultMaxDepth bounds how many `<link rel="next">` hops Crawl will
+	// follow away from a board it's already ingested.
+	DefaultMaxDepth = 5
+
+	// DefaultMaxFanout bounds how many board or "next" links a single page
+	// is allowed to contribute, so a pathological or adversarial page can't
+	// blow up the amount of work a single Crawl call does.
+	DefaultMaxFanout = 20
+
+	// DefaultPerHostInterval is the minimum gap enforced between requests to
+	// the same host, so crawling many boards hosted on one server doesn't
+	// look like (or act like) a denial of service attempt.
+	DefaultPerHostInterval = 500 * time.Millisecond
+
+	// DefaultTimeout bounds the wall-clock time budget for an entire Crawl
+	// call, regardless of how much of the link graph remains unexplored
+	// when it elapses.
+	DefaultTimeout = 2 * time.Minute
+
+	// DefaultMaxPages bounds the total number of HTTP requests (homepage,
+	// boards, and "next" hops combined) a single Crawl call will make, as a
+	// backstop against DefaultTimeout in case the host clock or network is
+	// behaving strangely.
+	DefaultMaxPages = 200
+
+	// fetchMaxBytes caps how much of any single response body is read, so a
+	// misbehaving or hostile server can't force the crawler to buffer an
+	// unbounded amount of data.
+	fetchMaxBytes = 1 << 20 // 1 MiB
+)
+
+// boardContentTypeValue is the `type` attribute value a `<link rel="alternate">`
+// element must carry to be treated as pointing at a Spring '83 board, per the
+// convention the spec sketches out.
+const boardContentTypeValue = "text/board+html"
+
+// Options configures a Crawler. The zero value is usable: every field falls
+// back to a DefaultXxx constant.
+type Options struct {
+	// Store, if non-nil, receives a Put for every board the crawler
+	// verifies. Left nil, Crawl only discovers and verifies boards without
+	// seeding anything -- useful for dry runs.
+	Store nsstore.BoardStore
+
+	// HTTPClient makes the crawler's outbound requests. Defaults to a
+	// client with a conservative per-request timeout; overridable in tests.
+	HTTPClient *http.Client
+
+	MaxDepth        int
+	MaxFanout       int
+	PerHostInterval time.Duration
+	Timeout         time.Duration
+	MaxPages        int
+
+	// Now returns the current time, used to validate key expiry. Defaults
+	// to time.Now; overridable in tests.
+	Now func() time.Time
+}
+
+// BoardResult records a single board the crawler found and attempted to
+// verify.
+type BoardResult struct {
+	Key        string    `json:"key"`
+	URL        string    `json:"url"`
+	SourcePage string    `json:"source_page"`
+	Depth      int       `json:"depth"`
+	Verified   bool      `json:"verified"`
+	Stored     bool      `json:"stored"`
+	Timestamp  time.Time `json:"timestamp,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Report is the JSON-serializable result of a single Crawl call.
+type Report struct {
+	Seed      string        `json:"seed"`
+	Boards    []BoardResult `json:"boards"`
+	Stats     Stats         `json:"stats"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+// Stats tallies what a Crawl call did, so a caller can tell a quiet link
+// graph apart from a crawl that was cut short.
+type Stats struct {
+	PagesFetched int `json:"pages_fetched"`
+	BoardsFound  int `json:"boards_found"`
+	Verified     int `json:"verified"`
+	Rejected     int `json:"rejected"`
+	Stored       int `json:"stored"`
+}
+
+// Crawler discovers Spring '83 boards by following `<link>` conventions
+// across the open web, verifying each one's signature before trusting it.
+// A Crawler is not safe for concurrent use by multiple goroutines; each
+// Crawl call owns its own visited set and rate limiter state.
+type Crawler struct {
+	opts Options
+
+	visited      map[string]bool
+	hostLimiter  map[string]time.Time
+	pagesFetched int
+}
+
+// NewCrawler returns a Crawler configured by opts, filling in defaults for
+// any zero-valued field.
+func NewCrawler(opts Options) *Crawler {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 15 * time.Second}
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	if opts.MaxFanout <= 0 {
+		opts.MaxFanout = DefaultMaxFanout
+	}
+	if opts.PerHostInterval <= 0 {
+		opts.PerHostInterval = DefaultPerHostInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = DefaultMaxPages
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &Crawler{
+		opts:        opts,
+		visited:     make(map[string]bool),
+		hostLimiter: make(map[string]time.Time),
+	}
+}
+
+// Crawl fetches seedURL, discovers boards advertised from it via `<link
+// rel="alternate" type="text/board+html">`, verifies each one, and recurses
+// into each board's own `<link rel="next">` chain (bounded by
+// Options.MaxDepth). Returns a Report describing everything it found,
+// verified, rejected, and (if Options.Store is set) stored, even if the
+// crawl was cut short by Options.Timeout or Options.MaxPages -- a partial
+// report is still useful to an operator bootstrapping a node.
+func (c *Crawler) Crawl(ctx context.Context, seedURL string) (*Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	report := &Report{Seed: seedURL}
+
+	homepage, err := c.fetch(ctx, seedURL)
+	if err != nil {
+		return nil, xerrors.Errorf("error fetching seed URL %q: %w", seedURL, err)
+	}
+
+	boardLinks := findLinks(homepage, seedURL, "alternate", boardContentTypeValue)
+	if len(boardLinks) > c.opts.MaxFanout {
+		boardLinks = boardLinks[:c.opts.MaxFanout]
+	}
+
+	for _, boardURL := range boardLinks {
+		c.ingest(ctx, report, boardURL, seedURL, 0)
+
+		if ctx.Err() != nil || c.pagesFetched >= c.opts.MaxPages {
+			report.Truncated = true
+			break
+		}
+	}
+
+	report.Stats.PagesFetched = c.pagesFetched
+	return report, nil
+}
+
+// ingest fetches and verifies the board at boardURL, records the outcome
+// onto report, and -- if the board verifies and Options.Store is set --
+// stores it. It then follows any `<link rel="next">` the board's content
+// advertises, recursing up to Options.MaxDepth hops deep.
+func (c *Crawler) ingest(ctx context.Context, report *Report, boardURL, sourcePage string, depth int) {
+	if ctx.Err() != nil || c.pagesFetched >= c.opts.MaxPages {
+		report.Truncated = true
+		return
+	}
+
+	if c.visited[boardURL] {
+		return
+	}
+	c.visited[boardURL] = true
+
+	result := BoardResult{URL: boardURL, SourcePage: sourcePage, Depth: depth}
+
+	content, headers, err := c.fetchWithHeaders(ctx, boardURL)
+	if err != nil {
+		result.Error = err.Error()
+		report.Boards = append(report.Boards, result)
+		report.Stats.BoardsFound++
+		report.Stats.Rejected++
+		return
+	}
+	report.Stats.BoardsFound++
+
+	key, err := boardKeyFromURL(boardURL)
+	if err != nil {
+		result.Error = err.Error()
+		report.Boards = append(report.Boards, result)
+		report.Stats.Rejected++
+		return
+	}
+	result.Key = key
+
+	board, err := c.verify(key, content, headers)
+	if err != nil {
+		result.Error = err.Error()
+		report.Boards = append(report.Boards, result)
+		report.Stats.Rejected++
+		return
+	}
+
+	result.Verified = true
+	result.Timestamp = board.Timestamp
+	report.Stats.Verified++
+
+	if c.opts.Store != nil {
+		if err := c.opts.Store.Put(ctx, key, board); err != nil {
+			result.Error = xerrors.Errorf("error storing verified board: %w", err).Error()
+		} else {
+			result.Stored = true
+			report.Stats.Stored++
+		}
+	}
+
+	report.Boards = append(report.Boards, result)
+
+	if depth >= c.opts.MaxDepth {
+		return
+	}
+
+	nextLinks := findLinks(string(content), boardURL, "next", "")
+	if len(nextLinks) > c.opts.MaxFanout {
+		nextLinks = nextLinks[:c.opts.MaxFanout]
+	}
+
+	for _, next := range nextLinks {
+		c.ingest(ctx, report, next, boardURL, depth+1)
+
+		if ctx.Err() != nil || c.pagesFetched >= c.opts.MaxPages {
+			report.Truncated = true
+			return
+		}
+	}
+}
+
+// verify checks that content was signed by key's corresponding private key,
+// via the `Spring-Signature` header the spec requires a board response to
+// carry, and that key itself is a currently-valid Spring '83 key. Mirrors
+// the checks `Server.handlePutKey` applies to an inbound PUT, since a
+// crawled board is otherwise untrusted input from the open web.
+func (c *Crawler) verify(key string, content []byte, headers http.Header) (*nsstore.Board, error) {
+	keyObj, err := nskey.ParseKey(key, c.opts.Now())
+	if err != nil {
+		return nil, xerrors.Errorf("key %q failed validation: %w", key, err)
+	}
+
+	sigStr := headers.Get("Spring-Signature")
+	if sigStr == "" {
+		return nil, xerrors.New("response is missing Spring-Signature header")
+	}
+
+	sig, err := hex.DecodeString(sigStr)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, xerrors.New("Spring-Signature header is not a validly-encoded signature")
+	}
+
+	if !keyObj.Verify(content, sig) {
+		return nil, xerrors.New("signature does not verify against key")
+	}
+
+	var timestamp time.Time
+	if lastModified := headers.Get("Last-Modified"); lastModified != "" {
+		if t, err := time.Parse(http.TimeFormat, lastModified); err == nil {
+			timestamp = t
+		}
+	}
+	if timestamp.IsZero() {
+		timestamp = c.opts.Now()
+	}
+
+	return &nsstore.Board{Content: content, Signature: sigStr, Timestamp: timestamp}, nil
+}
+
+// fetch fetches url, enforcing the per-host rate limit and page budget, and
+// discards the response headers.
+func (c *Crawler) fetch(ctx context.Context, target string) (string, error) {
+	content, _, err := c.fetchWithHeaders(ctx, target)
+	return string(content), err
+}
+
+// fetchWithHeaders performs a rate-limited, size-bounded GET of target.
+func (c *Crawler) fetchWithHeaders(ctx context.Context, target string) ([]byte, http.Header, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("error parsing URL %q: %w", target, err)
+	}
+
+	if err := c.waitForHost(ctx, parsed.Host); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("error building request: %w", err)
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.pagesFetched++
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, xerrors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxBytes))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("error reading response body: %w", err)
+	}
+
+	return content, resp.Header, nil
+}
+
+// waitForHost blocks, if necessary, until Options.PerHostInterval has
+// elapsed since the last request made to host.
+func (c *Crawler) waitForHost(ctx context.Context, host string) error {
+	if last, ok := c.hostLimiter[host]; ok {
+		if wait := c.opts.PerHostInterval - time.Since(last); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	c.hostLimiter[host] = time.Now()
+	return nil
+}
+
+// boardKeyFromURL extracts a Spring '83 key from a board URL's final path
+// segment, which is the convention both neospring's own `/{key}` route and
+// the spec's examples use.
+func boardKeyFromURL(boardURL string) (string, error) {
+	parsed, err := url.Parse(boardURL)
+	if err != nil {
+		return "", xerrors.Errorf("error parsing board URL %q: %w", boardURL, err)
+	}
+
+	key := strings.Trim(parsed.Path, "/")
+	if idx := strings.LastIndex(key, "/"); idx >= 0 {
+		key = key[idx+1:]
+	}
+	if key == "" {
+		return "", xerrors.Errorf("board URL %q has no key in its path", boardURL)
+	}
+
+	return key, nil
+}
+
+// linkTagRE matches a single `<link ...>` element so its attributes can be
+// picked apart by linkAttrRE. Deliberately as strict as the rest of
+// neospring's HTML handling (see timestampRE in server.go): this is meant to
+// recognize the narrow convention the spec sketches, not to be a general
+// HTML parser.
+var linkTagRE = regexp.MustCompile(`(?i)<link\s+([^>]*)/?>`)
+
+var linkAttrRE = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+
+// findLinks scans content for `<link>` elements matching rel (and, if
+// contentType is non-empty, also matching type), resolving each `href`
+// against base and returning the absolute URLs in document order. Malformed
+// or unresolvable links are skipped rather than failing the whole scan.
+func findLinks(content, base, rel, contentType string) []string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil
+	}
+
+	var out []string
+
+	for _, tagMatch := range linkTagRE.FindAllStringSubmatch(content, -1) {
+		attrs := make(map[string]string)
+		for _, attrMatch := range linkAttrRE.FindAllStringSubmatch(tagMatch[1], -1) {
+			attrs[strings.ToLower(attrMatch[1])] = attrMatch[2]
+		}
+
+		if attrs["rel"] != rel {
+			continue
+		}
+		if contentType != "" && attrs["type"] != contentType {
+			continue
+		}
+
+		href := attrs["href"]
+		if href == "" {
+			continue
+		}
+
+		resolved, err := baseURL.Parse(href)
+		if err != nil {
+			continue
+		}
+
+		out = append(out, resolved.String())
+	}
+
+	return out
+}
+
+// String implements fmt.Stringer for convenient logging.
+func (r *Report) String() string {
+	return fmt.Sprintf("crawl of %q: %d pages fetched, %d boards found, %d verified, %d rejected, %d stored",
+		r.Seed, r.Stats.PagesFetched, r.Stats.BoardsFound, r.Stats.Verified, r.Stats.Rejected, r.Stats.Stored)
+}
@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+const (
+	samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+	samplePublicKey  = "e90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1124"
+)
+
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+func stableTimeFunc() time.Time { return stableTime }
+
+func TestFindLinks(t *testing.T) {
+	content := `
+<html><head>
+<link rel="alternate" type="text/board+html" href="/boards/abc">
+<link rel="alternate" type="text/other" href="/boards/ignored">
+<link rel="next" href="https://other.example.com/boards/def">
+</head></html>
+`
+
+	boardLinks := findLinks(content, "https://example.com/", "alternate", boardContentTypeValue)
+	require.Equal(t, []string{"https://example.com/boards/abc"}, boardLinks)
+
+	nextLinks := findLinks(content, "https://example.com/", "next", "")
+	require.Equal(t, []string{"https://other.example.com/boards/def"}, nextLinks)
+}
+
+func TestBoardKeyFromURL(t *testing.T) {
+	key, err := boardKeyFromURL("https://example.com/boards/" + samplePublicKey)
+	require.NoError(t, err)
+	require.Equal(t, samplePublicKey, key)
+
+	_, err = boardKeyFromURL("https://example.com/")
+	require.Error(t, err)
+}
+
+func TestCrawlerCrawl(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := []byte(`<time datetime="2022-11-01T00:00:00Z">hello</time>`)
+	signature := keyPair.SignHex(content)
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<link rel="alternate" type="text/board+html" href="/boards/%s">`, keyPair.PublicKey)
+	})
+	mux.HandleFunc("/boards/"+keyPair.PublicKey, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Spring-Signature", signature)
+		w.Header().Set("Last-Modified", stableTime.Format(http.TimeFormat))
+		w.Write(content)
+	})
+
+	server := httptest.NewServer(&mux)
+	defer server.Close()
+
+	store := nsmemorystore.NewMemoryStore(logrus.New(), 0)
+
+	c := NewCrawler(Options{
+		Store:           store,
+		PerHostInterval: time.Millisecond,
+		Now:             stableTimeFunc,
+	})
+
+	report, err := c.Crawl(context.Background(), server.URL+"/")
+	require.NoError(t, err)
+
+	require.Len(t, report.Boards, 1)
+	require.True(t, report.Boards[0].Verified)
+	require.True(t, report.Boards[0].Stored)
+	require.Equal(t, keyPair.PublicKey, report.Boards[0].Key)
+	require.Equal(t, 1, report.Stats.Verified)
+	require.Equal(t, 1, report.Stats.Stored)
+}
+
+func TestCrawlerCrawlRejectsBadSignature(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := []byte(`<time datetime="2022-11-01T00:00:00Z">hello</time>`)
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<link rel="alternate" type="text/board+html" href="/boards/%s">`, keyPair.PublicKey)
+	})
+	mux.HandleFunc("/boards/"+keyPair.PublicKey, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Spring-Signature", keyPair.SignHex([]byte("different content")))
+		w.Write(content)
+	})
+
+	server := httptest.NewServer(&mux)
+	defer server.Close()
+
+	c := NewCrawler(Options{PerHostInterval: time.Millisecond, Now: stableTimeFunc})
+
+	report, err := c.Crawl(context.Background(), server.URL+"/")
+	require.NoError(t, err)
+
+	require.Len(t, report.Boards, 1)
+	require.False(t, report.Boards[0].Verified)
+	require.NotEmpty(t, report.Boards[0].Error)
+	require.Equal(t, 1, report.Stats.Rejected)
+}
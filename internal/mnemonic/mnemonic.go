@@ -0,0 +1,166 @@
+// Package mnemonic encodes and decodes raw key material as BIP39-style
+// mnemonic phrases: sequences of words drawn from a fixed wordlist, which are
+// far easier to transcribe and recover by hand than a long hex string.
+package mnemonic
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"math/big"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+//go:embed wordlist.txt
+var wordlistRaw string
+
+// wordlist is the fixed set of words that phrases are built from. A word's
+// position in the list is its 11-bit index.
+var wordlist = strings.Split(strings.TrimSpace(wordlistRaw), "\n")
+
+// wordIndex maps a word back to its position in wordlist for decoding.
+var wordIndex = buildWordIndex()
+
+func buildWordIndex() map[string]int {
+	index := make(map[string]int, len(wordlist))
+	for i, word := range wordlist {
+		index[word] = i
+	}
+	return index
+}
+
+const (
+	// Number of words in a phrase encoding just an Ed25519 seed.
+	seedWords = 24
+
+	// Number of words in a phrase encoding an Ed25519 seed and public key
+	// together.
+	seedAndPublicKeyWords = 48
+)
+
+var (
+	ErrInvalidChecksum = xerrors.New("mnemonic's checksum doesn't match; it may have been transcribed incorrectly")
+	ErrInvalidWord     = xerrors.New("mnemonic contains a word that's not in the wordlist")
+	ErrWrongWordCount  = xerrors.New("mnemonic has the wrong number of words")
+)
+
+// Encode converts a 32-byte Ed25519 seed into a 24-word mnemonic phrase. The
+// seed's 256 bits are padded with an 8-bit checksum derived from its SHA-256
+// hash (so that a mistyped phrase is very likely to be caught on decode),
+// then the resulting 264 bits are split into twenty-four 11-bit indices into
+// wordlist.
+func Encode(seed []byte) (string, error) {
+	if len(seed) != ed25519.SeedSize {
+		return "", xerrors.Errorf("seed's length is %d, but should be %d", len(seed), ed25519.SeedSize)
+	}
+
+	return encode(seed, seedWords)
+}
+
+// Decode reverses Encode, returning the original 32-byte seed (suitable for
+// ed25519.NewKeyFromSeed) after verifying that phrase's words are all valid
+// and that its checksum matches.
+func Decode(phrase string) ([]byte, error) {
+	return decode(phrase, seedWords, ed25519.SeedSize)
+}
+
+// EncodeSeedAndPublicKey is like Encode, but encodes an Ed25519 seed and
+// public key together into a single 48-word phrase. This is useful for
+// Spring '83, where a conforming key's public portion carries a
+// brute-forced expiry suffix that can't be reconstructed from the seed
+// alone without redoing the search, so a single phrase that restores both
+// is more useful than two separate ones.
+func EncodeSeedAndPublicKey(seed, publicKey []byte) (string, error) {
+	if len(seed) != ed25519.SeedSize {
+		return "", xerrors.Errorf("seed's length is %d, but should be %d", len(seed), ed25519.SeedSize)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return "", xerrors.Errorf("public key's length is %d, but should be %d", len(publicKey), ed25519.PublicKeySize)
+	}
+
+	combined := make([]byte, 0, len(seed)+len(publicKey))
+	combined = append(combined, seed...)
+	combined = append(combined, publicKey...)
+
+	return encode(combined, seedAndPublicKeyWords)
+}
+
+// DecodeSeedAndPublicKey reverses EncodeSeedAndPublicKey, returning the
+// original seed and public key.
+func DecodeSeedAndPublicKey(phrase string) (seed, publicKey []byte, err error) {
+	combined, err := decode(phrase, seedAndPublicKeyWords, ed25519.SeedSize+ed25519.PublicKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return combined[:ed25519.SeedSize], combined[ed25519.SeedSize:], nil
+}
+
+// encode packs data plus a checksum derived from its SHA-256 hash into
+// wordCount words of 11 bits apiece.
+func encode(data []byte, wordCount int) (string, error) {
+	checksumBits := wordCount*11 - len(data)*8
+	if checksumBits <= 0 || checksumBits > 24 {
+		return "", xerrors.Errorf("%d bytes can't be encoded into %d words", len(data), wordCount)
+	}
+
+	value := new(big.Int).SetBytes(data)
+	value.Lsh(value, uint(checksumBits))
+	value.Or(value, big.NewInt(int64(checksum(data, checksumBits))))
+
+	words := make([]string, wordCount)
+	mask := big.NewInt(1<<11 - 1)
+	for i := wordCount - 1; i >= 0; i-- {
+		words[i] = wordlist[new(big.Int).And(value, mask).Int64()]
+		value.Rsh(value, 11)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// decode reverses encode, verifying that phrase has wordCount valid words and
+// that its checksum matches, and returning the first dataLen bytes it
+// packed.
+func decode(phrase string, wordCount, dataLen int) ([]byte, error) {
+	checksumBits := wordCount*11 - dataLen*8
+	if checksumBits <= 0 || checksumBits > 24 {
+		return nil, xerrors.Errorf("%d bytes can't be decoded from %d words", dataLen, wordCount)
+	}
+
+	words := strings.Fields(phrase)
+	if len(words) != wordCount {
+		return nil, ErrWrongWordCount
+	}
+
+	value := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, ErrInvalidWord
+		}
+
+		value.Lsh(value, 11)
+		value.Or(value, big.NewInt(int64(index)))
+	}
+
+	wantChecksum := new(big.Int).And(value, big.NewInt(1<<uint(checksumBits)-1)).Int64()
+
+	data := new(big.Int).Rsh(value, uint(checksumBits))
+	dataBytes := data.FillBytes(make([]byte, dataLen))
+
+	if int64(checksum(dataBytes, checksumBits)) != wantChecksum {
+		return nil, ErrInvalidChecksum
+	}
+
+	return dataBytes, nil
+}
+
+// checksum returns the top n bits (n <= 24) of data's SHA-256 hash,
+// interpreted as a big-endian integer.
+func checksum(data []byte, n int) uint32 {
+	hash := sha256.Sum256(data)
+	v := uint32(hash[0])<<16 | uint32(hash[1])<<8 | uint32(hash[2])
+	return v >> uint(24-n)
+}
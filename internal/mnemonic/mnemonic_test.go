@@ -0,0 +1,81 @@
+package mnemonic
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		_, seed, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		phrase, err := Encode(seed.Seed())
+		require.NoError(t, err)
+		require.Len(t, strings.Fields(phrase), seedWords)
+
+		decoded, err := Decode(phrase)
+		require.NoError(t, err)
+		require.Equal(t, []byte(seed.Seed()), decoded)
+	})
+
+	t.Run("WrongSeedLength", func(t *testing.T) {
+		_, err := Encode([]byte{0x01, 0x02})
+		require.Error(t, err)
+	})
+
+	t.Run("WrongWordCount", func(t *testing.T) {
+		_, err := Decode("abandon abandon abandon")
+		require.ErrorIs(t, err, ErrWrongWordCount)
+	})
+
+	t.Run("InvalidWord", func(t *testing.T) {
+		phrase := strings.Repeat(wordlist[0]+" ", seedWords-1) + "notarealword"
+
+		_, err := Decode(phrase)
+		require.ErrorIs(t, err, ErrInvalidWord)
+	})
+
+	t.Run("InvalidChecksum", func(t *testing.T) {
+		_, seed, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		phrase, err := Encode(seed.Seed())
+		require.NoError(t, err)
+
+		words := strings.Fields(phrase)
+		lastIndex := wordIndex[words[len(words)-1]]
+		words[len(words)-1] = wordlist[(lastIndex+1)%len(wordlist)]
+
+		_, err = Decode(strings.Join(words, " "))
+		require.ErrorIs(t, err, ErrInvalidChecksum)
+	})
+}
+
+func TestEncodeDecodeSeedAndPublicKey(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		phrase, err := EncodeSeedAndPublicKey(privateKey.Seed(), publicKey)
+		require.NoError(t, err)
+		require.Len(t, strings.Fields(phrase), seedAndPublicKeyWords)
+
+		decodedSeed, decodedPublicKey, err := DecodeSeedAndPublicKey(phrase)
+		require.NoError(t, err)
+		require.Equal(t, []byte(privateKey.Seed()), decodedSeed)
+		require.Equal(t, []byte(publicKey), decodedPublicKey)
+	})
+
+	t.Run("WrongPublicKeyLength", func(t *testing.T) {
+		_, seed, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		_, err = EncodeSeedAndPublicKey(seed.Seed(), []byte{0x01})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,211 @@
+// Package nsclient implements the client half of the Spring '83 wire
+// protocol: publishing a signed board to a host and fetching one back,
+// verifying its signature and embedded timestamp along the way. It's used by
+// `neospring publish`/`neospring get`, but is its own package so that
+// third-party Go code can embed the same logic without shelling out to the
+// CLI.
+package nsclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+// MaxContentSize mirrors the server's own limit: the Spring '83 spec caps a
+// board post at 2217 bytes, the size of the internet's first ever web page.
+const MaxContentSize = 2217
+
+// timestampFormat is the layout of the `<time datetime="...">` tag every
+// board is expected to embed.
+const timestampFormat = "2006-01-02T15:04:05Z"
+
+// timestampRE extracts a board's embedded timestamp, mirroring the regex the
+// server itself uses to parse one out of a PUT body.
+var timestampRE = regexp.MustCompile(`<time datetime="([1-9]\d{3}-(0[1-9]|1[0-2])-\d\dT\d\d:\d\d:\d\dZ)">`)
+
+var (
+	// ErrTimestampMissing is returned when board content doesn't contain a
+	// `<time datetime="...">` tag at all.
+	ErrTimestampMissing = xerrors.New("content has no <time datetime> tag")
+
+	// ErrSignatureInvalid is returned when a board's signature doesn't
+	// verify against its content under the given public key.
+	ErrSignatureInvalid = xerrors.New("signature is invalid for the given content and key")
+
+	// ErrTimestampMismatch is returned when a board's embedded <time
+	// datetime> tag doesn't agree with the Last-Modified header the host
+	// served alongside it.
+	ErrTimestampMismatch = xerrors.New("embedded <time datetime> does not match the Last-Modified header")
+
+	// ErrNotModified is returned by Get when the host reports the board
+	// hasn't changed since the If-Modified-Since time the caller passed in.
+	ErrNotModified = xerrors.New("board not modified since the given time")
+)
+
+// Client publishes boards to, and fetches them from, any Spring '83 host
+// over its standard HTTP endpoints.
+type Client struct {
+	httpClient *http.Client
+	timeNow    func() time.Time
+}
+
+// NewClient returns a Client that talks to hosts with a reasonable default
+// timeout. Pass httpClient to use your own (for example, to reuse
+// connections, or to inject a test transport); nil selects the default.
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Client{httpClient: httpClient, timeNow: time.Now}
+}
+
+// SetTimeNow overrides the time Client considers "now" when checking a
+// fetched key's validity. For testing purposes only.
+func (c *Client) SetTimeNow(timeNow func() time.Time) {
+	c.timeNow = timeNow
+}
+
+// Publish signs content with keyPair and PUTs it to host. ifUnmodifiedSince,
+// if non-zero, is sent as an `If-Unmodified-Since` header so a host that
+// supports conditional PUTs can reject the write if it has something newer
+// than the caller last saw -- belt and suspenders alongside the embedded
+// <time datetime> tag the wire protocol already uses for the same purpose.
+func (c *Client) Publish(ctx context.Context, host string, keyPair *nskey.KeyPair, content []byte, ifUnmodifiedSince time.Time) error {
+	if len(content) > MaxContentSize {
+		return xerrors.Errorf("content is %d bytes, which exceeds the maximum of %d", len(content), MaxContentSize)
+	}
+
+	if !timestampRE.Match(content) {
+		return ErrTimestampMissing
+	}
+
+	signature := keyPair.Sign(content)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, host+"/"+keyPair.PublicKey, bytes.NewReader(content))
+	if err != nil {
+		return xerrors.Errorf("error building put request: %w", err)
+	}
+	req.Header.Set("Spring-Signature", hex.EncodeToString(signature))
+	if !ifUnmodifiedSince.IsZero() {
+		req.Header.Set("If-Unmodified-Since", ifUnmodifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("error making put request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return xerrors.Errorf("error reading put response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("host %q responded to put with status %d: %s", host, resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// Get fetches key from host, returning ErrNotModified if ifModifiedSince is
+// non-zero and the host reports nothing newer. On success, the returned
+// board's signature has already been verified against its content, and its
+// embedded <time datetime> tag checked against the Last-Modified header --
+// callers never see an unverified board.
+func (c *Client) Get(ctx context.Context, host, key string, ifModifiedSince time.Time) (*nsstore.Board, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/"+key, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("error building get request: %w", err)
+	}
+	if !ifModifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", ifModifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("error making get request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nsstore.ErrKeyNotFound
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("host %q responded to get with unexpected status %d", host, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading get response body: %w", err)
+	}
+
+	lastModified, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing host's Last-Modified header: %w", err)
+	}
+
+	board := &nsstore.Board{
+		Content:   content,
+		Signature: resp.Header.Get("Spring-Signature"),
+		Timestamp: lastModified,
+	}
+
+	if err := Verify(key, board, c.timeNow()); err != nil {
+		return nil, err
+	}
+
+	return board, nil
+}
+
+// Verify checks that board's signature is valid for key and content, and
+// that its embedded <time datetime> tag agrees with its Timestamp (normally
+// populated from a Last-Modified header). now is used only to confirm key is
+// currently a live Spring '83 key. It's exported separately from Get so that
+// a board obtained some other way (e.g. from a store, or a federation peer)
+// can be held to the same standard.
+func Verify(key string, board *nsstore.Board, now time.Time) error {
+	sig, err := hex.DecodeString(board.Signature)
+	if err != nil {
+		return xerrors.Errorf("error decoding signature: %w", err)
+	}
+
+	parsedKey, err := nskey.ParseKey(key, now)
+	if err != nil {
+		return xerrors.Errorf("error parsing key: %w", err)
+	}
+
+	if !parsedKey.Verify(board.Content, sig) {
+		return ErrSignatureInvalid
+	}
+
+	match := timestampRE.FindSubmatch(board.Content)
+	if match == nil {
+		return ErrTimestampMissing
+	}
+
+	embeddedTimestamp, err := time.Parse(timestampFormat, string(match[1]))
+	if err != nil {
+		return xerrors.Errorf("error parsing embedded timestamp: %w", err)
+	}
+
+	if !embeddedTimestamp.Equal(board.Timestamp) {
+		return ErrTimestampMismatch
+	}
+
+	return nil
+}
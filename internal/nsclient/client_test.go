@@ -0,0 +1,130 @@
+package nsclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+const samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+
+// stableTime falls within samplePrivateKey's validity window, so tests don't
+// depend on the wall-clock date.
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+func boardContent(timestamp time.Time) []byte {
+	return []byte(fmt.Sprintf(`<time datetime="%s">hello</time>`, timestamp.UTC().Format(timestampFormat)))
+}
+
+func TestClientPublish(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := boardContent(stableTime)
+
+	var gotSignature string
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/"+keyPair.PublicKey, r.URL.Path)
+		gotSignature = r.Header.Get("Spring-Signature")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer host.Close()
+
+	client := NewClient(nil)
+	err := client.Publish(context.Background(), host.URL, keyPair, content, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, keyPair.SignHex(content), gotSignature)
+}
+
+func TestClientPublishContentTooLarge(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := make([]byte, MaxContentSize+1)
+
+	client := NewClient(nil)
+	err := client.Publish(context.Background(), "http://unused.example.com", keyPair, content, time.Time{})
+	require.Error(t, err)
+}
+
+func TestClientPublishMissingTimestamp(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+	client := NewClient(nil)
+	err := client.Publish(context.Background(), "http://unused.example.com", keyPair, []byte("no timestamp here"), time.Time{})
+	require.ErrorIs(t, err, ErrTimestampMissing)
+}
+
+func TestClientGet(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := boardContent(stableTime)
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Spring-Signature", keyPair.SignHex(content))
+		w.Header().Set("Last-Modified", stableTime.UTC().Format(http.TimeFormat))
+		_, _ = w.Write(content)
+	}))
+	defer host.Close()
+
+	client := NewClient(nil)
+	client.SetTimeNow(func() time.Time { return stableTime })
+
+	board, err := client.Get(context.Background(), host.URL, keyPair.PublicKey, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, content, board.Content)
+	require.True(t, stableTime.Equal(board.Timestamp))
+}
+
+func TestClientGetRejectsBadSignature(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := boardContent(stableTime)
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Spring-Signature", keyPair.SignHex([]byte("different content")))
+		w.Header().Set("Last-Modified", stableTime.UTC().Format(http.TimeFormat))
+		_, _ = w.Write(content)
+	}))
+	defer host.Close()
+
+	client := NewClient(nil)
+	client.SetTimeNow(func() time.Time { return stableTime })
+
+	_, err := client.Get(context.Background(), host.URL, keyPair.PublicKey, time.Time{})
+	require.ErrorIs(t, err, ErrSignatureInvalid)
+}
+
+func TestClientGetRejectsTimestampMismatch(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	content := boardContent(stableTime)
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Spring-Signature", keyPair.SignHex(content))
+		w.Header().Set("Last-Modified", stableTime.Add(1*time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write(content)
+	}))
+	defer host.Close()
+
+	client := NewClient(nil)
+	client.SetTimeNow(func() time.Time { return stableTime })
+
+	_, err := client.Get(context.Background(), host.URL, keyPair.PublicKey, time.Time{})
+	require.ErrorIs(t, err, ErrTimestampMismatch)
+}
+
+func TestClientGetNotFound(t *testing.T) {
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer host.Close()
+
+	client := NewClient(nil)
+	_, err := client.Get(context.Background(), host.URL, keyPair.PublicKey, time.Time{})
+	require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+}
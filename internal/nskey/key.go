@@ -1,19 +1,35 @@
 package nskey
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"encoding/hex"
+	"math"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/mnemonic"
 )
 
 const (
 	// The maximum valid lifetime of a key as dictated by the Spring '83
 	// specification.
 	MaxLifetime = 2 * 365 * 24 * time.Hour
+
+	// keySuffixLen is the length of a key's `83eMMYY` expiry suffix, which
+	// difficulty checks exclude from the "key body" they examine for leading
+	// or trailing hex zeros.
+	keySuffixLen = len("83e0122")
+
+	// DifficultyMaxZeros is the number of leading or trailing hex zeros a key
+	// body must carry at a difficulty factor of 1.0 (i.e. a realm that's
+	// completely full). Bounds the brute-force cost required to mint a key
+	// even if a caller passes a difficulty factor above 1.
+	DifficultyMaxZeros = 8
 )
 
 // Test private/public keypair defined by the Spring '83 specification. Attempts
@@ -28,6 +44,8 @@ var (
 	ErrKeyExpired     = xerrors.New("key is expired")
 	ErrKeyInvalid     = xerrors.New("key is invalid")
 	ErrKeyNotYetValid = xerrors.New("key is not yet valid")
+
+	ErrSuccessorSignatureInvalid = xerrors.New("successor attestation signature is invalid")
 )
 
 // See: https://github.com/robinsloan/spring-83/blob/main/draft-20220629.md#key-format
@@ -53,9 +71,88 @@ func KeyFromRaw(publicKey ed25519.PublicKey) *Key {
 // requirements imposed by the spec. A key is the public portion of an Ed25519
 // keypair encoded as hex.
 func ParseKey(key string, now time.Time) (*Key, error) {
+	expiresAt, expiryMonth, err := keyExpiry(key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if now.After(expiresAt) {
+		return nil, ErrKeyExpired
+	}
+
+	validAt := expiryMonth.Add(-MaxLifetime)
+	if validAt.After(now) {
+		return nil, ErrKeyNotYetValid
+	}
+
+	return parseKeyUnchecked(key)
+}
+
+// MeetsDifficulty reports whether key's body (everything except its
+// `83eMMYY` expiry suffix) carries enough leading or trailing hex zeros to
+// satisfy difficultyFactor. Assumes key is already known to be
+// well-formed -- callers combining this with ParseKey should check format
+// first.
+func MeetsDifficulty(key string, difficultyFactor float64) bool {
+	requiredZeros := RequiredZerosForDifficulty(difficultyFactor)
+	if requiredZeros == 0 {
+		return true
+	}
+
+	body := key
+	if len(body) > keySuffixLen {
+		body = body[:len(body)-keySuffixLen]
+	}
+
+	return leadingZeros(body) >= requiredZeros || trailingZeros(body) >= requiredZeros
+}
+
+// RequiredZerosForDifficulty translates a difficulty factor in [0, 1] (as
+// computed from a realm's current fullness) into the number of leading or
+// trailing hex zeros a new key's body must carry to be accepted.
+func RequiredZerosForDifficulty(difficultyFactor float64) int {
+	switch {
+	case difficultyFactor <= 0:
+		return 0
+	case difficultyFactor >= 1:
+		return DifficultyMaxZeros
+	default:
+		return int(math.Round(difficultyFactor * DifficultyMaxZeros))
+	}
+}
+
+func leadingZeros(s string) int {
+	var n int
+	for n < len(s) && s[n] == '0' {
+		n++
+	}
+	return n
+}
+
+func trailingZeros(s string) int {
+	var n int
+	for n < len(s) && s[len(s)-1-n] == '0' {
+		n++
+	}
+	return n
+}
+
+// KeyExpiry returns the time at which key's validity lapses, based on the
+// expiry month/year encoded in its last four digits, regardless of whether
+// it's otherwise currently valid (or even currently expired). Returns
+// ErrKeyInvalid if key isn't formatted like a Spring '83 key to begin with.
+func KeyExpiry(key string, now time.Time) (time.Time, error) {
+	expiresAt, _, err := keyExpiry(key, now)
+	return expiresAt, err
+}
+
+// keyExpiry is the shared implementation behind ParseKey and KeyExpiry,
+// returning both the last instant key is valid and the first of the month it
+// expires in (the latter needed by ParseKey to check MaxLifetime).
+func keyExpiry(key string, now time.Time) (expiresAt, expiryMonth time.Time, err error) {
 	matches := keyRE.FindAllStringSubmatch(key, 1)
 	if matches == nil {
-		return nil, ErrKeyInvalid
+		return time.Time{}, time.Time{}, ErrKeyInvalid
 	}
 
 	monthStr, yearStr := matches[0][1], matches[0][2]
@@ -65,22 +162,14 @@ func ParseKey(key string, now time.Time) (*Key, error) {
 	century := now.Year() / 100 * 100
 	year += century
 
-	expiryMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	expiryMonth = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 
 	// Add a month, then subtract down by a second to get the last second of the
 	// target month we're looking for, which will be considered the last valid
 	// time for a key.
-	expiresAt := relativeMonth(expiryMonth, 1).Add(-1 * time.Second)
-	if now.After(expiresAt) {
-		return nil, ErrKeyExpired
-	}
+	expiresAt = relativeMonth(expiryMonth, 1).Add(-1 * time.Second)
 
-	validAt := expiryMonth.Add(-MaxLifetime)
-	if validAt.After(now) {
-		return nil, ErrKeyNotYetValid
-	}
-
-	return parseKeyUnchecked(key)
+	return expiresAt, expiryMonth, nil
 }
 
 func parseKeyUnchecked(publicKey string) (*Key, error) {
@@ -148,6 +237,86 @@ func MustParseKeyPairUnchecked(privateKey string) *KeyPair {
 	return keyPair
 }
 
+// Mnemonic encodes the keypair's private key as a 24-word mnemonic phrase,
+// suitable for backup in place of its 64-character hex representation. It
+// doesn't encode the public key, so restoring from it with
+// ParseKeyPairFromMnemonic requires supplying the public key separately; use
+// FullMnemonic if that's not convenient.
+func (kp *KeyPair) Mnemonic() (string, error) {
+	seedBytes, err := hex.DecodeString(kp.PrivateKey)
+	if err != nil {
+		return "", xerrors.Errorf("error decoding private key: %w", err)
+	}
+
+	return mnemonic.Encode(seedBytes)
+}
+
+// FullMnemonic is like Mnemonic, but also encodes the keypair's public key,
+// producing a longer 48-word phrase that alone is enough to restore the
+// keypair via ParseKeyPairFromFullMnemonic. This is useful for Spring '83,
+// where the public key carries a brute-forced expiry suffix that's
+// impractical to reconstruct from the private key alone.
+func (kp *KeyPair) FullMnemonic() (string, error) {
+	seedBytes, err := hex.DecodeString(kp.PrivateKey)
+	if err != nil {
+		return "", xerrors.Errorf("error decoding private key: %w", err)
+	}
+
+	return mnemonic.EncodeSeedAndPublicKey(seedBytes, kp.publicKeyBytes)
+}
+
+// ParseKeyPairFromMnemonic reconstructs a keypair from a 24-word mnemonic
+// phrase produced by Mnemonic, combined with the keypair's public key, which
+// Mnemonic doesn't encode. The derived private key is checked against
+// publicKey to catch a mistyped or mismatched phrase.
+func ParseKeyPairFromMnemonic(phrase, publicKey string) (*KeyPair, error) {
+	seedBytes, err := mnemonic.Decode(phrase)
+	if err != nil {
+		return nil, xerrors.Errorf("error decoding mnemonic: %w", err)
+	}
+
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing public key: %w", err)
+	}
+
+	return keyPairFromSeedAndPublicKey(seedBytes, publicKeyBytes)
+}
+
+// ParseKeyPairFromFullMnemonic reconstructs a keypair from a 48-word mnemonic
+// phrase produced by FullMnemonic, which unlike Mnemonic encodes the public
+// key as well, so it doesn't need to be supplied separately.
+func ParseKeyPairFromFullMnemonic(phrase string) (*KeyPair, error) {
+	seedBytes, publicKeyBytes, err := mnemonic.DecodeSeedAndPublicKey(phrase)
+	if err != nil {
+		return nil, xerrors.Errorf("error decoding mnemonic: %w", err)
+	}
+
+	return keyPairFromSeedAndPublicKey(seedBytes, publicKeyBytes)
+}
+
+func keyPairFromSeedAndPublicKey(seedBytes, publicKeyBytes []byte) (*KeyPair, error) {
+	privateKeyBytes := ed25519.NewKeyFromSeed(seedBytes)
+
+	if !bytes.Equal(privateKeyBytes.Public().(ed25519.PublicKey), publicKeyBytes) {
+		return nil, xerrors.Errorf("public key doesn't match the key derived from the mnemonic's private key")
+	}
+
+	return &KeyPair{*KeyFromRaw(publicKeyBytes), hex.EncodeToString(seedBytes), privateKeyBytes}, nil
+}
+
+// Signer is implemented by anything that can produce an Ed25519 signature
+// over message, the way KeyPair does with its in-process private key. It
+// exists so that callers that only ever need to sign (nstranslog's tree
+// head signing, in particular) can be handed something backed by a remote
+// signer -- e.g. nsvault's Transit-backed signer, which never materializes
+// the private key in this process -- instead of a concrete KeyPair.
+type Signer interface {
+	Sign(message []byte) []byte
+}
+
+var _ Signer = (*KeyPair)(nil)
+
 func (kp *KeyPair) Sign(message []byte) []byte {
 	return ed25519.Sign(kp.privateKeyBytes, message)
 }
@@ -156,6 +325,51 @@ func (kp *KeyPair) SignHex(message []byte) string {
 	return hex.EncodeToString(kp.Sign(message))
 }
 
+// SignSuccessor produces a signed attestation, under kp's private key, that
+// next is kp's successor key, effective for the [notBefore, notAfter]
+// window. A client that encounters kp's board near (or past) expiry can
+// verify this attestation to discover it should follow next instead,
+// without needing any out-of-band signal.
+func (kp *KeyPair) SignSuccessor(next *KeyPair, notBefore, notAfter time.Time) ([]byte, error) {
+	if next == nil {
+		return nil, xerrors.Errorf("next keypair is required")
+	}
+
+	return kp.Sign(successorPayload(kp.PublicKey, next.PublicKey, notBefore, notAfter)), nil
+}
+
+// VerifySuccessor checks a signature produced by SignSuccessor, confirming
+// that oldPublicKey's owner attested to newPublicKey being its successor for
+// the given [notBefore, notAfter] window.
+func VerifySuccessor(oldPublicKey, newPublicKey string, notBefore, notAfter time.Time, sig []byte) error {
+	oldKey, err := parseKeyUnchecked(oldPublicKey)
+	if err != nil {
+		return xerrors.Errorf("error parsing old public key: %w", err)
+	}
+
+	if len(sig) != ed25519.SignatureSize {
+		return xerrors.Errorf("signature's length is %d, but should be %d", len(sig), ed25519.SignatureSize)
+	}
+
+	if !oldKey.Verify(successorPayload(oldPublicKey, newPublicKey, notBefore, notAfter), sig) {
+		return ErrSuccessorSignatureInvalid
+	}
+
+	return nil
+}
+
+// successorPayload builds the canonical payload signed by SignSuccessor and
+// checked by VerifySuccessor.
+func successorPayload(oldPublicKey, newPublicKey string, notBefore, notAfter time.Time) []byte {
+	return []byte(strings.Join([]string{
+		"spring83-successor",
+		oldPublicKey,
+		newPublicKey,
+		notBefore.UTC().Format(time.RFC3339),
+		notAfter.UTC().Format(time.RFC3339),
+	}, "\n"))
+}
+
 func relativeMonth(t time.Time, relativeMonths int) time.Time {
 	year, month := t.Year(), t.Month()
 
@@ -4,6 +4,7 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,14 +18,14 @@ const (
 
 func TestParseKeyPair(t *testing.T) {
 	t.Run("GoGenerated", func(t *testing.T) {
-		keyPair, err := ParseKeyPair(samplePrivateKey, samplePublicKey)
+		keyPair, err := ParseKeyPairUnchecked(samplePrivateKey)
 		require.NoError(t, err)
 		require.Equal(t, samplePrivateKey, keyPair.PrivateKey)
 		require.Equal(t, samplePublicKey, keyPair.PublicKey)
 	})
 
 	t.Run("TestKeyPair", func(t *testing.T) {
-		keyPair, err := ParseKeyPair(TestPrivateKey, TestPublicKey)
+		keyPair, err := ParseKeyPairUnchecked(TestPrivateKey)
 		require.NoError(t, err)
 		require.Equal(t, TestPrivateKey, keyPair.PrivateKey)
 		require.Equal(t, TestPublicKey, keyPair.PublicKey)
@@ -45,7 +46,7 @@ func TestKeyPairRoundTrip(t *testing.T) {
 	message := "this is a message that will be signed"
 
 	t.Run("GoGenerated", func(t *testing.T) {
-		keyPair, err := ParseKeyPair(samplePrivateKey, samplePublicKey)
+		keyPair, err := ParseKeyPairUnchecked(samplePrivateKey)
 		require.NoError(t, err)
 
 		sig := keyPair.Sign([]byte(message))
@@ -53,7 +54,7 @@ func TestKeyPairRoundTrip(t *testing.T) {
 	})
 
 	t.Run("TestKeyPair", func(t *testing.T) {
-		keyPair, err := ParseKeyPair(TestPrivateKey, TestPublicKey)
+		keyPair, err := ParseKeyPairUnchecked(TestPrivateKey)
 		require.NoError(t, err)
 
 		sig := keyPair.Sign([]byte(message))
@@ -105,3 +106,67 @@ func TestParseKey(t *testing.T) {
 		require.ErrorIs(t, err, ErrKeyNotYetValid)
 	})
 }
+
+func TestRequiredZerosForDifficulty(t *testing.T) {
+	require.Equal(t, 0, RequiredZerosForDifficulty(0))
+	require.Equal(t, 0, RequiredZerosForDifficulty(-1))
+	require.Equal(t, DifficultyMaxZeros, RequiredZerosForDifficulty(1))
+	require.Equal(t, DifficultyMaxZeros, RequiredZerosForDifficulty(2))
+	require.Equal(t, 4, RequiredZerosForDifficulty(0.5))
+}
+
+func TestMeetsDifficulty(t *testing.T) {
+	const suffix = "83e1124"
+	body := samplePublicKey[:len(samplePublicKey)-len(suffix)]
+
+	leadingZerosKey := strings.Repeat("0", 4) + body[4:] + suffix
+	trailingZerosKey := body[:len(body)-4] + strings.Repeat("0", 4) + suffix
+
+	require.True(t, MeetsDifficulty(leadingZerosKey, 0.5))
+	require.True(t, MeetsDifficulty(trailingZerosKey, 0.5))
+	require.False(t, MeetsDifficulty(samplePublicKey, 0.5))
+	require.True(t, MeetsDifficulty(samplePublicKey, 0))
+}
+
+func TestSignVerifySuccessor(t *testing.T) {
+	notBefore := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		oldKeyPair, err := ParseKeyPairUnchecked(samplePrivateKey)
+		require.NoError(t, err)
+
+		_, newPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		newKeyPair := KeyPairFromRaw(newPrivateKey)
+
+		sig, err := oldKeyPair.SignSuccessor(newKeyPair, notBefore, notAfter)
+		require.NoError(t, err)
+
+		err = VerifySuccessor(oldKeyPair.PublicKey, newKeyPair.PublicKey, notBefore, notAfter, sig)
+		require.NoError(t, err)
+	})
+
+	t.Run("TamperedWindow", func(t *testing.T) {
+		oldKeyPair, err := ParseKeyPairUnchecked(samplePrivateKey)
+		require.NoError(t, err)
+
+		_, newPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		newKeyPair := KeyPairFromRaw(newPrivateKey)
+
+		sig, err := oldKeyPair.SignSuccessor(newKeyPair, notBefore, notAfter)
+		require.NoError(t, err)
+
+		err = VerifySuccessor(oldKeyPair.PublicKey, newKeyPair.PublicKey, notBefore.Add(time.Hour), notAfter, sig)
+		require.ErrorIs(t, err, ErrSuccessorSignatureInvalid)
+	})
+
+	t.Run("NilNext", func(t *testing.T) {
+		oldKeyPair, err := ParseKeyPairUnchecked(samplePrivateKey)
+		require.NoError(t, err)
+
+		_, err = oldKeyPair.SignSuccessor(nil, notBefore, notAfter)
+		require.Error(t, err)
+	})
+}
@@ -5,11 +5,16 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha512"
 	"encoding/hex"
+	"io"
+	"math/big"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"filippo.io/edwards25519"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
 )
@@ -20,6 +25,25 @@ const (
 
 	// Spring '83 keys have a maximum expiry age of two years.
 	validKeyAge = 2 * 365 * 24 * time.Hour
+
+	// specSuffixHexChars is the number of hex characters in the `83eMMYY`
+	// suffix that the Spring '83 spec always requires of a key, regardless
+	// of any additional vanity constraints given in GenerateOptions.
+	specSuffixHexChars = 7
+
+	// publicKeyHexChars is the number of hex characters in a Spring '83
+	// public key.
+	publicKeyHexChars = ed25519.PublicKeySize * 2
+
+	// progressInterval is how often GenerateOptions.Progress is invoked
+	// while a search is running.
+	progressInterval = 1 * time.Second
+
+	// seedBatchBytes is how much random seed material each worker pulls from
+	// its Reader at a time, amortizing the syscall/CSPRNG overhead of
+	// crypto/rand.Read over many candidate keys instead of paying it once
+	// per ed25519.GenerateKey call.
+	seedBatchBytes = 64 * 1024
 )
 
 type ed25519KeyPair struct {
@@ -30,71 +54,217 @@ type ed25519KeyPair struct {
 func (p *ed25519KeyPair) PrivateKeyHex() string { return hex.EncodeToString(p.PrivateKey.Seed()) }
 func (p *ed25519KeyPair) PublicKeyHex() string  { return hex.EncodeToString(p.PublicKey) }
 
+// GenerateOptions customizes a key search beyond the expiry-month suffix
+// that's always required of a Spring '83 key (see GenerateConformingKey).
+type GenerateOptions struct {
+	// Suffix is additional hex appended immediately before the required
+	// expiry suffix, letting a caller target a vanity public key like
+	// "...cafe83e0627" by setting Suffix to "cafe". Optional.
+	Suffix string
+
+	// Prefix optionally constrains the leading hex characters of the
+	// generated public key. Optional.
+	Prefix string
+
+	// Reader supplies randomness for key generation. Defaults to
+	// crypto/rand.Reader; overridable so a search can be run
+	// deterministically in tests.
+	Reader io.Reader
+
+	// Progress, if set, is invoked periodically from a dedicated goroutine
+	// with the number of keys generated so far and the time elapsed, so a
+	// caller like the CLI can render a rate or ETA.
+	Progress func(iterations int64, elapsed time.Duration)
+}
+
+// EstimateDifficulty returns the expected number of keys that must be
+// generated to satisfy opts, assuming a uniform random distribution: each
+// additional hex character constrained narrows the search by a further
+// factor of 16, on top of the 7 characters always required by a key's
+// expiry suffix.
+func EstimateDifficulty(opts GenerateOptions) *big.Float {
+	hexChars := len(opts.Suffix) + len(opts.Prefix) + specSuffixHexChars
+	iterations := new(big.Int).Exp(big.NewInt(16), big.NewInt(int64(hexChars)), nil)
+	return new(big.Float).SetInt(iterations)
+}
+
+// KeysPerSecond computes a throughput figure from a completed (or
+// in-progress) search's iteration count and elapsed wall time, so callers
+// like the CLI's progress printer and BenchmarkGenerateConformingKeyWithSuffix
+// can report a consistent rate.
+func KeysPerSecond(totalIterations int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(totalIterations) / elapsed.Seconds()
+}
+
 // GenerateConformingKey runs a parallel search for an Ed25519 key that expires
-// in the same month as `expiryMonth`. Generally speaking, `expiryMonth` should
-// target two years from the current month, which is the maximum validity period
-// of a Spring '83 key.
-// portion has the given target suffix.
-func GenerateConformingKey(ctx context.Context, expiryMonth time.Time) (*ed25519KeyPair, int, error) {
-	return generateConformingKeyWithSuffix(ctx, keySuffixWithExpiry(expiryMonth))
+// in the same month as `expiryMonth`, additionally satisfying any vanity
+// suffix/prefix constraints given in opts. Generally speaking, `expiryMonth`
+// should target two years from the current month, which is the maximum
+// validity period of a Spring '83 key.
+func GenerateConformingKey(ctx context.Context, expiryMonth time.Time, opts GenerateOptions) (*ed25519KeyPair, int, error) {
+	suffix := opts.Suffix + keySuffixWithExpiry(expiryMonth)
+
+	if len(suffix)+len(opts.Prefix) > publicKeyHexChars {
+		return nil, 0, xerrors.Errorf(
+			"combined suffix (%d hex chars, including the required expiry suffix) and prefix (%d hex chars) "+
+				"can't both be satisfied by a %d hex character public key",
+			len(suffix), len(opts.Prefix), publicKeyHexChars)
+	}
+
+	return generateConformingKeyWithSuffix(ctx, suffix, opts)
 }
 
 // Same as above, but specifically targets the given hex-encoded suffix. This
 // function is broken out separately to make the function easily runnable in
 // tests without having to spend the time and resources to generate a real
 // Spring '83 key.
-func generateConformingKeyWithSuffix(ctx context.Context, targetSuffix string) (*ed25519KeyPair, int, error) {
+func generateConformingKeyWithSuffix(ctx context.Context, targetSuffix string, opts GenerateOptions) (*ed25519KeyPair, int, error) {
+	reader := io.Reader(rand.Reader)
+	if opts.Reader != nil {
+		// An arbitrary caller-supplied reader (e.g. a deterministic one used
+		// in tests) isn't necessarily safe for the concurrent reads that
+		// follow, so serialize access to it.
+		reader = &lockedReader{r: opts.Reader}
+	}
+
 	var (
 		conformingKeyChan = make(chan *ed25519KeyPair, runtime.NumCPU())
 		done              atomic.Bool
 		totalIterations   int64
 	)
 
-	targetSuffixBytes, oddChars := hexBytes(targetSuffix)
+	targetSuffixBytes, suffixOddChars := hexBytes(targetSuffix, false)
+	targetPrefixBytes, prefixOddChars := hexBytes(opts.Prefix, true)
 
-	{
-		errGroup, _ := errgroup.WithContext(ctx)
+	if opts.Progress != nil {
+		start := time.Now()
+		stopProgress := make(chan struct{})
+		defer close(stopProgress)
 
-		for i := 0; i < runtime.NumCPU(); i++ {
-			errGroup.Go(func() error {
-				for numIterations := 0; ; numIterations++ {
-					if done.Load() {
-						atomic.AddInt64(&totalIterations, int64(numIterations))
-						return nil
-					}
+		go func() {
+			ticker := time.NewTicker(progressInterval)
+			defer ticker.Stop()
 
-					publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
-					if err != nil {
-						return xerrors.Errorf("error generating key: %w", err)
-					}
+			for {
+				select {
+				case <-ticker.C:
+					opts.Progress(atomic.LoadInt64(&totalIterations), time.Since(start))
+				case <-stopProgress:
+					return
+				}
+			}
+		}()
+	}
+
+	errGroup, _ := errgroup.WithContext(ctx)
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		errGroup.Go(func() error {
+			// Seed material is pulled in large batches rather than one
+			// ed25519.SeedSize read at a time, amortizing the CSPRNG's
+			// overhead over many candidate keys. seedBuf is reused in place;
+			// pos tracks how much of it has already been consumed.
+			seedBuf := make([]byte, seedBatchBytes)
+			pos := len(seedBuf)
+
+			for {
+				if done.Load() {
+					return nil
+				}
 
-					if !suffixBytesEqual([]byte(privateKey), targetSuffixBytes, oddChars) {
-						continue
+				if pos+ed25519.SeedSize > len(seedBuf) {
+					if _, err := io.ReadFull(reader, seedBuf); err != nil {
+						return xerrors.Errorf("error reading seed material: %w", err)
 					}
+					pos = 0
+				}
+
+				seed := seedBuf[pos : pos+ed25519.SeedSize]
+				pos += ed25519.SeedSize
 
-					conformingKeyChan <- &ed25519KeyPair{privateKey, publicKey}
+				atomic.AddInt64(&totalIterations, 1)
 
-					done.Store(true)
+				publicKey, err := publicKeyFromSeed(seed)
+				if err != nil {
+					return xerrors.Errorf("error deriving public key: %w", err)
 				}
-			})
-		}
 
-		if err := errGroup.Wait(); err != nil {
-			return nil, 0, xerrors.Errorf("error finding key: %w", err)
-		}
+				if !suffixBytesEqual(publicKey, targetSuffixBytes, suffixOddChars, false) {
+					continue
+				}
+
+				if !suffixBytesEqual(publicKey, targetPrefixBytes, prefixOddChars, true) {
+					continue
+				}
+
+				// Only now -- once a conforming key's actually been found --
+				// pay for materializing a full ed25519.PrivateKey.
+				privateKey := ed25519.NewKeyFromSeed(seed)
+
+				conformingKeyChan <- &ed25519KeyPair{privateKey, ed25519.PublicKey(publicKey)}
+				done.Store(true)
+				return nil
+			}
+		})
+	}
+
+	if err := errGroup.Wait(); err != nil {
+		return nil, 0, xerrors.Errorf("error finding key: %w", err)
 	}
 
 	return <-conformingKeyChan, int(totalIterations), nil
 }
 
+// publicKeyFromSeed derives the Ed25519 public key for seed directly via
+// filippo.io/edwards25519's scalar/point arithmetic, replicating what
+// ed25519.NewKeyFromSeed does internally but without allocating a full
+// ed25519.PrivateKey. This lets the hot loop in
+// generateConformingKeyWithSuffix check candidate keys against the target
+// suffix/prefix cheaply, only paying for NewKeyFromSeed once a match is
+// found.
+func publicKeyFromSeed(seed []byte) ([]byte, error) {
+	digest := sha512.Sum512(seed)
+
+	s, err := new(edwards25519.Scalar).SetBytesWithClamping(digest[:ed25519.SeedSize])
+	if err != nil {
+		return nil, xerrors.Errorf("error clamping scalar: %w", err)
+	}
+
+	return new(edwards25519.Point).ScalarBaseMult(s).Bytes(), nil
+}
+
+// lockedReader serializes reads against an underlying reader that isn't
+// otherwise safe for concurrent use.
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
 // Breaks the given hex string into bytes. The boolean flag indicates whether
-// there was an odd number of hex characters which means that the most
-// significant byte only represents half a byte worth of relevant information.
-func hexBytes(s string) ([]byte, bool) {
+// there was an odd number of hex characters which means that the boundary
+// byte only represents half a byte worth of relevant information. With
+// fromStart set, the odd character is treated as the leading (most
+// significant) nibble of the target's last byte rather than the trailing
+// (least significant) nibble of its first, matching how a prefix's leftover
+// character lines up against the generated key's bytes.
+func hexBytes(s string, fromStart bool) ([]byte, bool) {
 	var oddChars bool
 	if len(s)%2 == 1 {
 		oddChars = true
-		s = "0" + s
+		if fromStart {
+			s += "0"
+		} else {
+			s = "0" + s
+		}
 	}
 
 	sBytes, err := hex.DecodeString(s)
@@ -105,27 +275,41 @@ func hexBytes(s string) ([]byte, bool) {
 	return sBytes, oddChars
 }
 
-// Bytewise suffix comparison that lets us avoid encoding every single generated
-// key to a hex string. The `oddChars` flag handles the case where we only care
-// about the half byte at the boundary, as is the case with a Spring '83 key
-// where the last seven hex characters are relevant (each two characters are a
-// byte).
-func suffixBytesEqual(b, suffix []byte, oddChars bool) bool {
-	if len(suffix) < 1 {
+// Bytewise suffix (or, with fromStart, prefix) comparison that lets us avoid
+// encoding every single generated key to a hex string. The `oddChars` flag
+// handles the case where we only care about half of a boundary byte, as is
+// the case with a Spring '83 key where the last seven hex characters are
+// relevant (each two characters are a byte).
+func suffixBytesEqual(b, target []byte, oddChars, fromStart bool) bool {
+	if len(target) < 1 {
 		return true
 	}
 
+	if fromStart {
+		if oddChars {
+			bBoundary := b[len(target)-1]
+			targetBoundary := target[len(target)-1]
+
+			// Compare the high nibble at the boundary, and then the rest of
+			// the preceding target bytes as usual.
+			return bBoundary&0xf0 == targetBoundary&0xf0 &&
+				bytes.Equal(b[:len(target)-1], target[:len(target)-1])
+		}
+
+		return bytes.Equal(b[:len(target)], target)
+	}
+
 	if oddChars {
-		bBoundary := b[len(b)-len(suffix)]
-		suffixBoundary := suffix[0]
+		bBoundary := b[len(b)-len(target)]
+		targetBoundary := target[0]
 
-		// Compare the half byte at the boundary, and then the rest of suffix
-		// bytes as usual.
-		return bBoundary&0x0f == suffixBoundary&0x0f &&
-			bytes.Equal(b[len(b)-len(suffix)+1:], suffix[1:])
+		// Compare the low nibble at the boundary, and then the rest of
+		// target bytes as usual.
+		return bBoundary&0x0f == targetBoundary&0x0f &&
+			bytes.Equal(b[len(b)-len(target)+1:], target[1:])
 	}
 
-	return bytes.Equal(b[len(b)-len(suffix):], suffix)
+	return bytes.Equal(b[len(b)-len(target):], target)
 }
 
 func keySuffixWithExpiry(t time.Time) string {
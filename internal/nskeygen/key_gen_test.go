@@ -1,7 +1,10 @@
 package nskeygen
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
 	"runtime"
 	"strings"
@@ -10,8 +13,6 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
-
-	"github.com/brandur/neospring/internal/nskey"
 )
 
 func TestExpiryDigitsTimeFormat(t *testing.T) {
@@ -23,17 +24,17 @@ func TestGenerateConformingKeyWithSuffix(t *testing.T) {
 	ctx := context.Background()
 	defer goleak.VerifyNone(t)
 
-	showKeys := func(key *nskey.KeyPair, start time.Time, totalIterations int) {
+	showKeys := func(key *ed25519KeyPair, start time.Time, totalIterations int) {
 		fmt.Printf("took %v with %d iterations\n", time.Since(start), totalIterations)
-		fmt.Printf("private key (hex): %s\n", key.PrivateKey)
-		fmt.Printf("public key (hex):  %s\n", key.PublicKey)
+		fmt.Printf("private key (hex): %s\n", key.PrivateKeyHex())
+		fmt.Printf("public key (hex):  %s\n", key.PublicKeyHex())
 	}
 
 	// Ultra simplistic example with no suffix, meaning the first key generated
 	// gets returned.
 	t.Run("NoSuffix", func(t *testing.T) {
 		start := time.Now()
-		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "")
+		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "", GenerateOptions{})
 		require.NoError(t, err)
 		require.LessOrEqual(t, totalIterations, runtime.NumCPU())
 		showKeys(key, start, totalIterations)
@@ -41,51 +42,137 @@ func TestGenerateConformingKeyWithSuffix(t *testing.T) {
 
 	t.Run("VeryEasySuffix", func(t *testing.T) {
 		start := time.Now()
-		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "aa")
+		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "aa", GenerateOptions{})
 		require.NoError(t, err)
-		require.True(t, strings.HasSuffix(key.PublicKey, "aa"))
+		require.True(t, strings.HasSuffix(key.PublicKeyHex(), "aa"))
 		showKeys(key, start, totalIterations)
 	})
 
 	t.Run("EasySuffix", func(t *testing.T) {
 		start := time.Now()
-		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "aaa")
+		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "aaa", GenerateOptions{})
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(key.PublicKeyHex(), "aaa"))
+		showKeys(key, start, totalIterations)
+	})
+
+	t.Run("EasyPrefix", func(t *testing.T) {
+		start := time.Now()
+		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "", GenerateOptions{Prefix: "aaa"})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(key.PublicKeyHex(), "aaa"))
+		showKeys(key, start, totalIterations)
+	})
+
+	t.Run("DeterministicReader", func(t *testing.T) {
+		seed := bytes.Repeat([]byte{0x01}, 4096*ed25519.SeedSize)
+		start := time.Now()
+		key, totalIterations, err := generateConformingKeyWithSuffix(ctx, "", GenerateOptions{Reader: bytes.NewReader(seed)})
 		require.NoError(t, err)
-		require.True(t, strings.HasSuffix(key.PublicKey, "aaa"))
 		showKeys(key, start, totalIterations)
 	})
 }
 
+func TestGenerateConformingKey(t *testing.T) {
+	ctx := context.Background()
+	defer goleak.VerifyNone(t)
+
+	expiryMonth := time.Date(2022, 0o7, 11, 1, 1, 1, 1, time.UTC)
+
+	t.Run("UnsatisfiableCombination", func(t *testing.T) {
+		_, _, err := GenerateConformingKey(ctx, expiryMonth, GenerateOptions{
+			Prefix: strings.Repeat("a", publicKeyHexChars),
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestEstimateDifficulty(t *testing.T) {
+	noConstraints, _ := EstimateDifficulty(GenerateOptions{}).Int64()
+	require.Equal(t, int64(268435456), noConstraints) // 16^7
+
+	withSuffix, _ := EstimateDifficulty(GenerateOptions{Suffix: "a"}).Int64()
+	require.Equal(t, int64(268435456*16), withSuffix) // 16^8
+}
+
 func TestHexBytes(t *testing.T) {
 	{
-		sBytes, oddChars := hexBytes("5678")
+		sBytes, oddChars := hexBytes("5678", false)
 		require.Equal(t, []byte{0x56, 0x78}, sBytes)
 		require.False(t, oddChars)
 	}
 
 	{
-		sBytes, oddChars := hexBytes("678")
+		sBytes, oddChars := hexBytes("678", false)
 		require.Equal(t, []byte{0x06, 0x78}, sBytes)
 		require.True(t, oddChars)
 	}
+
+	{
+		sBytes, oddChars := hexBytes("678", true)
+		require.Equal(t, []byte{0x67, 0x80}, sBytes)
+		require.True(t, oddChars)
+	}
 }
 
 func TestSuffixBytesEqual(t *testing.T) {
-	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{}, false))
+	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{}, false, false))
 
-	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{0x78}, false))
-	require.True(t, suffixBytesEqual([]byte{0x56, 0x78}, []byte{0x78}, false))
-	require.False(t, suffixBytesEqual([]byte{0x78, 0x56}, []byte{0x78}, false))
+	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{0x78}, false, false))
+	require.True(t, suffixBytesEqual([]byte{0x56, 0x78}, []byte{0x78}, false, false))
+	require.False(t, suffixBytesEqual([]byte{0x78, 0x56}, []byte{0x78}, false, false))
 
-	require.False(t, suffixBytesEqual([]byte{0x78}, []byte{0x08}, false))
-	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{0x08}, true))
+	require.False(t, suffixBytesEqual([]byte{0x78}, []byte{0x08}, false, false))
+	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{0x08}, true, false))
 
-	require.True(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x56, 0x78}, false))
-	require.False(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x06, 0x08}, false))
-	require.True(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x06, 0x78}, true))
+	require.True(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x56, 0x78}, false, false))
+	require.False(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x06, 0x08}, false, false))
+	require.True(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x06, 0x78}, true, false))
+
+	// Prefix matching (fromStart).
+	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{}, false, true))
+
+	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{0x78}, false, true))
+	require.True(t, suffixBytesEqual([]byte{0x78, 0x56}, []byte{0x78}, false, true))
+	require.False(t, suffixBytesEqual([]byte{0x56, 0x78}, []byte{0x78}, false, true))
+
+	require.False(t, suffixBytesEqual([]byte{0x78}, []byte{0x70}, false, true))
+	require.True(t, suffixBytesEqual([]byte{0x78}, []byte{0x70}, true, true))
+
+	require.True(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x34, 0x56}, false, true))
+	require.False(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x34, 0x60}, false, true))
+	require.True(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x34, 0x50}, true, true))
+	require.False(t, suffixBytesEqual([]byte{0x34, 0x56, 0x78}, []byte{0x34, 0x60}, true, true))
 }
 
 func TestKeySuffixWithExpiry(t *testing.T) {
 	testTime := time.Date(2022, 0o7, 11, 1, 1, 1, 1, time.Local)
 	require.Equal(t, "83e0724", keySuffixWithExpiry(testTime)) // two years in the future
 }
+
+func TestPublicKeyFromSeed(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	_, err := rand.Read(seed)
+	require.NoError(t, err)
+
+	publicKey, err := publicKeyFromSeed(seed)
+	require.NoError(t, err)
+
+	want := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	require.Equal(t, []byte(want), publicKey)
+}
+
+func TestKeysPerSecond(t *testing.T) {
+	require.InDelta(t, 1000.0, KeysPerSecond(1000, time.Second), 0.001)
+	require.Equal(t, float64(0), KeysPerSecond(1000, 0))
+}
+
+func BenchmarkGenerateConformingKeyWithSuffix(b *testing.B) {
+	ctx := context.Background()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := generateConformingKeyWithSuffix(ctx, "aa", GenerateOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package nsstore
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CacheStore wraps any two BoardStores, serving reads from a fast cache
+// store while fanning writes out to a slower, durable one -- the same
+// read-through-cache shape nsboltstore and nsgcpstoragestore each bake into
+// their own concrete type, pulled out here so that it can front any pair of
+// stores (for example nsmemorystore in front of nsredisstore) rather than
+// just the one each of those packages happens to embed.
+//
+// Writes go to durable first; cache is only updated once a write has been
+// durably persisted, so a cache crash never leaves it holding content that
+// was never actually saved.
+type CacheStore struct {
+	cache   BoardStore
+	durable BoardStore
+	logger  *logrus.Logger
+}
+
+// NewCacheStore returns a CacheStore that serves reads from cache, falling
+// back to and warming from durable on a miss, and writes through to durable
+// before mirroring into cache.
+func NewCacheStore(logger *logrus.Logger, cache, durable BoardStore) *CacheStore {
+	return &CacheStore{
+		cache:   cache,
+		durable: durable,
+		logger:  logger,
+	}
+}
+
+func (s *CacheStore) Get(ctx context.Context, key string) (*Board, error) {
+	if board, err := s.cache.Get(ctx, key); err == nil {
+		return board, nil
+	}
+
+	board, err := s.durable.Get(ctx, key)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if err := s.cache.Put(ctx, key, board); err != nil {
+		s.logger.Warnf("CacheStore: Error warming cache for key %q: %v", keyPrefix(key), err)
+	}
+
+	return board, nil
+}
+
+func (s *CacheStore) Put(ctx context.Context, key string, board *Board) error {
+	if err := s.durable.Put(ctx, key, board); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err := s.cache.Put(ctx, key, board); err != nil {
+		s.logger.Warnf("CacheStore: Error updating cache for key %q: %v", keyPrefix(key), err)
+	}
+
+	return nil
+}
+
+// ReapLoop runs durable's reap loop on its own goroutine (since it may be a
+// genuine forever loop, like nsmemorystore's) and blocks running cache's, so
+// that calling CacheStore's ReapLoop on a goroutine -- as every BoardStore's
+// is -- reaps both of the stores it wraps.
+func (s *CacheStore) ReapLoop(ctx context.Context, shutdown <-chan struct{}) {
+	go s.durable.ReapLoop(ctx, shutdown)
+	s.cache.ReapLoop(ctx, shutdown)
+}
+
+// Iterate always reads through to durable, since cache may not hold every
+// board that's ever been written (for example, one only ever read or
+// written through a peer instance before being restarted locally).
+func (s *CacheStore) Iterate(ctx context.Context, fn func(key string, board *Board) error) error {
+	return s.durable.Iterate(ctx, fn) //nolint:wrapcheck
+}
+
+// Delete removes key from durable first, then cache, mirroring Put's
+// write-through ordering.
+func (s *CacheStore) Delete(ctx context.Context, key string) error {
+	if err := s.durable.Delete(ctx, key); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err := s.cache.Delete(ctx, key); err != nil {
+		s.logger.Warnf("CacheStore: Error deleting key %q from cache: %v", keyPrefix(key), err)
+	}
+
+	return nil
+}
+
+// Count always reads through to durable, for the same reason Iterate does.
+func (s *CacheStore) Count(ctx context.Context) (int, error) {
+	return s.durable.Count(ctx) //nolint:wrapcheck
+}
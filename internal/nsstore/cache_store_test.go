@@ -0,0 +1,93 @@
+package nsstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+func TestCacheStore(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+
+	cache := nsmemorystore.NewMemoryStore(logger, 0)
+	cache.SetTimeNow(func() time.Time { return stableTime })
+
+	durable := nsmemorystore.NewMemoryStore(logger, 0)
+	durable.SetTimeNow(func() time.Time { return stableTime })
+
+	store := nsstore.NewCacheStore(logger, cache, durable)
+
+	t.Run("Put writes through to durable and warms cache", func(t *testing.T) {
+		board := &nsstore.Board{
+			Content:   []byte("some board content"),
+			Timestamp: stableTime,
+		}
+		require.NoError(t, store.Put(ctx, sampleValidKey, board))
+
+		durableBoard, err := durable.Get(ctx, sampleValidKey)
+		require.NoError(t, err)
+		require.Equal(t, board, durableBoard)
+
+		cacheBoard, err := cache.Get(ctx, sampleValidKey)
+		require.NoError(t, err)
+		require.Equal(t, board, cacheBoard)
+	})
+
+	t.Run("Get falls back to durable and warms cache on a miss", func(t *testing.T) {
+		board := &nsstore.Board{
+			Content:   []byte("only in durable"),
+			Timestamp: stableTime,
+		}
+		require.NoError(t, durable.Put(ctx, "only-in-durable", board))
+
+		got, err := store.Get(ctx, "only-in-durable")
+		require.NoError(t, err)
+		require.Equal(t, board, got)
+
+		cacheBoard, err := cache.Get(ctx, "only-in-durable")
+		require.NoError(t, err)
+		require.Equal(t, board, cacheBoard)
+	})
+
+	t.Run("Delete removes the board from both stores", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, sampleValidKey, &nsstore.Board{
+			Content:   []byte("some board content"),
+			Timestamp: stableTime,
+		}))
+		require.NoError(t, store.Delete(ctx, sampleValidKey))
+
+		_, err := durable.Get(ctx, sampleValidKey)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+
+		_, err = cache.Get(ctx, sampleValidKey)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+	})
+
+	t.Run("Count and Iterate read through to durable", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, sampleValidKey, &nsstore.Board{
+			Content:   []byte("some board content"),
+			Timestamp: stableTime,
+		}))
+
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+
+		durableCount, err := durable.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, durableCount, count)
+
+		var seenKeys []string
+		require.NoError(t, store.Iterate(ctx, func(key string, _ *nsstore.Board) error {
+			seenKeys = append(seenKeys, key)
+			return nil
+		}))
+		require.Contains(t, seenKeys, sampleValidKey)
+	})
+}
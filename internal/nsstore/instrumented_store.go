@@ -0,0 +1,189 @@
+package nsstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const keyPrefixLen = 8
+
+var (
+	boardsGetTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boards_get_total",
+			Help: "Total number of BoardStore.Get calls, labeled by backend and result (hit, miss, or error).",
+		},
+		[]string{"backend", "result"},
+	)
+
+	boardsPutTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boards_put_total",
+			Help: "Total number of BoardStore.Put calls, labeled by backend and result (ok or error).",
+		},
+		[]string{"backend", "result"},
+	)
+
+	boardReapTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "board_reap_total",
+			Help: "Total number of boards deleted by the sweeper, labeled by backend.",
+		},
+		[]string{"backend"},
+	)
+
+	boardAgeSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "board_age_seconds",
+			Help:    "Age, in seconds, of a board's timestamp at the moment it's successfully put to a store.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 22), // 1s up to roughly MaxContentAge
+		},
+	)
+
+	storageOperationDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "storage_operation_duration_seconds",
+			Help: "Duration, in seconds, of each BoardStore operation, labeled by backend and op.",
+		},
+		[]string{"backend", "op"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(boardsGetTotal, boardsPutTotal, boardReapTotal, boardAgeSeconds, storageOperationDurationSeconds)
+}
+
+// InstrumentedStore wraps another BoardStore, transparently forwarding every
+// call to it while recording Prometheus counters/histograms and a structured
+// log line for each operation, so that a store's behavior can be observed
+// and correlated with its logs regardless of which concrete backend is
+// running underneath.
+type InstrumentedStore struct {
+	backend string
+	logger  *logrus.Logger
+	store   BoardStore
+}
+
+// NewInstrumentedStore returns an InstrumentedStore that forwards every call
+// to store, labeling its metrics and log lines with backend -- normally the
+// concrete store's type name (e.g. "MemoryStore"), as produced by
+// reflect.TypeOf(store).Elem().Name().
+func NewInstrumentedStore(logger *logrus.Logger, store BoardStore, backend string) *InstrumentedStore {
+	return &InstrumentedStore{
+		backend: backend,
+		logger:  logger,
+		store:   store,
+	}
+}
+
+func (s *InstrumentedStore) Get(ctx context.Context, key string) (*Board, error) {
+	start := time.Now()
+	board, err := s.store.Get(ctx, key)
+	duration := time.Since(start)
+
+	result := "hit"
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		result = "miss"
+	case err != nil:
+		result = "error"
+	}
+
+	boardsGetTotal.WithLabelValues(s.backend, result).Inc()
+	storageOperationDurationSeconds.WithLabelValues(s.backend, "get").Observe(duration.Seconds())
+
+	s.logger.WithFields(logrus.Fields{
+		"backend":     s.backend,
+		"duration_ms": duration.Milliseconds(),
+		"key_prefix":  keyPrefix(key),
+		"op":          "get",
+		"result":      result,
+	}).Debug("store_operation")
+
+	return board, err //nolint:wrapcheck
+}
+
+func (s *InstrumentedStore) Put(ctx context.Context, key string, board *Board) error {
+	start := time.Now()
+	err := s.store.Put(ctx, key, board)
+	duration := time.Since(start)
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	} else {
+		boardAgeSeconds.Observe(time.Since(board.Timestamp).Seconds())
+	}
+
+	boardsPutTotal.WithLabelValues(s.backend, result).Inc()
+	storageOperationDurationSeconds.WithLabelValues(s.backend, "put").Observe(duration.Seconds())
+
+	s.logger.WithFields(logrus.Fields{
+		"backend":     s.backend,
+		"bytes":       len(board.Content),
+		"duration_ms": duration.Milliseconds(),
+		"key_prefix":  keyPrefix(key),
+		"op":          "put",
+		"result":      result,
+	}).Debug("store_operation")
+
+	return err //nolint:wrapcheck
+}
+
+// ReapLoop forwards to the wrapped store unmodified. The store's own reap
+// loop deletes boards directly rather than through Delete, so there's
+// nothing useful to instrument here; reaps are counted via Delete instead,
+// which is what Sweeper calls.
+func (s *InstrumentedStore) ReapLoop(ctx context.Context, shutdown <-chan struct{}) {
+	s.store.ReapLoop(ctx, shutdown)
+}
+
+func (s *InstrumentedStore) Iterate(ctx context.Context, fn func(key string, board *Board) error) error {
+	return s.store.Iterate(ctx, fn) //nolint:wrapcheck
+}
+
+func (s *InstrumentedStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, key)
+	duration := time.Since(start)
+
+	storageOperationDurationSeconds.WithLabelValues(s.backend, "delete").Observe(duration.Seconds())
+	if err == nil {
+		boardReapTotal.WithLabelValues(s.backend).Inc()
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"backend":     s.backend,
+		"duration_ms": duration.Milliseconds(),
+		"key_prefix":  keyPrefix(key),
+		"op":          "delete",
+	}).Debug("store_operation")
+
+	return err //nolint:wrapcheck
+}
+
+func (s *InstrumentedStore) Count(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := s.store.Count(ctx)
+	duration := time.Since(start)
+
+	storageOperationDurationSeconds.WithLabelValues(s.backend, "count").Observe(duration.Seconds())
+
+	return count, err //nolint:wrapcheck
+}
+
+// keyPrefix truncates a public key down to a short prefix suitable for
+// logging -- long enough to spot a particular key across log lines and
+// metrics dashboards, short enough not to fully disclose it in logs that may
+// be more widely readable than the store itself.
+func keyPrefix(key string) string {
+	if len(key) <= keyPrefixLen {
+		return key
+	}
+
+	return key[:keyPrefixLen]
+}
@@ -0,0 +1,73 @@
+package nsstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+
+	underlying := nsmemorystore.NewMemoryStore(logger, 0)
+	underlying.SetTimeNow(func() time.Time { return stableTime })
+
+	store := nsstore.NewInstrumentedStore(logger, underlying, "TestBackend")
+
+	t.Run("Put and Get", func(t *testing.T) {
+		board := &nsstore.Board{
+			Content:   []byte("some board content"),
+			Timestamp: stableTime,
+		}
+		require.NoError(t, store.Put(ctx, sampleValidKey, board))
+
+		got, err := store.Get(ctx, sampleValidKey)
+		require.NoError(t, err)
+		require.Equal(t, board, got)
+
+		_, err = store.Get(ctx, "some-key-that-does-not-exist")
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+	})
+
+	t.Run("Delete removes the board from the underlying store", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, sampleValidKey, &nsstore.Board{
+			Content:   []byte("some board content"),
+			Timestamp: stableTime,
+		}))
+		require.NoError(t, store.Delete(ctx, sampleValidKey))
+
+		_, err := store.Get(ctx, sampleValidKey)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+
+		// Deleting an already-absent key is a no-op, not an error.
+		require.NoError(t, store.Delete(ctx, sampleValidKey))
+	})
+
+	t.Run("Count and Iterate delegate to the underlying store", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, sampleValidKey, &nsstore.Board{
+			Content:   []byte("some board content"),
+			Timestamp: stableTime,
+		}))
+
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+
+		underlyingCount, err := underlying.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, underlyingCount, count)
+
+		var seenKeys []string
+		require.NoError(t, store.Iterate(ctx, func(key string, _ *nsstore.Board) error {
+			seenKeys = append(seenKeys, key)
+			return nil
+		}))
+		require.Contains(t, seenKeys, sampleValidKey)
+	})
+}
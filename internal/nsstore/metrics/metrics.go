@@ -0,0 +1,53 @@
+// Package metrics holds Prometheus collectors shared across nsstore's
+// in-memory cache tier (nsmemorystore), separate from InstrumentedStore's
+// per-backend collectors in the nsstore package itself, since a MemoryStore
+// is so often used as a front-cache for a slower backend (GCP, S3, Bolt)
+// rather than as the backend InstrumentedStore is wrapping directly.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// MemoryCacheOperationsTotal counts MemoryStore.Get results, labeled by
+	// whether the key was present and fresh ("hit") or not ("miss").
+	MemoryCacheOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "memory_cache_operations_total",
+			Help: "Total number of MemoryStore.Get calls, labeled by result (hit or miss).",
+		},
+		[]string{"result"},
+	)
+
+	// MemoryCacheEvictionsTotal counts boards the LRU discarded to stay
+	// under its configured MaxEntries, as opposed to boards removed by a
+	// deliberate Delete or reap.
+	MemoryCacheEvictionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "memory_cache_evictions_total",
+			Help: "Total number of boards evicted from a MemoryStore's LRU to stay under its MaxEntries.",
+		},
+	)
+
+	// MemoryCacheStalePrunedTotal counts boards that Get found present in
+	// the LRU but past nsstore.MaxContentAge, meaning the reap loop hadn't
+	// yet caught up to them.
+	MemoryCacheStalePrunedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "memory_cache_stale_pruned_total",
+			Help: "Total number of boards found stale (past MaxContentAge) on read and pruned before the reap loop reached them.",
+		},
+	)
+
+	// MemoryCacheReapTotal counts boards removed by a MemoryStore's own
+	// ReapLoop.
+	MemoryCacheReapTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "memory_cache_reap_total",
+			Help: "Total number of boards deleted by a MemoryStore's reap loop.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(MemoryCacheOperationsTotal, MemoryCacheEvictionsTotal, MemoryCacheStalePrunedTotal, MemoryCacheReapTotal)
+}
@@ -0,0 +1,330 @@
+// Package nsboltstore implements nsstore's `BoardStore` interface on top of
+// BoltDB, giving operators a durable single-node option that needs neither
+// GCP nor S3 -- just a file on disk. Unlike nsdiskstore, it maintains a
+// secondary index of boards by expiry so that ReapLoop doesn't need to walk
+// every board on every sweep, and fronts reads with an in-memory cache the
+// way nsgcpstoragestore does so that hot boards don't hit disk on every GET.
+package nsboltstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+// boardsBucket is the BoltDB bucket boards are stored under, keyed by their
+// Spring '83 public key hex, with the serialized board as the value.
+var boardsBucket = []byte("boards")
+
+// expiryBucket is a secondary index over boardsBucket, keyed by each
+// board's expiry instant (8-byte big-endian Unix-nanos) followed by its
+// public key, with the public key repeated as the value. BoltDB keeps
+// bucket keys sorted, so ReapLoop can cursor from the start and stop as
+// soon as it reaches an expiry that hasn't arrived yet, rather than
+// scanning every board on every sweep.
+var expiryBucket = []byte("expiry")
+
+// expiryTimestampLen is the width in bytes of the big-endian Unix-nanos
+// prefix on an expiryBucket key.
+const expiryTimestampLen = 8
+
+// BoltStore persists boards to a BoltDB file on disk, fronted by an
+// in-memory cache. It's safe for concurrent use -- BoltDB serializes
+// writers internally and allows any number of concurrent readers.
+type BoltStore struct {
+	db              *bbolt.DB
+	logger          *logrus.Logger
+	memoryStore     *nsmemorystore.MemoryStore
+	name            string
+	path            string
+	reapLoopStarted bool
+	timeNow         func() time.Time
+}
+
+// BoltConfig tunes the durability/throughput tradeoff of a BoltStore's
+// underlying file. The zero value is the safe, durable default.
+type BoltConfig struct {
+	// NoSync skips fsync on every commit when true, trading durability
+	// (a small window of recently-written boards can be lost on a host
+	// crash, though not on an ordinary process exit) for substantially
+	// higher write throughput. Leave false for the default, safe behavior.
+	NoSync bool
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB file at path and
+// returns a BoltStore backed by it. Callers are responsible for calling
+// Close when the store is no longer needed.
+func NewBoltStore(logger *logrus.Logger, path string, config BoltConfig) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 1 * time.Second, NoSync: config.NoSync})
+	if err != nil {
+		return nil, xerrors.Errorf("error opening bolt db at %q: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boardsBucket); err != nil {
+			return err //nolint:wrapcheck
+		}
+		_, err := tx.CreateBucketIfNotExists(expiryBucket)
+		return err //nolint:wrapcheck
+	}); err != nil {
+		return nil, xerrors.Errorf("error creating buckets: %w", err)
+	}
+
+	return &BoltStore{
+		db:          db,
+		logger:      logger,
+		memoryStore: nsmemorystore.NewMemoryStore(logger, 0),
+		name:        reflect.TypeOf(BoltStore{}).Name(),
+		path:        path,
+		timeNow:     time.Now,
+	}, nil
+}
+
+// Close closes the underlying BoltDB file.
+func (s *BoltStore) Close() error {
+	return s.db.Close() //nolint:wrapcheck
+}
+
+// Get returns the board stored at key, consulting the in-memory cache
+// before reading through to BoltDB.
+func (s *BoltStore) Get(ctx context.Context, key string) (*nsstore.Board, error) {
+	if board, err := s.memoryStore.Get(ctx, key); err == nil {
+		return board, nil
+	}
+
+	var board *nsstore.Board
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(boardsBucket).Get([]byte(key))
+		if data == nil {
+			return nsstore.ErrKeyNotFound
+		}
+
+		board = new(nsstore.Board)
+		return json.Unmarshal(data, board)
+	}); err != nil {
+		if errors.Is(err, nsstore.ErrKeyNotFound) {
+			return nil, err
+		}
+
+		return nil, xerrors.Errorf("error reading key %q: %w", key, err)
+	}
+
+	// Just in case the reap loop is behind, aggressively prune possibly
+	// outdated content.
+	if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+		s.logger.Infof(s.name+": Returning not found for stale key %q created %v", key, board.Timestamp)
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	if err := s.memoryStore.Put(ctx, key, board); err != nil {
+		return nil, err
+	}
+
+	return board, nil
+}
+
+// Put stores board under key, updating the expiry index in the same
+// transaction so the two never drift apart, then caches it in memory.
+func (s *BoltStore) Put(ctx context.Context, key string, board *nsstore.Board) error {
+	data, err := json.Marshal(board)
+	if err != nil {
+		return xerrors.Errorf("error encoding board: %w", err)
+	}
+
+	if err := s.db.Update(func(tx *bbolt.Tx) error { //nolint:wrapcheck
+		boards := tx.Bucket(boardsBucket)
+		expiry := tx.Bucket(expiryBucket)
+
+		if existing := boards.Get([]byte(key)); existing != nil {
+			existingBoard := new(nsstore.Board)
+			if err := json.Unmarshal(existing, existingBoard); err != nil {
+				return xerrors.Errorf("error decoding existing board: %w", err)
+			}
+
+			if existingBoard.Timestamp.After(board.Timestamp) {
+				return nsstore.ErrTimestampOlderThanCurrent
+			}
+
+			if err := expiry.Delete(expiryKey(existingBoard.Timestamp, key)); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		if err := boards.Put([]byte(key), data); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		return expiry.Put(expiryKey(board.Timestamp, key), []byte(key)) //nolint:wrapcheck
+	}); err != nil {
+		return err
+	}
+
+	return s.memoryStore.Put(ctx, key, board)
+}
+
+// Iterate walks every board currently in the store, invoking fn once for
+// each key. Always reads through to BoltDB rather than the memory cache,
+// since the cache may not hold every board that's ever been written.
+func (s *BoltStore) Iterate(_ context.Context, fn func(key string, board *nsstore.Board) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error { //nolint:wrapcheck
+		return tx.Bucket(boardsBucket).ForEach(func(k, v []byte) error {
+			board := new(nsstore.Board)
+			if err := json.Unmarshal(v, board); err != nil {
+				return xerrors.Errorf("error decoding board for key %q: %w", k, err)
+			}
+
+			return fn(string(k), board)
+		})
+	})
+}
+
+// Delete permanently removes the board stored at key, if any, from BoltDB,
+// its expiry index, and the memory cache.
+func (s *BoltStore) Delete(ctx context.Context, key string) error {
+	if err := s.db.Update(func(tx *bbolt.Tx) error { //nolint:wrapcheck
+		boards := tx.Bucket(boardsBucket)
+
+		existing := boards.Get([]byte(key))
+		if existing == nil {
+			return nil
+		}
+
+		existingBoard := new(nsstore.Board)
+		if err := json.Unmarshal(existing, existingBoard); err != nil {
+			return xerrors.Errorf("error decoding existing board: %w", err)
+		}
+
+		if err := tx.Bucket(expiryBucket).Delete(expiryKey(existingBoard.Timestamp, key)); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		return boards.Delete([]byte(key)) //nolint:wrapcheck
+	}); err != nil {
+		return err
+	}
+
+	return s.memoryStore.Delete(ctx, key)
+}
+
+// Count returns the number of boards currently in the store.
+func (s *BoltStore) Count(_ context.Context) (int, error) {
+	var count int
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		count = tx.Bucket(boardsBucket).Stats().KeyN
+		return nil
+	}); err != nil {
+		return 0, xerrors.Errorf("error reading bucket stats: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReapLoop starts a reaper forever loop that periodically cleans up expired
+// boards. It blocks, so should be started on a goroutine.
+func (s *BoltStore) ReapLoop(_ context.Context, shutdown <-chan struct{}) {
+	if s.reapLoopStarted {
+		panic("ReapLoop already started -- should only be run once")
+	}
+
+	s.reapLoopStarted = true
+
+	for {
+		_ = s.reap()
+
+		select {
+		case <-shutdown:
+			s.logger.Info(s.name + ": Received shutdown signal")
+			return
+
+		case <-time.After(1 * time.Minute):
+		}
+	}
+}
+
+// SetTimeNow overrides the time BoltStore considers "now". For testing
+// purposes only.
+func (s *BoltStore) SetTimeNow(timeNow func() time.Time) {
+	s.timeNow = timeNow
+	s.memoryStore.SetTimeNow(timeNow)
+}
+
+// reap cursors expiryBucket from its start, which -- because BoltDB keeps
+// keys sorted -- visits boards in expiry order. It stops at the first
+// expiry that hasn't arrived yet rather than walking the whole store, so
+// a sweep costs O(log n + k) for k expired boards instead of nsdiskstore's
+// O(n) full-bucket scan.
+func (s *BoltStore) reap() int {
+	now := expiryTimestampBytes(s.timeNow())
+
+	var staleExpiryKeys [][]byte
+	var staleBoardKeys []string
+
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		expiry := tx.Bucket(expiryBucket)
+		boards := tx.Bucket(boardsBucket)
+		cursor := expiry.Cursor()
+
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if bytes.Compare(k[:expiryTimestampLen], now) > 0 {
+				break
+			}
+
+			staleExpiryKeys = append(staleExpiryKeys, append([]byte(nil), k...))
+			staleBoardKeys = append(staleBoardKeys, string(v))
+		}
+
+		for _, k := range staleExpiryKeys {
+			if err := expiry.Delete(k); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		for _, key := range staleBoardKeys {
+			if err := boards.Delete([]byte(key)); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		return nil
+	}); err != nil {
+		s.logger.Errorf(s.name+": Error reaping: %v", err)
+		return 0
+	}
+
+	for _, key := range staleBoardKeys {
+		_ = s.memoryStore.Delete(context.Background(), key)
+	}
+
+	s.logger.Infof(s.name+": Reaped %d board(s)", len(staleBoardKeys))
+
+	return len(staleBoardKeys)
+}
+
+// expiryTimestampBytes encodes t as a sortable big-endian byte string for
+// use as an expiryBucket key prefix.
+func expiryTimestampBytes(t time.Time) []byte {
+	buf := make([]byte, expiryTimestampLen)
+	binary.BigEndian.PutUint64(buf, uint64(t.UnixNano()))
+	return buf
+}
+
+// expiryKey builds the composite expiryBucket key for a board with the
+// given timestamp and public key: its expiry instant (timestamp plus
+// nsstore.MaxContentAge) followed by the key itself, so that boards
+// expiring at the same instant don't collide.
+func expiryKey(timestamp time.Time, key string) []byte {
+	return append(expiryTimestampBytes(timestamp.Add(nsstore.MaxContentAge)), []byte(key)...)
+}
@@ -0,0 +1,261 @@
+// Package nsdiskstore implements nsstore's `BoardStore` interface on top of
+// BoltDB so that boards survive a server restart. It's meant as the
+// small-scale, single-node alternative to nsgcpstoragestore: no external
+// service to configure, just a file on disk.
+package nsdiskstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+// boardsBucket is the single BoltDB bucket that all boards are stored under,
+// keyed by their Spring '83 public key.
+var boardsBucket = []byte("boards")
+
+// DiskStore persists boards to a BoltDB file on disk. It's safe for
+// concurrent use -- BoltDB serializes writers internally and allows any
+// number of concurrent readers.
+type DiskStore struct {
+	db              *bbolt.DB
+	logger          *logrus.Logger
+	name            string
+	path            string
+	reapLoopStarted bool
+	timeNow         func() time.Time
+}
+
+// NewDiskStore opens (creating if necessary) a BoltDB file at path and
+// returns a DiskStore backed by it. Callers are responsible for calling
+// Close when the store is no longer needed.
+func NewDiskStore(logger *logrus.Logger, path string) (*DiskStore, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, xerrors.Errorf("error opening bolt db at %q: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boardsBucket)
+		return err //nolint:wrapcheck
+	}); err != nil {
+		return nil, xerrors.Errorf("error creating boards bucket: %w", err)
+	}
+
+	return &DiskStore{
+		db:      db,
+		logger:  logger,
+		name:    reflect.TypeOf(DiskStore{}).Name(),
+		path:    path,
+		timeNow: time.Now,
+	}, nil
+}
+
+// Close closes the underlying BoltDB file.
+func (s *DiskStore) Close() error {
+	return s.db.Close() //nolint:wrapcheck
+}
+
+func (s *DiskStore) Get(_ context.Context, key string) (*nsstore.Board, error) {
+	var board *nsstore.Board
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(boardsBucket).Get([]byte(key))
+		if data == nil {
+			return nsstore.ErrKeyNotFound
+		}
+
+		board = new(nsstore.Board)
+		return json.Unmarshal(data, board)
+	}); err != nil {
+		if errors.Is(err, nsstore.ErrKeyNotFound) {
+			return nil, err
+		}
+
+		return nil, xerrors.Errorf("error reading key %q: %w", key, err)
+	}
+
+	// Just in case the reap loop is behind, aggressively prune possibly
+	// outdated content.
+	if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+		s.logger.Infof(s.name+": Returning not found for stale key %q created %v", key, board.Timestamp)
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	return board, nil
+}
+
+// Put stores board under key. The write happens inside a single BoltDB
+// transaction that also reads any board already stored at key, so that the
+// "timestamp older than current" check and the write it guards are atomic
+// even under concurrent Puts for the same key.
+func (s *DiskStore) Put(_ context.Context, key string, board *nsstore.Board) error {
+	data, err := json.Marshal(board)
+	if err != nil {
+		return xerrors.Errorf("error encoding board: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error { //nolint:wrapcheck
+		bucket := tx.Bucket(boardsBucket)
+
+		if existing := bucket.Get([]byte(key)); existing != nil {
+			existingBoard := new(nsstore.Board)
+			if err := json.Unmarshal(existing, existingBoard); err != nil {
+				return xerrors.Errorf("error decoding existing board: %w", err)
+			}
+
+			if existingBoard.Timestamp.After(board.Timestamp) {
+				return nsstore.ErrTimestampOlderThanCurrent
+			}
+		}
+
+		return bucket.Put([]byte(key), data) //nolint:wrapcheck
+	})
+}
+
+// Iterate walks every board currently in the store, invoking fn once for
+// each key.
+func (s *DiskStore) Iterate(_ context.Context, fn func(key string, board *nsstore.Board) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error { //nolint:wrapcheck
+		return tx.Bucket(boardsBucket).ForEach(func(k, v []byte) error {
+			board := new(nsstore.Board)
+			if err := json.Unmarshal(v, board); err != nil {
+				return xerrors.Errorf("error decoding board for key %q: %w", k, err)
+			}
+
+			return fn(string(k), board)
+		})
+	})
+}
+
+// Delete permanently removes the board stored at key, if any.
+func (s *DiskStore) Delete(_ context.Context, key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error { //nolint:wrapcheck
+		return tx.Bucket(boardsBucket).Delete([]byte(key)) //nolint:wrapcheck
+	})
+}
+
+// Count returns the number of boards currently in the store.
+func (s *DiskStore) Count(_ context.Context) (int, error) {
+	var count int
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		count = tx.Bucket(boardsBucket).Stats().KeyN
+		return nil
+	}); err != nil {
+		return 0, xerrors.Errorf("error reading bucket stats: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReapLoop starts a reaper forever loop that periodically cleans up expired
+// keys. It blocks, so should be started on a goroutine.
+func (s *DiskStore) ReapLoop(_ context.Context, shutdown <-chan struct{}) {
+	if s.reapLoopStarted {
+		panic("ReapLoop already started -- should only be run once")
+	}
+
+	s.reapLoopStarted = true
+
+	for {
+		_ = s.reap()
+
+		select {
+		case <-shutdown:
+			s.logger.Info(s.name + ": Received shutdown signal")
+			return
+
+		case <-time.After(1 * time.Minute):
+		}
+	}
+}
+
+// For testing purposes only.
+func (s *DiskStore) SetTimeNow(timeNow func() time.Time) {
+	s.timeNow = timeNow
+}
+
+// Stats is the set of figures a DiskStore can report about itself so that the
+// server can surface them as metrics.
+type Stats struct {
+	NumBoards   int
+	OnDiskBytes int64
+}
+
+// Stats returns the current number of live boards and the size in bytes of
+// the store's underlying file on disk.
+func (s *DiskStore) Stats() (*Stats, error) {
+	stats := &Stats{}
+
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		stats.NumBoards = tx.Bucket(boardsBucket).Stats().KeyN
+		return nil
+	}); err != nil {
+		return nil, xerrors.Errorf("error reading bucket stats: %w", err)
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, xerrors.Errorf("error statting %q: %w", s.path, err)
+	}
+	stats.OnDiskBytes = info.Size()
+
+	return stats, nil
+}
+
+func (s *DiskStore) reap() int {
+	now := s.timeNow()
+	var numReaped, total int
+
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boardsBucket)
+		cursor := bucket.Cursor()
+
+		var staleKeys [][]byte
+
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			board := new(nsstore.Board)
+			if err := json.Unmarshal(v, board); err != nil {
+				return xerrors.Errorf("error decoding board for key %q: %w", k, err)
+			}
+
+			if now.After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+				// Can't delete through the cursor while deciding what to
+				// delete based on its own iteration, so collect keys and
+				// delete them in a second pass.
+				staleKeys = append(staleKeys, append([]byte(nil), k...))
+			}
+		}
+
+		for _, k := range staleKeys {
+			if err := bucket.Delete(k); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		numReaped = len(staleKeys)
+		total = bucket.Stats().KeyN - numReaped
+
+		return nil
+	}); err != nil {
+		s.logger.Errorf(s.name+": Error reaping: %v", err)
+		return 0
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"num_reaped": numReaped,
+		"total":      total,
+	}).Infof(s.name+": Reaped %d board(s) [total: %d]", numReaped, total)
+
+	return numReaped
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/googleapis/gax-go"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
 	"github.com/brandur/neospring/internal/nsstore"
@@ -30,9 +31,11 @@ type GCPStorageStore struct {
 	storageClient *storage.Client
 
 	// All for purposes of testability.
-	storageReader func(ctx context.Context, bucket, key string) (io.ReadCloser, error)
-	storageWriter func(ctx context.Context, bucket, key string) io.WriteCloser
-	timeNow       func() time.Time
+	storageReader  func(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	storageWriter  func(ctx context.Context, bucket, key string) io.WriteCloser
+	storageObjects func(ctx context.Context, bucket string) *storage.ObjectIterator
+	storageDeleter func(ctx context.Context, bucket, key string) error
+	timeNow        func() time.Time
 }
 
 func NewGCPStorageStore(ctx context.Context, logger *logrus.Logger, serviceAccountJSON, bucket string) *GCPStorageStore { //nolint:lll
@@ -52,7 +55,7 @@ func NewGCPStorageStore(ctx context.Context, logger *logrus.Logger, serviceAccou
 	return &GCPStorageStore{
 		bucket:        bucket,
 		logger:        logger,
-		memoryStore:   nsmemorystore.NewMemoryStore(logger),
+		memoryStore:   nsmemorystore.NewMemoryStore(logger, 0),
 		name:          reflect.TypeOf(GCPStorageStore{}).Name(),
 		storageClient: storageClient,
 		storageReader: func(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
@@ -61,6 +64,12 @@ func NewGCPStorageStore(ctx context.Context, logger *logrus.Logger, serviceAccou
 		storageWriter: func(ctx context.Context, bucket, key string) io.WriteCloser {
 			return storageClient.Bucket(bucket).Object(key).NewWriter(ctx)
 		},
+		storageObjects: func(ctx context.Context, bucket string) *storage.ObjectIterator {
+			return storageClient.Bucket(bucket).Objects(ctx, nil)
+		},
+		storageDeleter: func(ctx context.Context, bucket, key string) error {
+			return storageClient.Bucket(bucket).Object(key).Delete(ctx) //nolint:wrapcheck
+		},
 		timeNow: time.Now,
 	}
 }
@@ -139,6 +148,69 @@ func (s *GCPStorageStore) ReapLoop(ctx context.Context, shutdown <-chan struct{}
 	s.memoryStore.ReapLoop(ctx, shutdown)
 }
 
+// Iterate walks every board in the bucket, invoking fn once for each key.
+// Unlike Get, this always reads through to GCP storage rather than
+// consulting the local memory cache, since the cache may not hold every
+// object that's ever been written.
+func (s *GCPStorageStore) Iterate(ctx context.Context, fn func(key string, board *nsstore.Board) error) error {
+	it := s.storageObjects(ctx, s.bucket)
+
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return xerrors.Errorf("error listing objects in bucket %q: %w", s.bucket, err)
+		}
+
+		reader, err := s.storageReader(ctx, s.bucket, attrs.Name)
+		if err != nil {
+			return xerrors.Errorf("error getting reader for key %q: %w", attrs.Name, err)
+		}
+
+		var storageBoard serializedBoard
+		err = json.NewDecoder(reader).Decode(&storageBoard)
+		reader.Close()
+		if err != nil {
+			return xerrors.Errorf("error decoding board for key %q: %w", attrs.Name, err)
+		}
+
+		if err := fn(attrs.Name, storageBoard.ToBoard()); err != nil {
+			return err
+		}
+	}
+}
+
+// Delete permanently removes the board stored at key, if any, from both GCP
+// storage and the local memory cache.
+func (s *GCPStorageStore) Delete(ctx context.Context, key string) error {
+	if err := s.storageDeleter(ctx, s.bucket, key); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return xerrors.Errorf("error deleting key %q: %w", key, err)
+	}
+
+	return s.memoryStore.Delete(ctx, key)
+}
+
+// Count returns the number of objects currently in the bucket. Like Iterate,
+// this reads through to GCP storage rather than the local memory cache.
+func (s *GCPStorageStore) Count(ctx context.Context) (int, error) {
+	it := s.storageObjects(ctx, s.bucket)
+
+	var count int
+	for {
+		_, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return count, nil
+		}
+		if err != nil {
+			return 0, xerrors.Errorf("error listing objects in bucket %q: %w", s.bucket, err)
+		}
+
+		count++
+	}
+}
+
 // Very similar to `nsstore.Board`, but a specific serialized format stored to a
 // GCP key as an object.
 type serializedBoard struct {
@@ -3,26 +3,46 @@ package nsmemorystore
 import (
 	"context"
 	"reflect"
-	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/sirupsen/logrus"
 
 	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/metrics"
 )
 
+// DefaultMaxEntries bounds how many boards a MemoryStore holds onto before
+// evicting the least recently used, so a store that's busy fronting a
+// slower backend (GCP, S3, Bolt) can't grow memory without bound while
+// waiting for the reap loop to catch up.
+const DefaultMaxEntries = 100_000
+
 type MemoryStore struct {
-	boards          map[string]*nsstore.Board
+	boards          *lru.Cache[string, *nsstore.Board]
 	logger          *logrus.Logger
-	mut             sync.RWMutex
 	name            string
 	reapLoopStarted bool
 	timeNow         func() time.Time
 }
 
-func NewMemoryStore(logger *logrus.Logger) *MemoryStore {
+// NewMemoryStore returns a MemoryStore bounded to maxEntries boards,
+// evicting the least recently used once full. A maxEntries of 0 falls back
+// to DefaultMaxEntries.
+func NewMemoryStore(logger *logrus.Logger, maxEntries int) *MemoryStore {
+	if maxEntries < 1 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	boards, err := lru.New[string, *nsstore.Board](maxEntries)
+	if err != nil {
+		// The only error New returns is for a non-positive size, which
+		// maxEntries never is after the fallback above.
+		panic(err)
+	}
+
 	return &MemoryStore{
-		boards:  make(map[string]*nsstore.Board),
+		boards:  boards,
 		logger:  logger,
 		name:    reflect.TypeOf(MemoryStore{}).Name(),
 		timeNow: time.Now,
@@ -30,32 +50,62 @@ func NewMemoryStore(logger *logrus.Logger) *MemoryStore {
 }
 
 func (s *MemoryStore) Get(_ context.Context, key string) (*nsstore.Board, error) {
-	s.mut.RLock()
-	defer s.mut.RUnlock()
-
-	board, ok := s.boards[key]
+	board, ok := s.boards.Get(key)
 	if !ok {
+		metrics.MemoryCacheOperationsTotal.WithLabelValues("miss").Inc()
 		return nil, nsstore.ErrKeyNotFound
 	}
 
-	// Just in case the cleaner is behind, aggressively prune possibly outdated
-	// content.
+	// Just in case the cleaner is behind, aggressively prune possibly
+	// outdated content.
 	if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
 		s.logger.Infof(s.name+": Returning not found for stale key %q created %v", key, board.Timestamp)
+		metrics.MemoryCacheOperationsTotal.WithLabelValues("miss").Inc()
+		metrics.MemoryCacheStalePrunedTotal.Inc()
 		return nil, nsstore.ErrKeyNotFound
 	}
 
+	metrics.MemoryCacheOperationsTotal.WithLabelValues("hit").Inc()
+
 	return board, nil
 }
 
 func (s *MemoryStore) Put(_ context.Context, key string, board *nsstore.Board) error {
-	s.mut.Lock()
-	defer s.mut.Unlock()
+	if evicted := s.boards.Add(key, board); evicted {
+		metrics.MemoryCacheEvictionsTotal.Inc()
+	}
 
-	s.boards[key] = board
 	return nil
 }
 
+// Iterate walks every board currently in the store, invoking fn once for
+// each key. Unlike Get, this doesn't affect the LRU's recency ordering.
+func (s *MemoryStore) Iterate(_ context.Context, fn func(key string, board *nsstore.Board) error) error {
+	for _, key := range s.boards.Keys() {
+		board, ok := s.boards.Peek(key)
+		if !ok {
+			continue
+		}
+
+		if err := fn(key, board); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete permanently removes the board stored at key, if any.
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.boards.Remove(key)
+	return nil
+}
+
+// Count returns the number of boards currently in the store.
+func (s *MemoryStore) Count(_ context.Context) (int, error) {
+	return s.boards.Len(), nil
+}
+
 // ReapLoop starts a reaper forever loop that periodically cleans up expired
 // keys. It blocks, so should be started on a goroutine.
 func (s *MemoryStore) ReapLoop(_ context.Context, shutdown <-chan struct{}) {
@@ -70,7 +120,7 @@ func (s *MemoryStore) ReapLoop(_ context.Context, shutdown <-chan struct{}) {
 
 		select {
 		case <-shutdown:
-			s.logger.Infof(s.name + ": Received shutdown signal")
+			s.logger.Info(s.name + ": Received shutdown signal")
 			return
 
 		case <-time.After(1 * time.Minute):
@@ -84,23 +134,27 @@ func (s *MemoryStore) SetTimeNow(timeNow func() time.Time) {
 }
 
 func (s *MemoryStore) reap() int {
-	s.mut.Lock()
-	defer s.mut.Unlock()
-
 	now := s.timeNow()
 	var numReaped int
 
-	for key, board := range s.boards {
+	for _, key := range s.boards.Keys() {
+		board, ok := s.boards.Peek(key)
+		if !ok {
+			continue
+		}
+
 		if now.After(board.Timestamp.Add(nsstore.MaxContentAge)) {
-			delete(s.boards, key)
+			s.boards.Remove(key)
 			numReaped++
 		}
 	}
 
+	metrics.MemoryCacheReapTotal.Add(float64(numReaped))
+
 	s.logger.WithFields(logrus.Fields{
 		"num_reaped": numReaped,
-		"total":      len(s.boards),
-	}).Infof(s.name+": Reaped %d board(s) [total: %d]", numReaped, len(s.boards))
+		"total":      s.boards.Len(),
+	}).Infof(s.name+": Reaped %d board(s) [total: %d]", numReaped, s.boards.Len())
 
 	return numReaped
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/brandur/neospring/internal/nskey"
 	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsstoretest"
 )
 
 const (
@@ -21,46 +22,15 @@ const (
 var logger = logrus.New()
 
 func TestMemoryBoardStore(t *testing.T) {
-	ctx := context.Background()
-	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
-	store := NewMemoryStore(logger)
-	store.SetTimeNow(func() time.Time { return stableTime })
-
-	// Nothing stored initially.
-	{
-		_, err := store.Get(ctx, keyPair.PublicKey)
-		require.ErrorIs(t, nsstore.ErrKeyNotFound, err)
-	}
-
-	const content = "some board content"
-	board := &nsstore.Board{
-		Content:   []byte(content),
-		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
-		Timestamp: stableTime,
-	}
-	err := store.Put(ctx, keyPair.PublicKey, board)
-	require.NoError(t, err)
-
-	// After putting content, we now get the same content back.
-	{
-		boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
-		require.NoError(t, err)
-		require.Equal(t, board, boardFromStore)
-	}
-
-	// When pushing time far into the future so that the content is after it's
-	// expiry, content is considered not present again.
-	{
-		store.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
-		_, err := store.Get(ctx, keyPair.PublicKey)
-		require.ErrorIs(t, nsstore.ErrKeyNotFound, err)
-	}
+	nsstoretest.RunConformance(t, func() nsstoretest.TimeSettableStore {
+		return NewMemoryStore(logger, 0)
+	})
 }
 
 func TestMemoryBoardStoreReap(t *testing.T) {
 	ctx := context.Background()
 	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
-	store := NewMemoryStore(logger)
+	store := NewMemoryStore(logger, 0)
 
 	const content = "some board content"
 	board := &nsstore.Board{
@@ -70,20 +40,20 @@ func TestMemoryBoardStoreReap(t *testing.T) {
 	}
 	err := store.Put(ctx, keyPair.PublicKey, board)
 	require.NoError(t, err)
-	require.Len(t, store.boards, 1)
+	require.Equal(t, 1, store.boards.Len())
 
 	// Move into the future
 	store.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
 
 	numReaped := store.reap()
 	require.Equal(t, 1, numReaped)
-	require.Len(t, store.boards, 0)
+	require.Equal(t, 0, store.boards.Len())
 }
 
 func TestMemoryBoardStoreReapLoop(t *testing.T) {
 	ctx := context.Background()
 	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
-	store := NewMemoryStore(logger)
+	store := NewMemoryStore(logger, 0)
 
 	const content = "some board content"
 	board := &nsstore.Board{
@@ -93,7 +63,7 @@ func TestMemoryBoardStoreReapLoop(t *testing.T) {
 	}
 	err := store.Put(ctx, keyPair.PublicKey, board)
 	require.NoError(t, err)
-	require.Len(t, store.boards, 1)
+	require.Equal(t, 1, store.boards.Len())
 
 	// Move into the future
 	store.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
@@ -105,7 +75,31 @@ func TestMemoryBoardStoreReapLoop(t *testing.T) {
 	// shutdown, and exit.
 	store.ReapLoop(ctx, shutdown)
 
-	require.Len(t, store.boards, 0)
+	require.Equal(t, 0, store.boards.Len())
+}
+
+func TestMemoryBoardStoreMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(logger, 2)
+
+	for _, key := range []string{"key-a", "key-b", "key-c"} {
+		board := &nsstore.Board{
+			Content:   []byte("some board content"),
+			Signature: "deadbeef",
+			Timestamp: time.Now(),
+		}
+		require.NoError(t, store.Put(ctx, key, board))
+	}
+
+	require.Equal(t, 2, store.boards.Len())
+
+	// key-a was the least recently used, so it's the one evicted to make
+	// room for key-c.
+	_, err := store.Get(ctx, "key-a")
+	require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+
+	_, err = store.Get(ctx, "key-c")
+	require.NoError(t, err)
 }
 
 var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
@@ -0,0 +1,278 @@
+// Package nsredisstore implements nsstore's `BoardStore` interface on top of
+// Redis, so that a fleet of neospring instances behind a load balancer can
+// share a single store rather than each holding its own disjoint set of
+// boards. Expiration is delegated to Redis' own EXPIREAT rather than a reap
+// loop, and every write is broadcast on a pub/sub channel so that an
+// instance fronting RedisStore with an in-memory cache (see
+// nsstore.NewCacheStore) can keep that cache in sync with writes made
+// through its peers.
+package nsredisstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+// updatesChannel is published to on every successful Put, and subscribed to
+// by SubscribeLoop, so that a write made through one instance is reflected
+// in every other instance's in-memory cache.
+const updatesChannel = "spring83:updates"
+
+// boardKeyPrefix namespaces board hashes within a Redis keyspace that may be
+// shared with other applications.
+const boardKeyPrefix = "spring83:board:"
+
+// scanCount is the COUNT hint passed to Redis' SCAN, balancing the number of
+// round trips Iterate and Count need against how much work each one asks
+// Redis to do per call.
+const scanCount = 100
+
+// RedisStore persists boards to Redis, relying on Redis' own EXPIREAT to
+// expire content rather than running a reap loop of its own.
+type RedisStore struct {
+	client  *redis.Client
+	logger  *logrus.Logger
+	name    string
+	timeNow func() time.Time
+}
+
+// NewRedisStore returns a RedisStore backed by client.
+func NewRedisStore(logger *logrus.Logger, client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client:  client,
+		logger:  logger,
+		name:    reflect.TypeOf(RedisStore{}).Name(),
+		timeNow: time.Now,
+	}
+}
+
+// Get returns the board stored at key, reading through to Redis.
+func (s *RedisStore) Get(ctx context.Context, key string) (*nsstore.Board, error) {
+	values, err := s.client.HGetAll(ctx, boardKey(key)).Result()
+	if err != nil {
+		return nil, xerrors.Errorf("error reading key %q: %w", key, err)
+	}
+
+	if len(values) == 0 {
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	board, err := boardFromHash(values)
+	if err != nil {
+		return nil, xerrors.Errorf("error decoding board for key %q: %w", key, err)
+	}
+
+	// Just in case Redis' own expiration is behind, aggressively prune
+	// possibly outdated content.
+	if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+		s.logger.Infof(s.name+": Returning not found for stale key %q created %v", key, board.Timestamp)
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	return board, nil
+}
+
+// Put stores board under key as a Redis hash, set to expire natively via
+// EXPIREAT at board's timestamp plus nsstore.MaxContentAge, then publishes
+// key on updatesChannel so that peer instances can refresh their caches. The
+// read-compare-write is wrapped in a WATCH transaction since, unlike a
+// single-process store, Redis writers can genuinely race for the same key.
+func (s *RedisStore) Put(ctx context.Context, key string, board *nsstore.Board) error {
+	redisKey := boardKey(key)
+
+	// Expressed as a TTL relative to timeNow rather than an absolute EXPIREAT
+	// so that it's still meaningful when timeNow is overridden away from the
+	// real wall clock (as SetTimeNow does in tests): an old board.Timestamp
+	// shouldn't translate into an EXPIREAT so far in the past that Redis
+	// deletes it the instant it's written.
+	ttl := board.Timestamp.Add(nsstore.MaxContentAge).Sub(s.timeNow())
+
+	txf := func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(ctx, redisKey).Result()
+		if err != nil {
+			return xerrors.Errorf("error reading existing key %q: %w", key, err)
+		}
+
+		if len(values) > 0 {
+			existing, err := boardFromHash(values)
+			if err != nil {
+				return xerrors.Errorf("error decoding existing board for key %q: %w", key, err)
+			}
+
+			if existing.Timestamp.After(board.Timestamp) {
+				return nsstore.ErrTimestampOlderThanCurrent
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisKey, hashFromBoard(board))
+			pipe.Expire(ctx, redisKey, ttl)
+			return nil
+		})
+
+		return err //nolint:wrapcheck
+	}
+
+	if err := s.client.Watch(ctx, txf, redisKey); err != nil {
+		if errors.Is(err, nsstore.ErrTimestampOlderThanCurrent) {
+			return err
+		}
+
+		return xerrors.Errorf("error writing key %q: %w", key, err)
+	}
+
+	if err := s.client.Publish(ctx, updatesChannel, key).Err(); err != nil {
+		// A missed cache-invalidation is recoverable (the next read through
+		// that peer will eventually notice the board via its own TTL or a
+		// later write), so this is worth logging but not worth failing Put
+		// over.
+		s.logger.Warnf(s.name+": Error publishing update for key %q: %v", key, err)
+	}
+
+	return nil
+}
+
+// Iterate walks every board currently in the store, invoking fn once for
+// each key.
+func (s *RedisStore) Iterate(ctx context.Context, fn func(key string, board *nsstore.Board) error) error {
+	iter := s.client.Scan(ctx, 0, boardKeyPrefix+"*", scanCount).Iterator()
+
+	for iter.Next(ctx) {
+		redisKey := iter.Val()
+
+		values, err := s.client.HGetAll(ctx, redisKey).Result()
+		if err != nil {
+			return xerrors.Errorf("error reading key %q: %w", redisKey, err)
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		board, err := boardFromHash(values)
+		if err != nil {
+			return xerrors.Errorf("error decoding board for key %q: %w", redisKey, err)
+		}
+
+		if err := fn(strings.TrimPrefix(redisKey, boardKeyPrefix), board); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err() //nolint:wrapcheck
+}
+
+// Delete permanently removes the board stored at key, if any. A no-op, not
+// an error, if key doesn't exist.
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, boardKey(key)).Err(); err != nil {
+		return xerrors.Errorf("error deleting key %q: %w", key, err)
+	}
+
+	return nil
+}
+
+// Count returns the number of boards currently in the store.
+func (s *RedisStore) Count(ctx context.Context) (int, error) {
+	var count int
+
+	iter := s.client.Scan(ctx, 0, boardKeyPrefix+"*", scanCount).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+
+	if err := iter.Err(); err != nil {
+		return 0, xerrors.Errorf("error scanning keys: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReapLoop is a no-op: expiration is delegated to Redis via the EXPIREAT set
+// on every Put, so there's no sweeping for RedisStore itself to do. See
+// SubscribeLoop for the forever loop RedisStore does need running.
+func (s *RedisStore) ReapLoop(context.Context, <-chan struct{}) {}
+
+// SubscribeLoop subscribes to updatesChannel and invokes onUpdate with the
+// board most recently written under each key it's notified of, so that a
+// cache fronting this store (see nsstore.NewCacheStore) stays in sync with
+// writes made by peer instances sharing the same Redis deployment. It
+// blocks, so should be started on a goroutine, and returns once shutdown is
+// closed.
+func (s *RedisStore) SubscribeLoop(ctx context.Context, shutdown <-chan struct{}, onUpdate func(key string, board *nsstore.Board)) {
+	pubsub := s.client.Subscribe(ctx, updatesChannel)
+	defer pubsub.Close() //nolint:errcheck
+
+	messages := pubsub.Channel()
+
+	for {
+		select {
+		case <-shutdown:
+			s.logger.Info(s.name + ": Received shutdown signal")
+			return
+
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			key := msg.Payload
+
+			board, err := s.Get(ctx, key)
+			if err != nil {
+				if !errors.Is(err, nsstore.ErrKeyNotFound) {
+					s.logger.Warnf(s.name+": Error refreshing cache for key %q: %v", key, err)
+				}
+
+				continue
+			}
+
+			onUpdate(key, board)
+		}
+	}
+}
+
+// SetTimeNow overrides the time RedisStore considers "now". For testing
+// purposes only.
+func (s *RedisStore) SetTimeNow(timeNow func() time.Time) {
+	s.timeNow = timeNow
+}
+
+func boardKey(key string) string {
+	return boardKeyPrefix + key
+}
+
+// hashFromBoard builds the field/value pairs Put stores board's fields
+// under. Timestamp is encoded as RFC3339Nano text rather than a bare Unix
+// integer so that it round-trips through boardFromHash byte-for-byte via
+// time.Parse, the same way encoding/json's time.Time (un)marshaling does
+// elsewhere in this codebase.
+func hashFromBoard(board *nsstore.Board) map[string]any {
+	return map[string]any{
+		"content":   board.Content,
+		"signature": board.Signature,
+		"timestamp": board.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func boardFromHash(values map[string]string) (*nsstore.Board, error) {
+	timestamp, err := time.Parse(time.RFC3339Nano, values["timestamp"])
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing timestamp: %w", err)
+	}
+
+	return &nsstore.Board{
+		Content:   []byte(values["content"]),
+		Signature: values["signature"],
+		Timestamp: timestamp,
+	}, nil
+}
@@ -0,0 +1,107 @@
+package nsredisstore
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsstoretest"
+)
+
+const samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+
+var (
+	logger     = logrus.New()
+	stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+)
+
+func newTestStore(t *testing.T) *RedisStore {
+	t.Helper()
+
+	miniredisServer := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: miniredisServer.Addr()})
+	t.Cleanup(func() { require.NoError(t, client.Close()) })
+
+	return NewRedisStore(logger, client)
+}
+
+func TestRedisBoardStore(t *testing.T) {
+	nsstoretest.RunConformance(t, func() nsstoretest.TimeSettableStore {
+		return newTestStore(t)
+	})
+}
+
+func TestRedisBoardStorePutStaleTimestampRejected(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := newTestStore(t)
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, store.Put(ctx, keyPair.PublicKey, board))
+
+	staleBoard := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime.Add(-1 * time.Minute),
+	}
+	err := store.Put(ctx, keyPair.PublicKey, staleBoard)
+	require.ErrorIs(t, err, nsstore.ErrTimestampOlderThanCurrent)
+
+	// The original board is still the one on record.
+	boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, board, boardFromStore)
+}
+
+func TestRedisBoardStoreSubscribeLoop(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := newTestStore(t)
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	updates := make(chan *nsstore.Board, 1)
+	shutdown := make(chan struct{})
+
+	go store.SubscribeLoop(ctx, shutdown, func(_ string, board *nsstore.Board) {
+		updates <- board
+	})
+
+	// Give the subscriber a moment to establish itself before publishing, or
+	// the Put below may race it and never be seen.
+	require.Eventually(t, func() bool {
+		return store.client.Publish(ctx, updatesChannel, "ping").Val() > 0 ||
+			store.client.PubSubNumSub(ctx, updatesChannel).Val()[updatesChannel] > 0
+	}, time.Second, time.Millisecond)
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, store.Put(ctx, keyPair.PublicKey, board))
+
+	select {
+	case got := <-updates:
+		require.Equal(t, board, got)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for subscribed update")
+	}
+
+	close(shutdown)
+}
@@ -0,0 +1,343 @@
+// Package nss3store implements nsstore's `BoardStore` interface for any
+// S3-compatible object storage endpoint (AWS S3, MinIO, Backblaze B2,
+// Cloudflare R2, ...). Unlike nsgcpstoragestore, its ReapLoop doesn't rely on
+// an out-of-band bucket lifecycle policy -- it actively lists and deletes
+// expired objects itself, since many S3-compatible providers don't offer
+// lifecycle rules.
+package nss3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+// DefaultReapBatchSize is the number of keys requested per ListObjectsV2 page
+// while reaping expired objects.
+const DefaultReapBatchSize = 1000
+
+type S3Store struct {
+	bucket      string
+	logger      *logrus.Logger
+	memoryStore *nsmemorystore.MemoryStore
+	name        string
+	s3Client    *s3.Client
+
+	// All for purposes of testability.
+	storageReader  func(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	storageWriter  func(ctx context.Context, bucket, key string, body []byte) error
+	storageLister  func(ctx context.Context, bucket string) *s3.ListObjectsV2Paginator
+	storageDeleter func(ctx context.Context, bucket, key string) error
+	timeNow        func() time.Time
+}
+
+// NewS3Store builds an S3Store targeting bucket on the given endpoint and
+// region, authenticating with the given static credentials. endpoint may be
+// left empty to target AWS S3 itself; for any other S3-compatible provider
+// (MinIO, B2, R2, ...), it should be that provider's API base URL, in which
+// case path-style addressing is used since most non-AWS providers don't
+// support virtual-hosted-style bucket addressing.
+func NewS3Store(ctx context.Context, logger *logrus.Logger, endpoint, region, accessKeyID, secretAccessKey, bucket string) *S3Store { //nolint:lll
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(region),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &S3Store{
+		bucket:      bucket,
+		logger:      logger,
+		memoryStore: nsmemorystore.NewMemoryStore(logger, 0),
+		name:        reflect.TypeOf(S3Store{}).Name(),
+		s3Client:    s3Client,
+		storageReader: func(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+			out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+			if err != nil {
+				return nil, err //nolint:wrapcheck
+			}
+			return out.Body, nil
+		},
+		storageWriter: func(ctx context.Context, bucket, key string, body []byte) error {
+			_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket: &bucket,
+				Key:    &key,
+				Body:   bytes.NewReader(body),
+			})
+			return err //nolint:wrapcheck
+		},
+		storageLister: func(ctx context.Context, bucket string) *s3.ListObjectsV2Paginator {
+			maxKeys := int32(DefaultReapBatchSize)
+			return s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+				Bucket:  &bucket,
+				MaxKeys: &maxKeys,
+			})
+		},
+		storageDeleter: func(ctx context.Context, bucket, key string) error {
+			_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+			return err //nolint:wrapcheck
+		},
+		timeNow: time.Now,
+	}
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (*nsstore.Board, error) {
+	// Check to see if we might have this cached in our memory store first
+	// before going to slower S3 storage.
+	board, err := s.memoryStore.Get(ctx, key)
+	if err == nil {
+		return board, nil
+	}
+
+	reader, err := s.storageReader(ctx, s.bucket, key)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nsstore.ErrKeyNotFound
+		}
+
+		return nil, xerrors.Errorf("error getting key reader: %w", err)
+	}
+	defer reader.Close()
+
+	var storageBoard serializedBoard
+	if err := json.NewDecoder(reader).Decode(&storageBoard); err != nil {
+		return nil, xerrors.Errorf("error decoding board: %w", err)
+	}
+
+	// Just in case a reap cycle is behind, aggressively prune possibly
+	// outdated content.
+	if s.timeNow().After(storageBoard.Timestamp.Add(nsstore.MaxContentAge)) {
+		s.logger.Infof(s.name+": Returning not found for stale key %q created %v", key, storageBoard.Timestamp)
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	board = storageBoard.ToBoard()
+
+	if err := s.memoryStore.Put(ctx, key, board); err != nil {
+		return nil, err
+	}
+
+	return board, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, board *nsstore.Board) error {
+	encoded, err := json.Marshal(serializedBoardFrom(board))
+	if err != nil {
+		return xerrors.Errorf("error encoding board: %w", err)
+	}
+
+	if err := s.storageWriter(ctx, s.bucket, key, encoded); err != nil {
+		return xerrors.Errorf("error writing object: %w", err)
+	}
+
+	s.logger.Infof(s.name+": Stored key %q to S3 storage", key)
+
+	if err := s.memoryStore.Put(ctx, key, board); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// For testing purposes only.
+func (s *S3Store) SetTimeNow(timeNow func() time.Time) {
+	s.memoryStore.SetTimeNow(timeNow)
+	s.timeNow = timeNow
+}
+
+// ReapLoop starts a reaper forever loop that periodically cleans up expired
+// keys.
+//
+// Besides reaping the struct's internal memory store, this also lists the
+// bucket via ListObjectsV2 paging and issues a server-side DeleteObject for
+// any key whose board Timestamp is older than nsstore.MaxContentAge, so
+// operators don't need to configure a bucket lifecycle policy out-of-band.
+// It blocks, so should be started on a goroutine.
+func (s *S3Store) ReapLoop(ctx context.Context, shutdown <-chan struct{}) {
+	go s.memoryStore.ReapLoop(ctx, shutdown)
+
+	ticker := time.NewTicker(nsstore.DefaultSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			if err := s.reapExpiredObjects(ctx); err != nil {
+				s.logger.Warnf(s.name+": Error reaping expired objects: %v", err)
+			}
+		}
+	}
+}
+
+func (s *S3Store) reapExpiredObjects(ctx context.Context) error {
+	paginator := s.storageLister(ctx, s.bucket)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return xerrors.Errorf("error listing objects in bucket %q: %w", s.bucket, err)
+		}
+
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+
+			// A single unreadable or corrupt object shouldn't starve reaping
+			// for the rest of the bucket -- log and move on to the next key
+			// rather than aborting the whole pass, since pagination always
+			// restarts from the beginning on the next tick and would
+			// otherwise get stuck on the same bad object forever.
+			reader, err := s.storageReader(ctx, s.bucket, *object.Key)
+			if err != nil {
+				s.logger.Warnf(s.name+": Error getting reader for key %q, skipping: %v", *object.Key, err)
+				continue
+			}
+
+			var storageBoard serializedBoard
+			err = json.NewDecoder(reader).Decode(&storageBoard)
+			reader.Close()
+			if err != nil {
+				s.logger.Warnf(s.name+": Error decoding board for key %q, skipping: %v", *object.Key, err)
+				continue
+			}
+
+			// Gate on the board's own logical Timestamp, the same field Get
+			// prunes staleness against above -- not the object's physical
+			// LastModified, which can trail Timestamp by up to
+			// TimestampTolerance (a PUT is accepted as long as Timestamp is
+			// within that tolerance of now() - MaxContentAge) and would
+			// otherwise let already-expired content sit in the bucket for up
+			// to another MaxContentAge.
+			if s.timeNow().After(storageBoard.Timestamp.Add(nsstore.MaxContentAge)) {
+				if err := s.storageDeleter(ctx, s.bucket, *object.Key); err != nil {
+					s.logger.Warnf(s.name+": Error deleting expired key %q, skipping: %v", *object.Key, err)
+					continue
+				}
+				s.logger.Infof(s.name+": Reaped expired key %q", *object.Key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Iterate walks every board in the bucket, invoking fn once for each key.
+// Unlike Get, this always reads through to S3 rather than consulting the
+// local memory cache, since the cache may not hold every object that's ever
+// been written.
+func (s *S3Store) Iterate(ctx context.Context, fn func(key string, board *nsstore.Board) error) error {
+	paginator := s.storageLister(ctx, s.bucket)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return xerrors.Errorf("error listing objects in bucket %q: %w", s.bucket, err)
+		}
+
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+
+			reader, err := s.storageReader(ctx, s.bucket, *object.Key)
+			if err != nil {
+				return xerrors.Errorf("error getting reader for key %q: %w", *object.Key, err)
+			}
+
+			var storageBoard serializedBoard
+			err = json.NewDecoder(reader).Decode(&storageBoard)
+			reader.Close()
+			if err != nil {
+				return xerrors.Errorf("error decoding board for key %q: %w", *object.Key, err)
+			}
+
+			if err := fn(*object.Key, storageBoard.ToBoard()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete permanently removes the board stored at key, if any, from both S3
+// and the local memory cache.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := s.storageDeleter(ctx, s.bucket, key); err != nil {
+		var noSuchKey *types.NoSuchKey
+		if !errors.As(err, &noSuchKey) {
+			return xerrors.Errorf("error deleting key %q: %w", key, err)
+		}
+	}
+
+	return s.memoryStore.Delete(ctx, key)
+}
+
+// Count returns the number of objects currently in the bucket. Like Iterate,
+// this reads through to S3 rather than the local memory cache.
+func (s *S3Store) Count(ctx context.Context) (int, error) {
+	paginator := s.storageLister(ctx, s.bucket)
+
+	var count int
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, xerrors.Errorf("error listing objects in bucket %q: %w", s.bucket, err)
+		}
+
+		count += len(page.Contents)
+	}
+
+	return count, nil
+}
+
+// Very similar to `nsstore.Board`, but a specific serialized format stored to
+// an S3 key as an object.
+type serializedBoard struct {
+	Content   []byte    `json:"content"`
+	Signature string    `json:"signature"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func serializedBoardFrom(b *nsstore.Board) *serializedBoard {
+	return &serializedBoard{
+		Content:   b.Content,
+		Signature: b.Signature,
+		Timestamp: b.Timestamp,
+	}
+}
+
+func (b *serializedBoard) ToBoard() *nsstore.Board {
+	return &nsstore.Board{
+		Content:   b.Content,
+		Signature: b.Signature,
+		Timestamp: b.Timestamp,
+	}
+}
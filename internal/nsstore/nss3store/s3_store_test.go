@@ -0,0 +1,281 @@
+package nss3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+const (
+	samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+	samplePublicKey  = "e90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1124"
+)
+
+var logger = logrus.New()
+
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+// For injecting a stable time into a server because eventually the sample key
+// we're using will expire, and if we were using `time.Now()`, that would start
+// failing all the tests.
+func stableTimeFunc() time.Time {
+	return stableTime
+}
+
+func TestS3StoreRead(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := NewS3Store(ctx, logger, "", "us-east-1", "key", "secret", "neospring_board")
+	store.SetTimeNow(stableTimeFunc)
+
+	store.storageReader = func(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+		require.Equal(t, "neospring_board", bucket)
+		require.Equal(t, samplePublicKey, key)
+		return nil, &types.NoSuchKey{}
+	}
+
+	{
+		_, err := store.Get(ctx, keyPair.PublicKey)
+		require.ErrorIs(t, nsstore.ErrKeyNotFound, err)
+	}
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+
+	var storageReaderCalled bool
+	store.storageReader = func(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+		require.Equal(t, "neospring_board", bucket)
+		require.Equal(t, samplePublicKey, key)
+
+		require.False(t, storageReaderCalled, "storageReader mock should only have been called once")
+		storageReaderCalled = true
+
+		return io.NopCloser(bytes.NewReader(mustJSONMarshal(t, board))), nil
+	}
+
+	{
+		boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
+		require.NoError(t, err)
+		require.Equal(t, board, boardFromStore)
+	}
+
+	// Call again. This result should come from the memory store.
+	{
+		boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
+		require.NoError(t, err)
+		require.Equal(t, board, boardFromStore)
+	}
+
+	// Set again to avoid the "only once" check.
+	store.storageReader = func(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(mustJSONMarshal(t, board))), nil
+	}
+
+	// When pushing time far into the future so that the content is after it's
+	// expiry, content is considered not present again.
+	{
+		store.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
+		_, err := store.Get(ctx, keyPair.PublicKey)
+		require.ErrorIs(t, nsstore.ErrKeyNotFound, err)
+	}
+}
+
+func TestS3StorePut(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := NewS3Store(ctx, logger, "", "us-east-1", "key", "secret", "neospring_board")
+	store.SetTimeNow(stableTimeFunc)
+
+	var written []byte
+	store.storageWriter = func(ctx context.Context, bucket, key string, body []byte) error {
+		require.Equal(t, "neospring_board", bucket)
+		require.Equal(t, samplePublicKey, key)
+
+		written = body
+		return nil
+	}
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	err := store.Put(ctx, keyPair.PublicKey, board)
+	require.NoError(t, err)
+
+	var boardFromStore serializedBoard
+	mustJSONUnmarshal(t, written, &boardFromStore)
+	require.Equal(t, board, boardFromStore.ToBoard())
+
+	// The put should have added the key to the internal memory store. Here we
+	// check that we're able to get it back out of there without having to go
+	// to S3.
+	{
+		store.storageReader = func(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+			require.Fail(t, "storageReader mock should not be called")
+			return nil, nil
+		}
+
+		boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
+		require.NoError(t, err)
+		require.Equal(t, board, boardFromStore)
+	}
+}
+
+// Reaping must key off the board's own Timestamp field, not the S3 object's
+// LastModified -- a board can be PUT with a Timestamp that's already close to
+// MaxContentAge old (per server.go's TimestampTolerance allowance), in which
+// case LastModified is fresh even though the board itself is already expired.
+func TestS3StoreReap(t *testing.T) {
+	ctx := context.Background()
+	store := NewS3Store(ctx, logger, "", "us-east-1", "key", "secret", "neospring_board")
+	store.SetTimeNow(stableTimeFunc)
+
+	const key = samplePublicKey
+
+	expiredBoard := &nsstore.Board{
+		Content:   []byte("some board content"),
+		Signature: "deadbeef",
+		Timestamp: stableTime.Add(-nsstore.MaxContentAge).Add(-time.Minute),
+	}
+	encoded := mustJSONMarshal(t, serializedBoardFrom(expiredBoard))
+
+	// LastModified is recent even though the board's own Timestamp is already
+	// expired, which is exactly the gap the reaper must not be fooled by.
+	lastModified := stableTime
+	fakeClient := &fakeListObjectsV2Client{
+		output: &s3.ListObjectsV2Output{
+			Contents: []types.Object{
+				{Key: aws.String(key), LastModified: &lastModified},
+			},
+		},
+	}
+
+	store.storageLister = func(_ context.Context, bucket string) *s3.ListObjectsV2Paginator {
+		require.Equal(t, "neospring_board", bucket)
+		return s3.NewListObjectsV2Paginator(fakeClient, &s3.ListObjectsV2Input{Bucket: &bucket})
+	}
+
+	store.storageReader = func(_ context.Context, bucket, gotKey string) (io.ReadCloser, error) {
+		require.Equal(t, "neospring_board", bucket)
+		require.Equal(t, key, gotKey)
+		return io.NopCloser(bytes.NewReader(encoded)), nil
+	}
+
+	var deletedKey string
+	store.storageDeleter = func(_ context.Context, bucket, gotKey string) error {
+		require.Equal(t, "neospring_board", bucket)
+		deletedKey = gotKey
+		return nil
+	}
+
+	require.NoError(t, store.reapExpiredObjects(ctx))
+	require.Equal(t, key, deletedKey, "expected the reaper to delete a board that's expired by Timestamp even though LastModified is recent")
+}
+
+// A single unreadable or corrupt object shouldn't stop the reaper from
+// getting to the rest of the bucket -- it should be logged and skipped so
+// later keys in the same pass still get reaped.
+func TestS3StoreReapSkipsBadObject(t *testing.T) {
+	ctx := context.Background()
+	store := NewS3Store(ctx, logger, "", "us-east-1", "key", "secret", "neospring_board")
+	store.SetTimeNow(stableTimeFunc)
+
+	const (
+		badKey  = "bad-key"
+		goodKey = samplePublicKey
+	)
+
+	expiredBoard := &nsstore.Board{
+		Content:   []byte("some board content"),
+		Signature: "deadbeef",
+		Timestamp: stableTime.Add(-nsstore.MaxContentAge).Add(-time.Minute),
+	}
+	encoded := mustJSONMarshal(t, serializedBoardFrom(expiredBoard))
+
+	lastModified := stableTime
+	fakeClient := &fakeListObjectsV2Client{
+		output: &s3.ListObjectsV2Output{
+			Contents: []types.Object{
+				{Key: aws.String(badKey), LastModified: &lastModified},
+				{Key: aws.String(goodKey), LastModified: &lastModified},
+			},
+		},
+	}
+
+	store.storageLister = func(_ context.Context, bucket string) *s3.ListObjectsV2Paginator {
+		return s3.NewListObjectsV2Paginator(fakeClient, &s3.ListObjectsV2Input{Bucket: &bucket})
+	}
+
+	store.storageReader = func(_ context.Context, _, gotKey string) (io.ReadCloser, error) {
+		if gotKey == badKey {
+			return nil, xerrors.New("simulated S3 read error")
+		}
+		return io.NopCloser(bytes.NewReader(encoded)), nil
+	}
+
+	var deletedKeys []string
+	store.storageDeleter = func(_ context.Context, _, gotKey string) error {
+		deletedKeys = append(deletedKeys, gotKey)
+		return nil
+	}
+
+	require.NoError(t, store.reapExpiredObjects(ctx))
+	require.Equal(t, []string{goodKey}, deletedKeys,
+		"expected the reaper to skip the unreadable object and still reap the good one")
+}
+
+// fakeListObjectsV2Client satisfies s3.ListObjectsV2APIClient with a single
+// canned page, for driving an s3.ListObjectsV2Paginator in tests without a
+// real S3 endpoint.
+type fakeListObjectsV2Client struct {
+	output *s3.ListObjectsV2Output
+}
+
+func (c *fakeListObjectsV2Client) ListObjectsV2(_ context.Context, _ *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) { //nolint:lll
+	return c.output, nil
+}
+
+// This is already well tested from `MemoryStore`, so here just do a trivial
+// test to make sure the loop starts up and shuts down.
+func TestS3StoreReapLoop(t *testing.T) {
+	ctx := context.Background()
+	store := NewS3Store(ctx, logger, "", "us-east-1", "key", "secret", "neospring_board")
+
+	shutdown := make(chan struct{}, 1)
+	close(shutdown)
+
+	// We pre-closed the shutdown channel, so this should run once, notice the
+	// shutdown, and exit.
+	store.ReapLoop(ctx, shutdown)
+}
+
+func mustJSONMarshal(t *testing.T, v any) []byte {
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return b
+}
+
+func mustJSONUnmarshal(t *testing.T, data []byte, v any) {
+	err := json.Unmarshal(data, v)
+	require.NoError(t, err)
+}
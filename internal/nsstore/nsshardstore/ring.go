@@ -0,0 +1,162 @@
+package nsshardstore
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// vnodesPerShard is the number of virtual nodes each shard is given on the
+// ring. More virtual nodes spread a shard's share of the keyspace across
+// more, smaller arcs, which keeps the distribution even as shards come and
+// go rather than handing whichever shard happens to land next to a gap a
+// disproportionate share of it.
+const vnodesPerShard = 128
+
+// ring is a consistent hash ring over a fixed set of shard IDs, implemented
+// as a sorted slice of virtual node hashes alongside a lookup from hash back
+// to the shard it belongs to. Once built, a ring is immutable -- adding or
+// removing shards means constructing a new one via newRing.
+type ring struct {
+	hashes  []uint32
+	members map[uint32]shardID
+}
+
+// newRing builds a ring over shards, each contributing vnodesPerShard
+// virtual nodes hashed via siphash of the shard's ID and the vnode's index.
+func newRing(shards []shardID) *ring {
+	r := &ring{
+		members: make(map[uint32]shardID, len(shards)*vnodesPerShard),
+	}
+
+	for _, shard := range shards {
+		for i := 0; i < vnodesPerShard; i++ {
+			h := vnodeHash(shard, i)
+			r.hashes = append(r.hashes, h)
+			r.members[h] = shard
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+// Owners returns the n distinct shards responsible for key, starting with
+// its primary (the shard owning the first ring position at or after
+// hash(key)) and continuing clockwise around the ring for the replicas.
+// Fewer than n shards are returned only if the ring has fewer than n members
+// in the first place.
+func (r *ring) Owners(key string, n int) []shardID {
+	if len(r.hashes) == 0 {
+		return nil
+	}
+
+	start := r.search(hashKey(key))
+
+	seen := make(map[shardID]bool, n)
+	owners := make([]shardID, 0, n)
+
+	for i := 0; i < len(r.hashes) && len(owners) < n; i++ {
+		shard := r.members[r.hashes[(start+i)%len(r.hashes)]]
+		if seen[shard] {
+			continue
+		}
+		seen[shard] = true
+		owners = append(owners, shard)
+	}
+
+	return owners
+}
+
+// search returns the index of the first ring entry whose hash is greater
+// than or equal to h, wrapping around to 0 if h is greater than every hash
+// on the ring.
+func (r *ring) search(h uint32) int {
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return idx
+}
+
+// vnodeHash hashes one shard's virtual node onto the ring.
+func vnodeHash(shard shardID, vnode int) uint32 {
+	return hashKey(string(shard) + "#" + strconv.Itoa(vnode))
+}
+
+// hashKey reduces siphash's 64-bit output to the uint32 the ring is keyed
+// by, which is plenty of resolution given vnodesPerShard virtual nodes per
+// shard.
+func hashKey(key string) uint32 {
+	h := siphash24([]byte(key))
+	return uint32(h ^ (h >> 32))
+}
+
+// siphash24 computes SipHash-2-4 (https://www.aumasson.jp/siphash/siphash.pdf)
+// over data with an all-zero 128-bit key. The key doesn't need to be secret
+// or unpredictable here -- siphash is used only for its speed and excellent
+// bit distribution as a ring hash, not as a MAC -- so a fixed key keeps every
+// node in a cluster computing identical ring assignments without needing to
+// share or configure one.
+func siphash24(data []byte) uint64 {
+	const (
+		initV0 = 0x736f6d6570736575
+		initV1 = 0x646f72616e646f6d
+		initV2 = 0x6c7967656e657261
+		initV3 = 0x7465646279746573
+	)
+
+	v0, v1, v2, v3 := uint64(initV0), uint64(initV1), uint64(initV2), uint64(initV3)
+
+	length := len(data)
+	end := length - (length % 8)
+
+	for i := 0; i < end; i += 8 {
+		m := binary.LittleEndian.Uint64(data[i : i+8])
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+
+	var last [8]byte
+	copy(last[:], data[end:])
+	last[7] = byte(length)
+	m := binary.LittleEndian.Uint64(last[:])
+
+	v3 ^= m
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	v2 ^= 0xff
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = rotl(v1, 13)
+	v1 ^= v0
+	v0 = rotl(v0, 32)
+	v2 += v3
+	v3 = rotl(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = rotl(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = rotl(v1, 17)
+	v1 ^= v2
+	v2 = rotl(v2, 32)
+	return v0, v1, v2, v3
+}
+
+func rotl(x uint64, b uint) uint64 {
+	return (x << b) | (x >> (64 - b))
+}
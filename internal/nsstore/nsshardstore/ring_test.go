@@ -0,0 +1,54 @@
+package nsshardstore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingOwnersDistinctAndDeterministic(t *testing.T) {
+	r := newRing([]shardID{"a", "b", "c", "d"})
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+
+		owners := r.Owners(key, 3)
+		require.Len(t, owners, 3)
+
+		seen := make(map[shardID]bool)
+		for _, owner := range owners {
+			require.False(t, seen[owner], "owner %q repeated for key %q", owner, key)
+			seen[owner] = true
+		}
+
+		// Looking up the same key again should always produce the same
+		// owners, in the same order.
+		require.Equal(t, owners, r.Owners(key, 3))
+	}
+}
+
+func TestRingOwnersClampedToRingSize(t *testing.T) {
+	r := newRing([]shardID{"a", "b"})
+
+	owners := r.Owners("some-key", 5)
+	require.Len(t, owners, 2)
+}
+
+func TestRingOwnersEmptyRing(t *testing.T) {
+	r := newRing(nil)
+	require.Nil(t, r.Owners("some-key", 3))
+}
+
+func TestRingOwnersWraparound(t *testing.T) {
+	r := newRing([]shardID{"a", "b", "c"})
+
+	// Finding owners for the very last hash on the ring should wrap back
+	// around to the start rather than running off the end of the slice.
+	lastHash := r.hashes[len(r.hashes)-1]
+	idx := r.search(lastHash)
+	require.Equal(t, lastHash, r.hashes[idx])
+
+	owners := r.Owners("wraparound-key", len(r.hashes)+1)
+	require.LessOrEqual(t, len(owners), 3)
+}
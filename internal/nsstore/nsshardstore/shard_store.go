@@ -0,0 +1,325 @@
+// Package nsshardstore implements nsstore's `BoardStore` interface as a
+// consistent-hash router in front of N backing stores, letting a Spring '83
+// deployment scale across multiple machines instead of just vertically on
+// one. Each public key is routed to a primary shard (plus a configurable
+// number of replicas) via a hash ring with virtual nodes, so adding or
+// removing a shard only reshuffles a small fraction of the keyspace rather
+// than all of it.
+//
+// A shard is either this node's own local store, or a remote peer spoken to
+// over the module's existing board HTTP endpoints -- no new wire protocol is
+// needed, since a remote shard is addressed exactly like any other Spring
+// '83 server.
+package nsshardstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+// DefaultReplicationFactor is how many shards (the primary plus replicas)
+// each key is written to and may be read from, unless the caller configures
+// a different factor.
+const DefaultReplicationFactor = 2
+
+// shardID identifies a single shard on the ring: either "self" (this node's
+// own local store) or a peer's base URL (e.g. "https://peer.example.com").
+type shardID string
+
+// selfShardID is the fixed ring identity of this node's own local store.
+const selfShardID shardID = "self"
+
+// ShardStore routes each key to a shard via a consistent hash ring, writing
+// to and reading from the primary shard plus ReplicationFactor-1 replicas so
+// that a single shard being briefly unavailable doesn't make a key
+// unreadable or unwritable.
+type ShardStore struct {
+	localStore        nsstore.BoardStore
+	logger            *logrus.Logger
+	replicationFactor int
+	ring              *ring
+	self              shardID
+	shards            map[shardID]nsstore.BoardStore
+	timeNow           func() time.Time
+
+	reapLoopStarted bool
+}
+
+// NewShardStore returns a ShardStore that always includes localStore as the
+// "self" shard, plus one remote shard per URL in peers. replicationFactor is
+// clamped to the number of shards available if it's larger (a ring with
+// fewer shards than the requested replication factor just replicates to
+// everything it has).
+func NewShardStore(
+	logger *logrus.Logger,
+	localStore nsstore.BoardStore,
+	peers []string,
+	replicationFactor int,
+) *ShardStore {
+	if replicationFactor <= 0 {
+		replicationFactor = DefaultReplicationFactor
+	}
+
+	shards := make(map[shardID]nsstore.BoardStore, len(peers)+1)
+	shards[selfShardID] = localStore
+
+	shardIDs := make([]shardID, 0, len(peers)+1)
+	shardIDs = append(shardIDs, selfShardID)
+
+	for _, peer := range peers {
+		id := shardID(peer)
+		shards[id] = newRemoteStore(peer)
+		shardIDs = append(shardIDs, id)
+	}
+
+	if replicationFactor > len(shardIDs) {
+		replicationFactor = len(shardIDs)
+	}
+
+	return &ShardStore{
+		localStore:        localStore,
+		logger:            logger,
+		replicationFactor: replicationFactor,
+		ring:              newRing(shardIDs),
+		self:              selfShardID,
+		shards:            shards,
+		timeNow:           time.Now,
+	}
+}
+
+// SetTimeNow overrides the time ShardStore considers "now" when deciding
+// whether an owned board has expired. For testing purposes only.
+func (s *ShardStore) SetTimeNow(timeNow func() time.Time) {
+	s.timeNow = timeNow
+}
+
+// Get tries key's primary shard, then falls back to its replicas in ring
+// order on ErrKeyNotFound, returning the first board found.
+func (s *ShardStore) Get(ctx context.Context, key string) (*nsstore.Board, error) {
+	var lastErr error
+
+	for _, owner := range s.ring.Owners(key, s.replicationFactor) {
+		board, err := s.shards[owner].Get(ctx, key)
+		if err == nil {
+			return board, nil
+		}
+
+		if !errors.Is(err, nsstore.ErrKeyNotFound) {
+			s.logger.Infof("ShardStore: Error getting key %q from shard %q: %v", key, owner, err)
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// Put writes board to key's primary shard and every replica, walking
+// clockwise around the ring. Succeeds as long as at least one shard accepts
+// the write -- a deployment that wants every replica acknowledged before
+// returning should set replicationFactor to 1 per shard group instead.
+func (s *ShardStore) Put(ctx context.Context, key string, board *nsstore.Board) error {
+	owners := s.ring.Owners(key, s.replicationFactor)
+
+	var lastErr error
+	var numSucceeded int
+
+	for _, owner := range owners {
+		if err := s.shards[owner].Put(ctx, key, board); err != nil {
+			s.logger.Infof("ShardStore: Error putting key %q to shard %q: %v", key, owner, err)
+			lastErr = err
+			continue
+		}
+		numSucceeded++
+	}
+
+	if numSucceeded == 0 {
+		return xerrors.Errorf("error putting key %q to any of %d shard(s): %w", key, len(owners), lastErr)
+	}
+
+	return nil
+}
+
+// owns reports whether this node is key's primary shard -- the one shard in
+// its owner set responsible for reaping it, so that reaping isn't
+// duplicated across every node that happens to hold a replica.
+func (s *ShardStore) owns(key string) bool {
+	owners := s.ring.Owners(key, s.replicationFactor)
+	return len(owners) > 0 && owners[0] == s.self
+}
+
+// Iterate walks every board in the local store, invoking fn once for each
+// key. Like Get and Put, a ShardStore only ever sees its own shard's data
+// this way; a caller wanting a cluster-wide view needs to iterate every
+// node individually.
+func (s *ShardStore) Iterate(ctx context.Context, fn func(key string, board *nsstore.Board) error) error {
+	return s.localStore.Iterate(ctx, fn) //nolint:wrapcheck
+}
+
+// Delete removes key from the local store only. Callers wanting a key gone
+// from every replica should issue a Delete against each shard directly.
+func (s *ShardStore) Delete(ctx context.Context, key string) error {
+	return s.localStore.Delete(ctx, key) //nolint:wrapcheck
+}
+
+// Count returns the number of boards in the local store.
+func (s *ShardStore) Count(ctx context.Context) (int, error) {
+	return s.localStore.Count(ctx) //nolint:wrapcheck
+}
+
+// ReapLoop starts a reaper forever loop that periodically walks the local
+// store, expiring boards whose content has aged out -- but only for keys
+// this node is the primary owner of (see owns), so that reaping a
+// replicated key isn't redundantly done by every node holding a copy of it.
+func (s *ShardStore) ReapLoop(ctx context.Context, shutdown <-chan struct{}) {
+	if s.reapLoopStarted {
+		panic("ReapLoop already started -- should only be run once")
+	}
+
+	s.reapLoopStarted = true
+
+	for {
+		if err := s.reap(ctx); err != nil {
+			s.logger.Infof("ShardStore: Error reaping: %v", err)
+		}
+
+		select {
+		case <-shutdown:
+			s.logger.Infof("ShardStore: Received shutdown signal")
+			return
+
+		case <-time.After(1 * time.Minute):
+		}
+	}
+}
+
+func (s *ShardStore) reap(ctx context.Context) error {
+	var staleKeys []string
+
+	if err := s.localStore.Iterate(ctx, func(key string, board *nsstore.Board) error {
+		if !s.owns(key) {
+			return nil
+		}
+
+		if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+			staleKeys = append(staleKeys, key)
+		}
+
+		return nil
+	}); err != nil {
+		return xerrors.Errorf("error iterating local store: %w", err)
+	}
+
+	for _, key := range staleKeys {
+		if err := s.localStore.Delete(ctx, key); err != nil {
+			return xerrors.Errorf("error deleting expired key %q: %w", key, err)
+		}
+	}
+
+	if len(staleKeys) > 0 {
+		s.logger.Infof("ShardStore: Reaped %d owned board(s)", len(staleKeys))
+	}
+
+	return nil
+}
+
+// remoteStore fronts a peer neospring server as a shard, speaking the same
+// signed PUT/GET endpoints any Spring '83 client would. It doesn't support
+// Iterate, Delete, or Count -- nothing in the wire protocol offers a way to
+// enumerate or remove a peer's boards, so those are left to whichever node
+// owns the shard locally.
+type remoteStore struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newRemoteStore(baseURL string) *remoteStore {
+	return &remoteStore{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *remoteStore) Get(ctx context.Context, key string) (*nsstore.Board, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/"+key, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("error building get request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("error making get request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("shard %q responded to get with unexpected status %d", s.baseURL, resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading get response body: %w", err)
+	}
+
+	timestamp, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing shard's Last-Modified header: %w", err)
+	}
+
+	return &nsstore.Board{
+		Content:   content,
+		Signature: resp.Header.Get("Spring-Signature"),
+		Timestamp: timestamp,
+	}, nil
+}
+
+func (s *remoteStore) Put(ctx context.Context, key string, board *nsstore.Board) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.baseURL+"/"+key, bytes.NewReader(board.Content))
+	if err != nil {
+		return xerrors.Errorf("error building put request: %w", err)
+	}
+	req.Header.Set("Spring-Signature", board.Signature)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("error making put request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	// A 409 just means the shard already has this update or something
+	// newer, which isn't a write failure.
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode < 300 {
+		return nil
+	}
+
+	return xerrors.Errorf("shard %q responded to put with unexpected status %d", s.baseURL, resp.StatusCode)
+}
+
+func (s *remoteStore) Iterate(context.Context, func(key string, board *nsstore.Board) error) error {
+	return xerrors.New("remoteStore does not support Iterate: no enumeration endpoint exists in the wire protocol")
+}
+
+func (s *remoteStore) Delete(context.Context, string) error {
+	return xerrors.New("remoteStore does not support Delete: no deletion endpoint exists in the wire protocol")
+}
+
+func (s *remoteStore) Count(context.Context) (int, error) {
+	return 0, xerrors.New("remoteStore does not support Count: no enumeration endpoint exists in the wire protocol")
+}
+
+func (s *remoteStore) ReapLoop(context.Context, <-chan struct{}) {
+	// The remote peer is responsible for expiring its own boards.
+}
@@ -0,0 +1,154 @@
+package nsshardstore
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+const samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+// newFakePeer stands in for a peer neospring server, speaking just enough of
+// the board GET/PUT protocol for remoteStore to talk to it.
+func newFakePeer(t *testing.T) (*httptest.Server, *nsmemorystore.MemoryStore) {
+	t.Helper()
+
+	store := nsmemorystore.NewMemoryStore(logrus.New(), 0)
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path[1:]
+
+		switch r.Method {
+		case http.MethodGet:
+			board, err := store.Get(r.Context(), key)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Spring-Signature", board.Signature)
+			w.Header().Set("Last-Modified", board.Timestamp.UTC().Format(http.TimeFormat))
+			_, _ = w.Write(board.Content)
+
+		case http.MethodPut:
+			content, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			board := &nsstore.Board{
+				Content:   content,
+				Signature: r.Header.Get("Spring-Signature"),
+				Timestamp: stableTime,
+			}
+			if err := store.Put(r.Context(), key, board); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, store
+}
+
+func TestShardStorePutGetRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+	peer, _ := newFakePeer(t)
+	localStore := nsmemorystore.NewMemoryStore(logrus.New(), 0)
+
+	shardStore := NewShardStore(logrus.New(), localStore, []string{peer.URL}, 2)
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, shardStore.Put(ctx, keyPair.PublicKey, board))
+
+	boardFromStore, err := shardStore.Get(ctx, keyPair.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, board.Content, boardFromStore.Content)
+	require.Equal(t, board.Signature, boardFromStore.Signature)
+}
+
+func TestShardStoreGetFallsBackToReplica(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+	peer, peerStore := newFakePeer(t)
+
+	// A local store that never gets the write, so Get can only succeed by
+	// falling back to the replica.
+	localStore := nsmemorystore.NewMemoryStore(logrus.New(), 0)
+
+	shardStore := NewShardStore(logrus.New(), localStore, []string{peer.URL}, 2)
+
+	const content = "replica-only board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, peerStore.Put(ctx, keyPair.PublicKey, board))
+
+	boardFromStore, err := shardStore.Get(ctx, keyPair.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, board.Content, boardFromStore.Content)
+}
+
+func TestShardStoreReapOnlyReapsOwnedKeys(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+	localStore := nsmemorystore.NewMemoryStore(logrus.New(), 0)
+	localStore.SetTimeNow(func() time.Time { return stableTime })
+
+	// A single-shard ring always owns every key, so this exercises the
+	// reap path end to end.
+	shardStore := NewShardStore(logrus.New(), localStore, nil, 1)
+	shardStore.SetTimeNow(func() time.Time { return stableTime })
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, shardStore.Put(ctx, keyPair.PublicKey, board))
+
+	require.NoError(t, shardStore.reap(ctx))
+	count, err := localStore.Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	shardStore.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
+	require.NoError(t, shardStore.reap(ctx))
+
+	count, err = localStore.Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
@@ -0,0 +1,259 @@
+// Package nssqlitestore implements nsstore's `BoardStore` interface on top of
+// modernc.org/sqlite, a pure-Go SQLite driver that needs no cgo toolchain.
+// Like nsboltstore and nsdiskstore, it gives operators a durable single-node
+// option that survives a restart without standing up GCP or Redis, but
+// trades nsboltstore's hand-rolled expiry index for a plain SQL index and
+// DELETE statement, which is simpler to reason about at the cost of O(n)
+// deletes per sweep instead of nsboltstore's O(log n + k).
+package nssqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+// schema creates the boards table and its expiry index if they don't already
+// exist, along with the WAL-mode pragmas that let readers proceed
+// concurrently with a writer instead of blocking on SQLite's default
+// rollback-journal locking.
+const schema = `
+PRAGMA journal_mode = WAL;
+PRAGMA synchronous = NORMAL;
+PRAGMA busy_timeout = 5000;
+
+CREATE TABLE IF NOT EXISTS boards (
+	pubkey     TEXT PRIMARY KEY,
+	content    BLOB NOT NULL,
+	signature  TEXT NOT NULL,
+	timestamp  INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS boards_expires_at_idx ON boards (expires_at);
+`
+
+// SQLiteStore persists boards to a SQLite database file on disk.
+type SQLiteStore struct {
+	db              *sql.DB
+	logger          *logrus.Logger
+	name            string
+	path            string
+	reapLoopStarted bool
+	timeNow         func() time.Time
+}
+
+// NewSQLiteStore opens (creating if necessary) a SQLite database at path and
+// returns a SQLiteStore backed by it. Callers are responsible for calling
+// Close when the store is no longer needed.
+func NewSQLiteStore(logger *logrus.Logger, path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, xerrors.Errorf("error opening sqlite db at %q: %w", path, err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		return nil, xerrors.Errorf("error creating schema: %w", err)
+	}
+
+	return &SQLiteStore{
+		db:      db,
+		logger:  logger,
+		name:    reflect.TypeOf(SQLiteStore{}).Name(),
+		path:    path,
+		timeNow: time.Now,
+	}, nil
+}
+
+// Close closes the underlying SQLite database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close() //nolint:wrapcheck
+}
+
+// Get returns the board stored at key.
+func (s *SQLiteStore) Get(ctx context.Context, key string) (*nsstore.Board, error) {
+	var (
+		content   []byte
+		signature string
+		timestamp int64
+	)
+
+	err := s.db.QueryRowContext(ctx, `SELECT content, signature, timestamp FROM boards WHERE pubkey = ?`, key).
+		Scan(&content, &signature, &timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nsstore.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error reading key %q: %w", key, err)
+	}
+
+	board := &nsstore.Board{
+		Content:   content,
+		Signature: signature,
+		Timestamp: time.Unix(timestamp, 0).UTC(),
+	}
+
+	// Just in case the reap loop is behind, aggressively prune possibly
+	// outdated content.
+	if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+		s.logger.Infof(s.name+": Returning not found for stale key %q created %v", key, board.Timestamp)
+		return nil, nsstore.ErrKeyNotFound
+	}
+
+	return board, nil
+}
+
+// Put stores board under key, rejecting the write with
+// nsstore.ErrTimestampOlderThanCurrent if a newer board is already on
+// record. The read-compare-write is wrapped in a transaction since, like
+// nsboltstore, a row that isn't locked for the duration can race with other
+// writers for the same key.
+func (s *SQLiteStore) Put(ctx context.Context, key string, board *nsstore.Board) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return xerrors.Errorf("error starting transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var existingTimestamp int64
+
+	err = tx.QueryRowContext(ctx, `SELECT timestamp FROM boards WHERE pubkey = ?`, key).Scan(&existingTimestamp)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// No existing board; nothing to compare against.
+
+	case err != nil:
+		return xerrors.Errorf("error reading existing key %q: %w", key, err)
+
+	case existingTimestamp > board.Timestamp.Unix():
+		return nsstore.ErrTimestampOlderThanCurrent
+	}
+
+	expiresAt := board.Timestamp.Add(nsstore.MaxContentAge).Unix()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO boards (pubkey, content, signature, timestamp, expires_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (pubkey) DO UPDATE SET content = excluded.content, signature = excluded.signature,
+			timestamp = excluded.timestamp, expires_at = excluded.expires_at`,
+		key, board.Content, board.Signature, board.Timestamp.Unix(), expiresAt); err != nil {
+		return xerrors.Errorf("error writing key %q: %w", key, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Iterate walks every board currently in the store, invoking fn once for
+// each key.
+func (s *SQLiteStore) Iterate(ctx context.Context, fn func(key string, board *nsstore.Board) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT pubkey, content, signature, timestamp FROM boards`)
+	if err != nil {
+		return xerrors.Errorf("error querying boards: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var (
+			key       string
+			content   []byte
+			signature string
+			timestamp int64
+		)
+
+		if err := rows.Scan(&key, &content, &signature, &timestamp); err != nil {
+			return xerrors.Errorf("error scanning board: %w", err)
+		}
+
+		board := &nsstore.Board{
+			Content:   content,
+			Signature: signature,
+			Timestamp: time.Unix(timestamp, 0).UTC(),
+		}
+
+		if err := fn(key, board); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err() //nolint:wrapcheck
+}
+
+// Delete permanently removes the board stored at key, if any. A no-op, not
+// an error, if key doesn't exist.
+func (s *SQLiteStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM boards WHERE pubkey = ?`, key); err != nil {
+		return xerrors.Errorf("error deleting key %q: %w", key, err)
+	}
+
+	return nil
+}
+
+// Count returns the number of boards currently in the store.
+func (s *SQLiteStore) Count(ctx context.Context) (int, error) {
+	var count int
+
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM boards`).Scan(&count); err != nil {
+		return 0, xerrors.Errorf("error counting boards: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReapLoop starts a reaper forever loop that periodically cleans up expired
+// boards, on the same 1-minute cadence as nsmemorystore.MemoryStore.ReapLoop.
+// It blocks, so should be started on a goroutine.
+func (s *SQLiteStore) ReapLoop(ctx context.Context, shutdown <-chan struct{}) {
+	if s.reapLoopStarted {
+		panic("ReapLoop already started -- should only be run once")
+	}
+
+	s.reapLoopStarted = true
+
+	for {
+		_ = s.reap(ctx)
+
+		select {
+		case <-shutdown:
+			s.logger.Info(s.name + ": Received shutdown signal")
+			return
+
+		case <-time.After(1 * time.Minute):
+		}
+	}
+}
+
+// SetTimeNow overrides the time SQLiteStore considers "now". For testing
+// purposes only.
+func (s *SQLiteStore) SetTimeNow(timeNow func() time.Time) {
+	s.timeNow = timeNow
+}
+
+func (s *SQLiteStore) reap(ctx context.Context) int {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM boards WHERE expires_at < ?`, s.timeNow().Unix())
+	if err != nil {
+		s.logger.Errorf(s.name+": Error reaping: %v", err)
+		return 0
+	}
+
+	numReaped, err := result.RowsAffected()
+	if err != nil {
+		s.logger.Errorf(s.name+": Error reading reaped row count: %v", err)
+		return 0
+	}
+
+	s.logger.Infof(s.name+": Reaped %d board(s)", numReaped)
+
+	return int(numReaped)
+}
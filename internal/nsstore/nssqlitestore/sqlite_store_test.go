@@ -0,0 +1,89 @@
+package nssqlitestore
+
+import (
+	"context"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsstoretest"
+)
+
+const samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+
+var (
+	logger     = logrus.New()
+	stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+)
+
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	store, err := NewSQLiteStore(logger, filepath.Join(t.TempDir(), "boards.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, store.Close()) })
+
+	return store
+}
+
+func TestSQLiteBoardStore(t *testing.T) {
+	nsstoretest.RunConformance(t, func() nsstoretest.TimeSettableStore {
+		return newTestStore(t)
+	})
+}
+
+func TestSQLiteBoardStorePutStaleTimestampRejected(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := newTestStore(t)
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, store.Put(ctx, keyPair.PublicKey, board))
+
+	staleBoard := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime.Add(-1 * time.Minute),
+	}
+	err := store.Put(ctx, keyPair.PublicKey, staleBoard)
+	require.ErrorIs(t, err, nsstore.ErrTimestampOlderThanCurrent)
+
+	// The original board is still the one on record.
+	boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, board, boardFromStore)
+}
+
+func TestSQLiteBoardStoreReap(t *testing.T) {
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := newTestStore(t)
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	require.NoError(t, store.Put(ctx, keyPair.PublicKey, board))
+
+	store.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
+	require.Equal(t, 1, store.reap(ctx))
+
+	count, err := store.Count(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
@@ -0,0 +1,118 @@
+// Package nsstoretest holds a conformance test suite that any
+// `nsstore.BoardStore` implementation can run against itself to verify it
+// satisfies the interface's contract, so that the same set of cases doesn't
+// need to be hand duplicated across every store package.
+package nsstoretest
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+)
+
+const samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+// TimeSettableStore is a BoardStore with a test-only hook for controlling the
+// time it considers "now", which every store implementation exposes so that
+// content expiry can be exercised without a real sleep.
+type TimeSettableStore interface {
+	nsstore.BoardStore
+	SetTimeNow(func() time.Time)
+}
+
+// RunConformance runs a suite of tests common to every BoardStore
+// implementation against the store returned by newStore. Callers should pass
+// a fresh, empty store for each invocation.
+func RunConformance(t *testing.T, newStore func() TimeSettableStore) {
+	t.Helper()
+
+	ctx := context.Background()
+	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	store := newStore()
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	// Nothing stored initially.
+	{
+		_, err := store.Get(ctx, keyPair.PublicKey)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+	}
+
+	// Count is zero when nothing is stored.
+	{
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	}
+
+	const content = "some board content"
+	board := &nsstore.Board{
+		Content:   []byte(content),
+		Signature: hex.EncodeToString(keyPair.Sign([]byte(content))),
+		Timestamp: stableTime,
+	}
+	err := store.Put(ctx, keyPair.PublicKey, board)
+	require.NoError(t, err)
+
+	// Count reflects the board we just stored.
+	{
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	}
+
+	// After putting content, we now get the same content back.
+	{
+		boardFromStore, err := store.Get(ctx, keyPair.PublicKey)
+		require.NoError(t, err)
+		require.Equal(t, board, boardFromStore)
+	}
+
+	// When pushing time far into the future so that the content is after its
+	// expiry, content is considered not present again.
+	{
+		store.SetTimeNow(func() time.Time { return stableTime.Add(nsstore.MaxContentAge).Add(10 * time.Minute) })
+		_, err := store.Get(ctx, keyPair.PublicKey)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+	}
+
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	// Iterate visits every board currently in the store.
+	{
+		seen := make(map[string]*nsstore.Board)
+		err := store.Iterate(ctx, func(key string, board *nsstore.Board) error {
+			seen[key] = board
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]*nsstore.Board{keyPair.PublicKey: board}, seen)
+	}
+
+	// Delete removes a board outright; Iterate no longer visits it, and a
+	// second Delete of the same, now-absent key is a no-op rather than an
+	// error.
+	{
+		err := store.Delete(ctx, keyPair.PublicKey)
+		require.NoError(t, err)
+
+		err = store.Iterate(ctx, func(key string, board *nsstore.Board) error {
+			t.Fatalf("unexpected board visited after delete: %q", key)
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, store.Delete(ctx, keyPair.PublicKey))
+
+		count, err := store.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	}
+}
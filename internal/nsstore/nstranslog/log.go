@@ -0,0 +1,278 @@
+package nstranslog
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+)
+
+var (
+	// ErrTreeSizeInvalid is returned when a caller asks for a proof involving
+	// a tree size that's out of bounds for the log's current size.
+	ErrTreeSizeInvalid = xerrors.New("tree size is invalid for the log's current size")
+
+	// ErrLeafIndexInvalid is returned when a caller asks for an inclusion
+	// proof for a leaf index that's out of bounds.
+	ErrLeafIndexInvalid = xerrors.New("leaf index is invalid for the given tree size")
+
+	// ErrStaleTreeHead is returned when a witness tries to cosign a tree head
+	// that no longer matches the log's current one. Witnesses are expected to
+	// fetch a fresh tree head and reverify consistency before cosigning.
+	ErrStaleTreeHead = xerrors.New("cosigned tree head no longer matches the log's current tree head")
+
+	// ErrCosignatureInvalid is returned when a witness's signature doesn't
+	// verify against the tree head it claims to cosign.
+	ErrCosignatureInvalid = xerrors.New("witness signature is invalid")
+)
+
+// Leaf is the data committed to the log for a single successful board `Put`.
+// Its canonical encoding (see encode) is what's hashed to produce the leaf's
+// position in the Merkle tree.
+type Leaf struct {
+	PublicKey   string
+	Timestamp   time.Time
+	Signature   string
+	ContentHash [sha256.Size]byte
+}
+
+// encode produces a canonical, unambiguous byte encoding of the leaf that's
+// fed into the RFC 6962 leaf hash. Fields are newline-delimited text so that
+// the encoding stays easy to reason about and debug, in keeping with the
+// style of other canonical payloads signed elsewhere in this package (e.g.
+// successor key attestations).
+func (l *Leaf) encode() []byte {
+	return []byte(fmt.Sprintf("spring83-translog-leaf\n%s\n%s\n%s\n%s",
+		l.PublicKey,
+		l.Timestamp.UTC().Format(time.RFC3339),
+		l.Signature,
+		hex.EncodeToString(l.ContentHash[:]),
+	))
+}
+
+// SignedTreeHead is a commitment by the log operator to a particular tree
+// size and root hash at a point in time. It's what `GET /log/tree-head`
+// returns, and what witnesses cosign.
+type SignedTreeHead struct {
+	TreeSize  int
+	RootHash  []byte
+	Timestamp time.Time
+	Signature []byte
+}
+
+// TreeHeadPayload produces the canonical payload that's signed by the log's
+// operator key, and which witnesses sign in turn when cosigning a tree head.
+// It's exported so that external witness implementations, which only
+// observe a tree head's fields over the wire, know exactly what bytes to
+// sign.
+func TreeHeadPayload(treeSize int, rootHash []byte) []byte {
+	return []byte(fmt.Sprintf("spring83-translog-tree-head\n%d\n%s", treeSize, hex.EncodeToString(rootHash)))
+}
+
+// Cosignature is a witness's attestation that it observed a tree head and
+// found it consistent with an earlier one it had already verified.
+type Cosignature struct {
+	WitnessPublicKey string
+	Signature        []byte
+}
+
+// Log is an append-only, Merkle-hashed transparency log for board updates.
+// It's safe for concurrent use.
+type Log struct {
+	leafHashes [][]byte
+	leaves     []Leaf
+	logKeyPair nskey.Signer
+	mut        sync.RWMutex
+	timeNow    func() time.Time
+
+	// cosignatures is keyed by tree size because a witness's cosignature is
+	// only meaningful in the context of the specific tree head it observed.
+	cosignatures map[int][]Cosignature
+}
+
+// NewLog initializes a new, empty transparency log. Tree heads it produces
+// are signed with logKeyPair, which may be a concrete *nskey.KeyPair or any
+// other nskey.Signer -- e.g. nsvault's Transit-backed signer, for operators
+// who'd rather not have the log's private key material materialize in this
+// process at all.
+func NewLog(logKeyPair nskey.Signer) *Log {
+	return &Log{
+		cosignatures: make(map[int][]Cosignature),
+		logKeyPair:   logKeyPair,
+		timeNow:      time.Now,
+	}
+}
+
+// Append adds a new leaf to the log, returning the index it was assigned.
+// Leaves are never removed or reordered, so the returned index is stable for
+// the lifetime of the log.
+func (l *Log) Append(publicKey, signature string, content []byte, timestamp time.Time) (int, error) {
+	leaf := Leaf{
+		PublicKey:   publicKey,
+		Timestamp:   timestamp,
+		Signature:   signature,
+		ContentHash: sha256.Sum256(content),
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	index := len(l.leafHashes)
+	l.leafHashes = append(l.leafHashes, hashLeaf(leaf.encode()))
+	l.leaves = append(l.leaves, leaf)
+
+	return index, nil
+}
+
+// TreeHead returns a freshly signed tree head for the log's current size.
+func (l *Log) TreeHead() *SignedTreeHead {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	return l.treeHeadLocked()
+}
+
+func (l *Log) treeHeadLocked() *SignedTreeHead {
+	treeSize := len(l.leafHashes)
+	rootHash := merkleRoot(l.leafHashes)
+
+	return &SignedTreeHead{
+		TreeSize:  treeSize,
+		RootHash:  rootHash,
+		Timestamp: l.timeNow(),
+		Signature: l.logKeyPair.Sign(TreeHeadPayload(treeSize, rootHash)),
+	}
+}
+
+// ConsistencyProof returns the audit path proving that the tree of size
+// first is a prefix of the tree of size second, per RFC 6962 §2.1.2.
+func (l *Log) ConsistencyProof(first, second int) ([][]byte, error) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	if first < 0 || second > len(l.leafHashes) || first > second {
+		return nil, ErrTreeSizeInvalid
+	}
+
+	if first == 0 || first == second {
+		return nil, nil
+	}
+
+	return consistencyProof(l.leafHashes, first, second), nil
+}
+
+// InclusionProof returns the audit path proving that the leaf at index is
+// included in the tree of the given size, per RFC 6962 §2.1.1.
+func (l *Log) InclusionProof(index, treeSize int) ([][]byte, error) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	if treeSize < 0 || treeSize > len(l.leafHashes) {
+		return nil, ErrTreeSizeInvalid
+	}
+
+	if index < 0 || index >= treeSize {
+		return nil, ErrLeafIndexInvalid
+	}
+
+	return inclusionProof(l.leafHashes[:treeSize], index), nil
+}
+
+// LeafHash returns the RFC 6962 leaf hash for the leaf at the given index, so
+// that callers building an inclusion proof response have the hash that the
+// proof is anchored to without reaching into the log's internals.
+func (l *Log) LeafHash(index int) ([]byte, error) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	if index < 0 || index >= len(l.leafHashes) {
+		return nil, ErrLeafIndexInvalid
+	}
+
+	return l.leafHashes[index], nil
+}
+
+// Digest returns the most recent timestamp recorded for each public key that
+// has an entry in the log, keyed by public key. It's the basis of
+// federation's anti-entropy sweep, which exchanges these tuples between
+// peers to find updates each side is missing.
+func (l *Log) Digest() map[string]time.Time {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	digest := make(map[string]time.Time, len(l.leaves))
+	for _, leaf := range l.leaves {
+		if existing, ok := digest[leaf.PublicKey]; !ok || leaf.Timestamp.After(existing) {
+			digest[leaf.PublicKey] = leaf.Timestamp
+		}
+	}
+
+	return digest
+}
+
+// IndexForKey returns the index of the most recently appended leaf for the
+// given public key, so that a client that only knows a board's key can
+// request an inclusion proof for it.
+func (l *Log) IndexForKey(publicKey string) (int, bool) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	for i := len(l.leaves) - 1; i >= 0; i-- {
+		if l.leaves[i].PublicKey == publicKey {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// AddCosignature records a witness's cosignature of the log's current tree
+// head. The witness is expected to have already fetched that tree head and
+// satisfied itself (via ConsistencyProof against an earlier head it trusts)
+// that the log hasn't equivocated before cosigning it, so this only verifies
+// that the signature itself is valid over the current head.
+func (l *Log) AddCosignature(witnessPublicKey string, treeSize int, rootHash, signature []byte) error {
+	witnessPublicKeyBytes, err := hex.DecodeString(witnessPublicKey)
+	if err != nil {
+		return xerrors.Errorf("error decoding witness public key: %w", err)
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	current := l.treeHeadLocked()
+	if treeSize != current.TreeSize || hex.EncodeToString(rootHash) != hex.EncodeToString(current.RootHash) {
+		return ErrStaleTreeHead
+	}
+
+	if !ed25519.Verify(witnessPublicKeyBytes, TreeHeadPayload(treeSize, rootHash), signature) {
+		return ErrCosignatureInvalid
+	}
+
+	l.cosignatures[treeSize] = append(l.cosignatures[treeSize], Cosignature{
+		WitnessPublicKey: witnessPublicKey,
+		Signature:        signature,
+	})
+
+	return nil
+}
+
+// Cosignatures returns the cosignatures collected so far for the tree head at
+// the given size.
+func (l *Log) Cosignatures(treeSize int) []Cosignature {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+
+	return append([]Cosignature(nil), l.cosignatures[treeSize]...)
+}
+
+// SetTimeNow is for testing purposes only.
+func (l *Log) SetTimeNow(timeNow func() time.Time) {
+	l.timeNow = timeNow
+}
@@ -0,0 +1,158 @@
+package nstranslog
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+)
+
+const (
+	samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
+	samplePublicKey  = "e90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1124"
+)
+
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+func newTestLog(t *testing.T) (*Log, *nskey.KeyPair) {
+	t.Helper()
+
+	logKeyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+	log := NewLog(logKeyPair)
+	log.SetTimeNow(func() time.Time { return stableTime })
+	return log, logKeyPair
+}
+
+func TestLogAppendAndTreeHead(t *testing.T) {
+	log, logKeyPair := newTestLog(t)
+
+	// An empty log still produces a (signed) tree head.
+	head := log.TreeHead()
+	require.Equal(t, 0, head.TreeSize)
+	require.Equal(t, emptyHash(), head.RootHash)
+
+	index, err := log.Append(samplePublicKey, "deadbeef", []byte("some board content"), stableTime)
+	require.NoError(t, err)
+	require.Equal(t, 0, index)
+
+	head = log.TreeHead()
+	require.Equal(t, 1, head.TreeSize)
+	require.True(t, logKeyPair.Verify(TreeHeadPayload(head.TreeSize, head.RootHash), head.Signature))
+}
+
+func TestLogInclusionAndConsistencyProofs(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(samplePublicKey, "deadbeef", []byte{byte(i)}, stableTime)
+		require.NoError(t, err)
+	}
+
+	head := log.TreeHead()
+	require.Equal(t, 5, head.TreeSize)
+
+	proof, err := log.InclusionProof(2, 5)
+	require.NoError(t, err)
+	require.True(t, VerifyInclusionProof(log.leafHashes[2], 2, 5, proof, head.RootHash))
+
+	_, err = log.InclusionProof(5, 5)
+	require.ErrorIs(t, err, ErrLeafIndexInvalid)
+
+	_, err = log.ConsistencyProof(3, 10)
+	require.ErrorIs(t, err, ErrTreeSizeInvalid)
+
+	proof, err = log.ConsistencyProof(3, 5)
+	require.NoError(t, err)
+	require.NotEmpty(t, proof)
+}
+
+func TestLogLeafHash(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	_, err := log.Append(samplePublicKey, "deadbeef", []byte("some board content"), stableTime)
+	require.NoError(t, err)
+
+	hash, err := log.LeafHash(0)
+	require.NoError(t, err)
+	require.Equal(t, log.leafHashes[0], hash)
+
+	_, err = log.LeafHash(1)
+	require.ErrorIs(t, err, ErrLeafIndexInvalid)
+}
+
+func TestLogDigest(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	otherPublicKey := "f90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1125"
+
+	_, err := log.Append(samplePublicKey, "deadbeef", []byte("v1"), stableTime)
+	require.NoError(t, err)
+	_, err = log.Append(samplePublicKey, "deadbeef", []byte("v2"), stableTime.Add(1*time.Hour))
+	require.NoError(t, err)
+	_, err = log.Append(otherPublicKey, "deadbeef", []byte("v1"), stableTime.Add(30*time.Minute))
+	require.NoError(t, err)
+
+	digest := log.Digest()
+	require.Len(t, digest, 2)
+	require.Equal(t, stableTime.Add(1*time.Hour), digest[samplePublicKey])
+	require.Equal(t, stableTime.Add(30*time.Minute), digest[otherPublicKey])
+}
+
+func TestLogIndexForKey(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	_, ok := log.IndexForKey(samplePublicKey)
+	require.False(t, ok)
+
+	_, err := log.Append(samplePublicKey, "deadbeef", []byte("v1"), stableTime)
+	require.NoError(t, err)
+	_, err = log.Append(samplePublicKey, "deadbeef", []byte("v2"), stableTime)
+	require.NoError(t, err)
+
+	index, ok := log.IndexForKey(samplePublicKey)
+	require.True(t, ok)
+	require.Equal(t, 1, index)
+}
+
+func TestLogAddCosignature(t *testing.T) {
+	log, _ := newTestLog(t)
+
+	_, err := log.Append(samplePublicKey, "deadbeef", []byte("some board content"), stableTime)
+	require.NoError(t, err)
+
+	head := log.TreeHead()
+
+	witnessPublic, witnessPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	witnessSig := ed25519.Sign(witnessPrivate, TreeHeadPayload(head.TreeSize, head.RootHash))
+	witnessPublicHex := hex.EncodeToString(witnessPublic)
+
+	t.Run("Valid", func(t *testing.T) {
+		err := log.AddCosignature(witnessPublicHex, head.TreeSize, head.RootHash, witnessSig)
+		require.NoError(t, err)
+
+		cosigs := log.Cosignatures(head.TreeSize)
+		require.Len(t, cosigs, 1)
+		require.Equal(t, witnessPublicHex, cosigs[0].WitnessPublicKey)
+	})
+
+	t.Run("StaleTreeHead", func(t *testing.T) {
+		err := log.AddCosignature(witnessPublicHex, head.TreeSize+1, head.RootHash, witnessSig)
+		require.ErrorIs(t, err, ErrStaleTreeHead)
+	})
+
+	t.Run("InvalidSignature", func(t *testing.T) {
+		_, otherPrivate, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		badSig := ed25519.Sign(otherPrivate, TreeHeadPayload(head.TreeSize, head.RootHash))
+
+		err = log.AddCosignature(witnessPublicHex, head.TreeSize, head.RootHash, badSig)
+		require.ErrorIs(t, err, ErrCosignatureInvalid)
+	})
+}
@@ -0,0 +1,107 @@
+// Package nstranslog implements an append-only, Merkle-hashed transparency
+// log for board updates, in the style of RFC 6962 ("Certificate
+// Transparency"). Every successful `Put` on a `nsstore.BoardStore` can be
+// appended as a leaf, after which the log can produce signed tree heads,
+// consistency proofs between two tree sizes, and inclusion proofs for
+// individual leaves so that clients have a way to detect an operator
+// equivocating about what's been stored.
+package nstranslog
+
+import "crypto/sha256"
+
+// leafHashPrefix and nodeHashPrefix are the RFC 6962 domain separation
+// prefixes used to prevent second preimage attacks that confuse leaves with
+// internal nodes.
+const (
+	leafHashPrefix = 0x00
+	nodeHashPrefix = 0x01
+)
+
+// hashLeaf computes the RFC 6962 hash of a leaf: H(0x00 || leaf).
+func hashLeaf(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafHashPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// hashNode computes the RFC 6962 hash of an interior node: H(0x01 || left || right).
+func hashNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodeHashPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// emptyHash is the hash of a tree with no leaves, i.e. the hash of the empty
+// string, as dictated by RFC 6962.
+func emptyHash() []byte {
+	h := sha256.Sum256(nil)
+	return h[:]
+}
+
+// merkleRoot computes the root hash of the Merkle tree over leafHashes[0:n],
+// per the MTH algorithm in RFC 6962 §2.1.
+func merkleRoot(leafHashes [][]byte) []byte {
+	n := len(leafHashes)
+
+	switch {
+	case n == 0:
+		return emptyHash()
+	case n == 1:
+		return leafHashes[0]
+	}
+
+	k := splitPoint(n)
+	return hashNode(merkleRoot(leafHashes[:k]), merkleRoot(leafHashes[k:]))
+}
+
+// splitPoint returns the largest power of two strictly smaller than n, which
+// is where RFC 6962 splits a tree of n leaves into two subtrees.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// inclusionProof computes the audit path proving that the leaf at index m is
+// included in the tree formed by leafHashes, per the PATH algorithm in RFC
+// 6962 §2.1.1.
+func inclusionProof(leafHashes [][]byte, m int) [][]byte {
+	n := len(leafHashes)
+	if n <= 1 {
+		return nil
+	}
+
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionProof(leafHashes[:k], m), merkleRoot(leafHashes[k:]))
+	}
+	return append(inclusionProof(leafHashes[k:], m-k), merkleRoot(leafHashes[:k]))
+}
+
+// consistencyProof computes the proof that the tree of size m is consistent
+// with (i.e. a prefix of) the tree of size n formed by leafHashes, per the
+// PROOF/SUBPROOF algorithm in RFC 6962 §2.1.2.
+func consistencyProof(leafHashes [][]byte, m, n int) [][]byte {
+	return subProof(leafHashes[:n], m, n, true)
+}
+
+func subProof(leafHashes [][]byte, m, n int, haveRoot bool) [][]byte {
+	if m == n {
+		if haveRoot {
+			return nil
+		}
+		return [][]byte{merkleRoot(leafHashes)}
+	}
+
+	k := splitPoint(n)
+
+	if m <= k {
+		return append(subProof(leafHashes[:k], m, k, haveRoot), merkleRoot(leafHashes[k:n]))
+	}
+	return append(subProof(leafHashes[k:n], m-k, n-k, false), merkleRoot(leafHashes[:k]))
+}
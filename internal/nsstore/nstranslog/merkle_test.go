@@ -0,0 +1,99 @@
+package nstranslog
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func leafHashesForTest(n int) [][]byte {
+	leafHashes := make([][]byte, n)
+	for i := range leafHashes {
+		leafHashes[i] = hashLeaf([]byte{byte(i)})
+	}
+	return leafHashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		require.Equal(t, emptyHash(), merkleRoot(nil))
+	})
+
+	t.Run("SingleLeaf", func(t *testing.T) {
+		leafHashes := leafHashesForTest(1)
+		require.Equal(t, leafHashes[0], merkleRoot(leafHashes))
+	})
+
+	t.Run("TwoLeaves", func(t *testing.T) {
+		leafHashes := leafHashesForTest(2)
+		require.Equal(t, hashNode(leafHashes[0], leafHashes[1]), merkleRoot(leafHashes))
+	})
+
+	t.Run("IsStableAsTreeGrows", func(t *testing.T) {
+		// Appending a leaf shouldn't change the hashes of earlier subtrees once
+		// they're a clean power of two in size -- that's the whole point of this
+		// structure being append-only friendly.
+		two := merkleRoot(leafHashesForTest(2))
+		four := merkleRoot(leafHashesForTest(4))
+		require.NotEqual(t, two, four)
+	})
+}
+
+func TestSplitPoint(t *testing.T) {
+	require.Equal(t, 1, splitPoint(2))
+	require.Equal(t, 2, splitPoint(3))
+	require.Equal(t, 2, splitPoint(4))
+	require.Equal(t, 4, splitPoint(5))
+	require.Equal(t, 4, splitPoint(8))
+	require.Equal(t, 8, splitPoint(9))
+}
+
+func TestInclusionProofRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 8, 13, 17} {
+		leafHashes := leafHashesForTest(n)
+		root := merkleRoot(leafHashes)
+
+		for i := 0; i < n; i++ {
+			proof := inclusionProof(leafHashes, i)
+			require.True(t, VerifyInclusionProof(leafHashes[i], i, n, proof, root),
+				"inclusion proof failed for index %d of %d", i, n)
+		}
+	}
+}
+
+func TestInclusionProofRejectsTamperedLeaf(t *testing.T) {
+	leafHashes := leafHashesForTest(8)
+	root := merkleRoot(leafHashes)
+
+	proof := inclusionProof(leafHashes, 3)
+	tamperedLeaf := hashLeaf([]byte("not the real leaf"))
+
+	require.False(t, VerifyInclusionProof(tamperedLeaf, 3, 8, proof, root))
+}
+
+func TestConsistencyProof(t *testing.T) {
+	leafHashes := leafHashesForTest(8)
+
+	t.Run("SameSize", func(t *testing.T) {
+		require.Empty(t, consistencyProof(leafHashes, 8, 8))
+	})
+
+	t.Run("NonEmptyForGrowth", func(t *testing.T) {
+		proof := consistencyProof(leafHashes, 3, 8)
+		require.NotEmpty(t, proof)
+	})
+}
+
+func TestHashLeafDomainSeparation(t *testing.T) {
+	// RFC 6962 prefixes leaves and nodes differently specifically so that a
+	// leaf hash can never collide with an interior node hash for the same
+	// input bytes.
+	data := []byte("some leaf data")
+	leaf := hashLeaf(data)
+	node := hashNode(data[:len(data)/2], data[len(data)/2:])
+	require.NotEqual(t, leaf, node)
+
+	manualLeafHash := sha256.Sum256(append([]byte{0x00}, data...))
+	require.Equal(t, manualLeafHash[:], leaf)
+}
@@ -0,0 +1,33 @@
+package nstranslog
+
+import "bytes"
+
+// VerifyInclusionProof recomputes a tree's root hash from a leaf hash, its
+// index, the size of the tree it's claimed to belong to, and an audit path
+// produced by InclusionProof, then compares it against rootHash. It's the
+// verification counterpart of InclusionProof, and is what a client or witness
+// would run against a log it doesn't otherwise trust.
+func VerifyInclusionProof(leafHash []byte, index, treeSize int, proof [][]byte, rootHash []byte) bool {
+	computed := rootFromInclusionProof(proof, index, treeSize, leafHash)
+	return bytes.Equal(computed, rootHash)
+}
+
+// rootFromInclusionProof mirrors inclusionProof's recursion, consuming the
+// proof from its tail since that's the order in which sibling hashes were
+// appended going from leaf to root.
+func rootFromInclusionProof(proof [][]byte, index, treeSize int, hash []byte) []byte {
+	if treeSize <= 1 {
+		return hash
+	}
+
+	k := splitPoint(treeSize)
+	last := len(proof) - 1
+
+	if index < k {
+		sub := rootFromInclusionProof(proof[:last], index, k, hash)
+		return hashNode(sub, proof[last])
+	}
+
+	sub := rootFromInclusionProof(proof[:last], index-k, treeSize-k, hash)
+	return hashNode(proof[last], sub)
+}
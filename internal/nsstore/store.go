@@ -13,6 +13,14 @@ const (
 
 var ErrKeyNotFound = errors.New("key not found")
 
+// ErrTimestampOlderThanCurrent is returned by Put when the board's timestamp
+// is older than that of the board already stored at the same key. Server
+// enforces this check itself with a Get/Put pair, but a store whose Put can
+// race with other writers for the same key (as disk- or network-backed
+// stores can) should enforce it again atomically as a safety net, returning
+// this error when it does.
+var ErrTimestampOlderThanCurrent = errors.New("timestamp is older than current")
+
 type Board struct {
 	Content   []byte
 	Signature string
@@ -28,4 +36,24 @@ type BoardStore interface {
 	// on a goroutine, so it's not necessary for implementations to start their
 	// own. Stores may no-op if they have an alternative expiration mechanism.
 	ReapLoop(ctx context.Context, shutdown <-chan struct{})
+
+	// Iterate walks every board currently in the store, invoking fn once for
+	// each key. Used by Sweeper to find boards that need expiring under
+	// criteria (like a key's own 83eMMYY suffix, or timestamp-only content)
+	// that a store's own ReapLoop doesn't necessarily check for itself.
+	//
+	// fn should not mutate the store; callers wanting to delete a board found
+	// this way should collect its key and call Delete once iteration has
+	// finished.
+	Iterate(ctx context.Context, fn func(key string, board *Board) error) error
+
+	// Delete permanently removes the board stored at key, if any. A no-op,
+	// not an error, if key doesn't exist.
+	Delete(ctx context.Context, key string) error
+
+	// Count returns the number of boards currently in the store. Used to
+	// compute the realm's current difficulty factor, so callers that need it
+	// on a hot path (like a PUT handler) should cache the result rather than
+	// calling it on every request.
+	Count(ctx context.Context) (int, error)
 }
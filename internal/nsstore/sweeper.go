@@ -0,0 +1,154 @@
+package nsstore
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+)
+
+// DefaultSweepInterval is how often a Sweeper walks its store looking for
+// boards to expire, unless a caller configures a different interval.
+const DefaultSweepInterval = 1 * time.Hour
+
+// SweeperStats tallies what happened during a single sweep so that a caller
+// can verify the sweeper is actually doing something, and so the counts can
+// be surfaced as Prometheus-style metrics by whatever's embedding it.
+type SweeperStats struct {
+	Swept   int
+	Skipped int
+	Errored int
+}
+
+// Sweeper periodically walks a BoardStore's entire contents via Iterate,
+// deleting boards whose key has passed its 83eMMYY expiry, whose content has
+// exceeded MaxContentAge, or whose content is timestamp-only (already
+// treated as deleted by the server, but otherwise left to rot until its
+// content age also happens to elapse). It's a store-agnostic backstop on top
+// of whatever ReapLoop a given store implementation already runs
+// internally -- a store's own reap loop is free to use whatever lookup is
+// fastest against its own underlying storage, while Sweeper only depends on
+// the BoardStore interface and so works identically across all of them.
+type Sweeper struct {
+	interval time.Duration
+	logger   *logrus.Logger
+	store    BoardStore
+	timeNow  func() time.Time
+}
+
+// NewSweeper returns a Sweeper that walks store every interval. An interval
+// of zero defaults to DefaultSweepInterval.
+func NewSweeper(logger *logrus.Logger, store BoardStore, interval time.Duration) *Sweeper {
+	if interval == 0 {
+		interval = DefaultSweepInterval
+	}
+
+	return &Sweeper{
+		interval: interval,
+		logger:   logger,
+		store:    store,
+		timeNow:  time.Now,
+	}
+}
+
+// SetTimeNow overrides the time Sweeper considers "now". For testing purposes
+// only.
+func (s *Sweeper) SetTimeNow(timeNow func() time.Time) {
+	s.timeNow = timeNow
+}
+
+// Run sweeps the store once immediately, then again every interval, until
+// shutdown is closed. Blocks, so it's meant to be started on a goroutine.
+func (s *Sweeper) Run(ctx context.Context, shutdown <-chan struct{}) {
+	for {
+		stats, err := s.Sweep(ctx)
+		if err != nil {
+			s.logger.Errorf("Sweeper: Error sweeping: %v", err)
+		} else {
+			s.logger.WithFields(logrus.Fields{
+				"swept":   stats.Swept,
+				"skipped": stats.Skipped,
+				"errored": stats.Errored,
+			}).Infof("Sweeper: Swept %d board(s), skipped %d, errored %d", stats.Swept, stats.Skipped, stats.Errored)
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Sweep walks the store exactly once, deleting any board that's earned
+// expiry, and returns a tally of what happened.
+func (s *Sweeper) Sweep(ctx context.Context) (*SweeperStats, error) {
+	stats := &SweeperStats{}
+	now := s.timeNow()
+
+	var staleKeys []string
+
+	err := s.store.Iterate(ctx, func(key string, board *Board) error {
+		if !shouldExpire(key, board, now) {
+			stats.Skipped++
+			return nil
+		}
+
+		staleKeys = append(staleKeys, key)
+		return nil
+	})
+	if err != nil {
+		return stats, xerrors.Errorf("error iterating store: %w", err)
+	}
+
+	for _, key := range staleKeys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			stats.Errored++
+			s.logger.Infof("Sweeper: Error deleting expired key %q: %v", key, err)
+			continue
+		}
+
+		stats.Swept++
+	}
+
+	return stats, nil
+}
+
+// shouldExpire reports whether board, stored under key, has earned expiry
+// under any of the Sweeper's criteria.
+func shouldExpire(key string, board *Board, now time.Time) bool {
+	if now.After(board.Timestamp.Add(MaxContentAge)) {
+		return true
+	}
+
+	if isTimestampOnlyContent(board.Content) {
+		return true
+	}
+
+	if _, err := nskey.ParseKey(key, now); err != nil {
+		return true
+	}
+
+	return false
+}
+
+// timestampOnlyRE matches a Spring '83 `<time datetime="...">` tag. Mirrors
+// the one in the server package, which uses it to treat timestamp-only
+// content as an already-deleted board for GET purposes; Sweeper uses it to
+// decide the same content is safe to actually remove from storage.
+var timestampOnlyRE = regexp.MustCompile(`<time datetime="([1-9]\d{3}-(0[1-9]|1[0-2])-\d\dT\d\d:\d\d:\d\dZ)">`)
+
+func isTimestampOnlyContent(content []byte) bool {
+	match := timestampOnlyRE.FindSubmatch(content)
+	if match == nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(bytes.Replace(content, match[0], nil, 1))) == ""
+}
@@ -0,0 +1,73 @@
+package nsstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+const (
+	// A key that's already passed its 83eMMYY expiry suffix, per
+	// server_test.go's TestServerHandleGetKey/KeyExpired.
+	sampleExpiredKey = "ab589f4dde9fce4180fcf42c7b05185b0a02a5d682e353fa39177995083e0519"
+
+	sampleValidKey = "e90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1124"
+)
+
+var stableTime = time.Date(2022, 11, 9, 10, 11, 12, 0, time.UTC)
+
+func TestSweeperSweep(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	store := nsmemorystore.NewMemoryStore(logger, 0)
+	store.SetTimeNow(func() time.Time { return stableTime })
+
+	// Fresh content under a key that's still valid: should survive.
+	require.NoError(t, store.Put(ctx, sampleValidKey, &nsstore.Board{
+		Content:   []byte("some board content"),
+		Timestamp: stableTime,
+	}))
+
+	// Content old enough to have exceeded MaxContentAge: should be swept.
+	require.NoError(t, store.Put(ctx, "stale-content-key", &nsstore.Board{
+		Content:   []byte("some board content"),
+		Timestamp: stableTime.Add(-nsstore.MaxContentAge).Add(-time.Minute),
+	}))
+
+	// Timestamp-only (tombstone) content: should be swept immediately,
+	// without waiting for it to also age out.
+	require.NoError(t, store.Put(ctx, "tombstone-key", &nsstore.Board{
+		Content:   []byte(`<time datetime="2022-11-09T10:11:12Z">`),
+		Timestamp: stableTime,
+	}))
+
+	// Fresh content, but under a key whose own 83eMMYY suffix has already
+	// expired: should be swept.
+	require.NoError(t, store.Put(ctx, sampleExpiredKey, &nsstore.Board{
+		Content:   []byte("some board content"),
+		Timestamp: stableTime,
+	}))
+
+	sweeper := nsstore.NewSweeper(logger, store, time.Hour)
+	sweeper.SetTimeNow(func() time.Time { return stableTime })
+	stats, err := sweeper.Sweep(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, stats.Swept)
+	require.Equal(t, 1, stats.Skipped)
+	require.Equal(t, 0, stats.Errored)
+
+	_, err = store.Get(ctx, sampleValidKey)
+	require.NoError(t, err)
+
+	for _, key := range []string{"stale-content-key", "tombstone-key", sampleExpiredKey} {
+		_, err := store.Get(ctx, key)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound)
+	}
+}
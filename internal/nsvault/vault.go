@@ -0,0 +1,412 @@
+// Package nsvault lets a server lean on HashiCorp Vault for two things it
+// would otherwise have to manage itself: a deny list stored in Vault's KV v2
+// secrets engine, and board attestation signing via Vault's Transit engine,
+// so the server's own private signing key never has to be materialized in
+// this process at all.
+package nsvault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+)
+
+const (
+	// DefaultPollInterval is how often a DenyList re-reads its backing KV v2
+	// secret, absent an operator-configured interval.
+	DefaultPollInterval = 5 * time.Minute
+
+	// DefaultKVMountPath is the mount path a DenyList reads its secret
+	// under, absent an operator-configured one.
+	DefaultKVMountPath = "secret"
+
+	// DefaultTransitMountPath is the mount path a TransitSigner signs
+	// against, absent an operator-configured one.
+	DefaultTransitMountPath = "transit"
+)
+
+// AuthConfig configures how NewClient authenticates to Vault. Exactly one of
+// Token or RoleID/SecretID should be given; Token takes precedence if both
+// are set. Address may be left empty to use $VAULT_ADDR (and the rest of
+// Vault's usual environment-driven client config), the same as the vault
+// CLI itself.
+type AuthConfig struct {
+	Address  string
+	Token    string
+	RoleID   string
+	SecretID string
+}
+
+// NewClient builds and authenticates a Vault API client per config. If
+// authentication produces a renewable lease (as AppRole logins normally
+// do), a background goroutine is started to renew it for the lifetime of
+// the process, re-authenticating from scratch if a renewal is ultimately
+// refused (e.g. because the underlying AppRole secret ID was rotated),
+// so a long-lived server survives Vault-side credential rotation without
+// a restart.
+func NewClient(logger *logrus.Logger, config AuthConfig) (*api.Client, error) {
+	vaultConfig := api.DefaultConfig()
+	if config.Address != "" {
+		vaultConfig.Address = config.Address
+	}
+
+	client, err := api.NewClient(vaultConfig)
+	if err != nil {
+		return nil, xerrors.Errorf("error building vault client: %w", err)
+	}
+
+	if config.Token != "" {
+		client.SetToken(config.Token)
+		return client, nil
+	}
+
+	if config.RoleID == "" {
+		return nil, xerrors.Errorf("no vault auth configured -- set either Token or RoleID/SecretID")
+	}
+
+	login := func(ctx context.Context) (*api.Secret, error) {
+		appRoleAuth, err := approle.NewAppRoleAuth(config.RoleID, &approle.SecretID{FromString: config.SecretID})
+		if err != nil {
+			return nil, xerrors.Errorf("error building approle auth method: %w", err)
+		}
+
+		secret, err := client.Auth().Login(ctx, appRoleAuth)
+		if err != nil {
+			return nil, xerrors.Errorf("error logging into vault via approle: %w", err)
+		}
+
+		return secret, nil
+	}
+
+	secret, err := login(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.Auth != nil && secret.Auth.Renewable {
+		go renewLoop(logger, client, login, secret)
+	}
+
+	return client, nil
+}
+
+// renewLoop keeps secret's lease alive for as long as the process runs,
+// re-authenticating via login if a renewal is ever refused outright rather
+// than just failing transiently.
+func renewLoop(logger *logrus.Logger, client *api.Client, login func(ctx context.Context) (*api.Secret, error), secret *api.Secret) {
+	for {
+		watcher, err := client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: secret})
+		if err != nil {
+			logger.Warnf("nsvault: Error starting token lifetime watcher: %v", err)
+			return
+		}
+
+		go watcher.Start()
+
+		select {
+		case renewal := <-watcher.RenewCh():
+			logger.Infof("nsvault: Renewed vault token at %v", renewal.RenewedAt)
+		case err := <-watcher.DoneCh():
+			watcher.Stop()
+
+			if err != nil {
+				logger.Warnf("nsvault: Vault token renewal failed (%v), re-authenticating", err)
+			} else {
+				logger.Info("nsvault: Vault token lease has expired, re-authenticating")
+			}
+
+			newSecret, loginErr := login(context.Background())
+			if loginErr != nil {
+				logger.Errorf("nsvault: Error re-authenticating to vault: %v", loginErr)
+				return
+			}
+
+			secret = newSecret
+			continue
+		}
+
+		watcher.Stop()
+	}
+}
+
+// DenyList is a DenyList (in the sense of the root package's DenyList
+// interface -- this package can't import that one, but satisfies it
+// structurally) whose entries are a JSON array of hex-encoded public keys
+// stored at a Vault KV v2 path, re-read on every Reload. If Vault is
+// unreachable when a reload is attempted, the previously loaded entries are
+// left in place; if the very first load fails and a cachePath was given,
+// the last successfully loaded snapshot is read from disk instead so
+// startup isn't blocked on Vault being reachable.
+type DenyList struct {
+	cachePath    string
+	client       *api.Client
+	logger       *logrus.Logger
+	mountPath    string
+	pollInterval time.Duration
+	secretPath   string
+
+	mu                sync.RWMutex
+	denied            map[string]struct{}
+	reloadLoopStarted bool
+}
+
+// NewDenyList loads and returns a DenyList reading secretPath off client's
+// mountPath (e.g. "secret" for the default KV v2 mount), expecting its data
+// to carry a "keys" field holding a JSON array of hex-encoded public keys.
+// mountPath defaults to DefaultKVMountPath and pollInterval to
+// DefaultPollInterval if left zero. cachePath may be empty to skip the
+// on-disk fallback entirely.
+func NewDenyList(logger *logrus.Logger, client *api.Client, mountPath, secretPath, cachePath string, pollInterval time.Duration) (*DenyList, error) { //nolint:lll
+	if mountPath == "" {
+		mountPath = DefaultKVMountPath
+	}
+	if pollInterval == 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	l := &DenyList{
+		cachePath:    cachePath,
+		client:       client,
+		logger:       logger,
+		mountPath:    mountPath,
+		pollInterval: pollInterval,
+		secretPath:   secretPath,
+	}
+
+	if err := l.Reload(context.Background()); err != nil {
+		if cachePath == "" {
+			return nil, xerrors.Errorf("error loading initial deny list from vault: %w", err)
+		}
+
+		logger.Infof("nsvault.DenyList: Error loading initial deny list from vault (%v), falling back to cache at %q", err, cachePath)
+		if cacheErr := l.loadCache(); cacheErr != nil {
+			return nil, xerrors.Errorf("error loading initial deny list from vault (%v), and error loading cache: %w", err, cacheErr)
+		}
+	}
+
+	return l, nil
+}
+
+// Contains reports whether key is currently on the deny list.
+func (l *DenyList) Contains(key string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.denied[key]
+	return ok
+}
+
+// Reload re-reads the deny list from Vault, replacing the previously loaded
+// entries wholesale. A failure (Vault unreachable, malformed secret) leaves
+// the previously loaded entries in place rather than emptying the deny
+// list.
+func (l *DenyList) Reload(ctx context.Context) error {
+	secret, err := l.client.KVv2(l.mountPath).Get(ctx, l.secretPath)
+	if err != nil {
+		return xerrors.Errorf("error reading %s/%s from vault: %w", l.mountPath, l.secretPath, err)
+	}
+
+	keys, err := parseVaultKeys(secret.Data)
+	if err != nil {
+		return xerrors.Errorf("error parsing deny list from vault: %w", err)
+	}
+
+	denied := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		denied[key] = struct{}{}
+	}
+
+	l.mu.Lock()
+	l.denied = denied
+	l.mu.Unlock()
+
+	if l.cachePath != "" {
+		if err := l.writeCache(keys); err != nil {
+			l.logger.Infof("nsvault.DenyList: Error writing deny list cache: %v", err)
+		}
+	}
+
+	l.logger.Infof("nsvault.DenyList: Reloaded deny list with %d key(s) from vault", len(keys))
+
+	return nil
+}
+
+// ReloadLoop starts a reloader forever loop that periodically re-reads the
+// deny list from Vault. It blocks, so should be started on a goroutine.
+func (l *DenyList) ReloadLoop(ctx context.Context, shutdown <-chan struct{}) {
+	if l.reloadLoopStarted {
+		panic("ReloadLoop already started -- should only be run once")
+	}
+	l.reloadLoopStarted = true
+
+	ticker := time.NewTicker(l.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			l.logger.Info("nsvault.DenyList: Received shutdown signal")
+			return
+
+		case <-ticker.C:
+		}
+
+		if err := l.Reload(ctx); err != nil {
+			l.logger.Infof("nsvault.DenyList: Error reloading: %v", err)
+		}
+	}
+}
+
+// loadCache loads the last-good snapshot from cachePath, for use when the
+// initial Reload on startup fails because Vault is unreachable.
+func (l *DenyList) loadCache() error {
+	data, err := os.ReadFile(l.cachePath)
+	if err != nil {
+		return xerrors.Errorf("error reading %q: %w", l.cachePath, err)
+	}
+
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return xerrors.Errorf("error parsing cached deny list: %w", err)
+	}
+
+	denied := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		denied[key] = struct{}{}
+	}
+
+	l.mu.Lock()
+	l.denied = denied
+	l.mu.Unlock()
+
+	l.logger.Infof("nsvault.DenyList: Loaded %d key(s) from cache at %q", len(keys), l.cachePath)
+
+	return nil
+}
+
+// writeCache writes keys to cachePath as a JSON array, via a
+// write-then-rename so a crash mid-write can't leave a truncated cache
+// behind.
+func (l *DenyList) writeCache(keys []string) error {
+	encoded, err := json.Marshal(keys)
+	if err != nil {
+		return xerrors.Errorf("error marshaling cache: %w", err)
+	}
+
+	tmpPath := l.cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, encoded, 0o600); err != nil {
+		return xerrors.Errorf("error writing %q: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, l.cachePath); err != nil {
+		return xerrors.Errorf("error renaming %q to %q: %w", tmpPath, l.cachePath, err)
+	}
+
+	return nil
+}
+
+// parseVaultKeys extracts and validates the "keys" field of a KV v2
+// secret's data, expected to be a JSON array of hex-encoded public keys.
+func parseVaultKeys(data map[string]interface{}) ([]string, error) {
+	raw, ok := data["keys"]
+	if !ok {
+		return nil, xerrors.Errorf("secret has no \"keys\" field")
+	}
+
+	rawSlice, ok := raw.([]interface{})
+	if !ok {
+		return nil, xerrors.Errorf("\"keys\" field is not a JSON array")
+	}
+
+	keys := make([]string, 0, len(rawSlice))
+	for _, v := range rawSlice {
+		s, ok := v.(string)
+		if !ok {
+			return nil, xerrors.Errorf("\"keys\" entries must be strings")
+		}
+
+		if _, err := hex.DecodeString(s); err != nil {
+			return nil, xerrors.Errorf("invalid hex-encoded key %q: %w", s, err)
+		}
+
+		keys = append(keys, s)
+	}
+
+	return keys, nil
+}
+
+// TransitSigner is an nskey.Signer backed by a Vault Transit signing key,
+// so the private key material behind it never has to be materialized in
+// this process -- every Sign call is a round trip to Vault's Transit
+// "sign" endpoint instead.
+type TransitSigner struct {
+	client    *api.Client
+	keyName   string
+	mountPath string
+}
+
+var _ nskey.Signer = (*TransitSigner)(nil)
+
+// NewTransitSigner returns a TransitSigner signing against keyName on
+// client's mountPath, which defaults to DefaultTransitMountPath if left
+// empty.
+func NewTransitSigner(client *api.Client, mountPath, keyName string) *TransitSigner {
+	if mountPath == "" {
+		mountPath = DefaultTransitMountPath
+	}
+
+	return &TransitSigner{client: client, keyName: keyName, mountPath: mountPath}
+}
+
+// Sign implements nskey.Signer by calling Vault Transit's sign endpoint.
+// Like nskey.KeyPair.Sign, the nskey.Signer interface gives it no way to
+// return an error, so it panics if Vault is unreachable or the signing
+// request otherwise fails. Unlike KeyPair's in-process signing, a Vault
+// round trip can fail for reasons that have nothing to do with programmer
+// error, so callers on the request path (server.go's wrapEndpoint, in
+// particular) recover from this and turn it into a 500 rather than letting
+// it reach net/http's bare per-connection recovery.
+func (s *TransitSigner) Sign(message []byte) []byte {
+	secret, err := s.client.Logical().Write(fmt.Sprintf("%s/sign/%s", s.mountPath, s.keyName), map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString(message),
+	})
+	if err != nil {
+		panic(xerrors.Errorf("error signing with vault transit key %q: %w", s.keyName, err))
+	}
+
+	rawSignature, ok := secret.Data["signature"].(string)
+	if !ok {
+		panic(xerrors.Errorf("vault transit sign response for key %q is missing its signature", s.keyName))
+	}
+
+	sig, err := decodeTransitSignature(rawSignature)
+	if err != nil {
+		panic(xerrors.Errorf("error decoding vault transit signature: %w", err))
+	}
+
+	return sig
+}
+
+// decodeTransitSignature strips Transit's "vault:v<version>:" prefix off a
+// signature response and base64-decodes what's left into a raw signature.
+func decodeTransitSignature(raw string) ([]byte, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) != 3 {
+		return nil, xerrors.Errorf("unexpected transit signature format %q", raw)
+	}
+
+	return base64.StdEncoding.DecodeString(parts[2])
+}
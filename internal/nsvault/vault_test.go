@@ -0,0 +1,133 @@
+package nsvault
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+const samplePublicKey = "e90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1124"
+
+var logger = logrus.New()
+
+// newTestClient returns a Vault API client pointed at server, authenticated
+// with a fixed test token.
+func newTestClient(t *testing.T, server *httptest.Server) *api.Client {
+	t.Helper()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+	client.SetToken("test-token")
+	client.SetMaxRetries(0)
+
+	return client
+}
+
+// kvV2Handler serves a single KV v2 secret at /v1/<mountPath>/data/<path>
+// whose data is {"keys": keys}.
+func kvV2Handler(mountPath, path string, keys []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+mountPath+"/data/"+path {
+			http.NotFound(w, r)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"keys": keys},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	}
+}
+
+func TestDenyList(t *testing.T) {
+	server := httptest.NewServer(kvV2Handler("secret", "denied", []string{samplePublicKey}))
+	defer server.Close()
+
+	denyList, err := NewDenyList(logger, newTestClient(t, server), "secret", "denied", "", 0)
+	require.NoError(t, err)
+
+	require.True(t, denyList.Contains(samplePublicKey))
+	require.False(t, denyList.Contains("some-other-key"))
+}
+
+func TestDenyListReload(t *testing.T) {
+	keys := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kvV2Handler("secret", "denied", keys)(w, r)
+	}))
+	defer server.Close()
+
+	denyList, err := NewDenyList(logger, newTestClient(t, server), "secret", "denied", "", 0)
+	require.NoError(t, err)
+	require.False(t, denyList.Contains(samplePublicKey))
+
+	keys = []string{samplePublicKey}
+	require.NoError(t, denyList.Reload(t.Context()))
+	require.True(t, denyList.Contains(samplePublicKey))
+}
+
+func TestDenyListFallsBackToCacheWhenVaultUnreachable(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "denied.json")
+	cached, err := json.Marshal([]string{samplePublicKey})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cachePath, cached, 0o600))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unreachable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	denyList, err := NewDenyList(logger, newTestClient(t, server), "secret", "denied", cachePath, 0)
+	require.NoError(t, err)
+	require.True(t, denyList.Contains(samplePublicKey))
+}
+
+func TestDenyListNoCacheReturnsErrorWhenVaultUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unreachable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewDenyList(logger, newTestClient(t, server), "secret", "denied", "", 0)
+	require.Error(t, err)
+}
+
+func TestTransitSigner(t *testing.T) {
+	const message = "some board content"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/transit/sign/mykey" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var body struct {
+			Input string `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, base64.StdEncoding.EncodeToString([]byte(message)), body.Input)
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"signature": "vault:v1:" + base64.StdEncoding.EncodeToString([]byte("fake-signature")),
+			},
+		})
+	}))
+	defer server.Close()
+
+	signer := NewTransitSigner(newTestClient(t, server), "", "mykey")
+	require.Equal(t, []byte("fake-signature"), signer.Sign([]byte(message)))
+}
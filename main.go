@@ -2,21 +2,40 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
+	"runtime"
+	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/hashicorp/vault/api"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/xerrors"
 
+	"github.com/brandur/neospring/internal/crawler"
+	"github.com/brandur/neospring/internal/nsclient"
+	"github.com/brandur/neospring/internal/nskey"
 	"github.com/brandur/neospring/internal/nskeygen"
 	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsboltstore"
+	"github.com/brandur/neospring/internal/nsstore/nsdiskstore"
 	"github.com/brandur/neospring/internal/nsstore/nsgcpstoragestore"
 	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+	"github.com/brandur/neospring/internal/nsstore/nsredisstore"
+	"github.com/brandur/neospring/internal/nsstore/nss3store"
+	"github.com/brandur/neospring/internal/nsstore/nsshardstore"
+	"github.com/brandur/neospring/internal/nsstore/nssqlitestore"
+	"github.com/brandur/neospring/internal/nsvault"
 )
 
 const defaultPort = 4434 // 2217 * 2
@@ -43,7 +62,7 @@ neospring serve
 neospring keygen
 		`),
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := runServe(ctx); err != nil {
+			if err := runServe(ctx, 0); err != nil {
 				abortErr(err)
 			}
 		},
@@ -51,6 +70,8 @@ neospring keygen
 
 	// neospring keygen
 	{
+		var fromMnemonic, mnemonicPublicKey, vanitySuffix string
+
 		cmd := &cobra.Command{
 			Use:   "keygen",
 			Short: "Generate a conforming Spring '83 keypair",
@@ -61,34 +82,194 @@ builds in an automatic challenge factor in generating a new key, thereby helping
 to curb abuse. This command brute forces a conforming keypair in a way that
 leverages parallelism and some optimizations to do so as quickly as possible,
 but depending on hardware, may still take 3 to 30 minutes to complete.
+
+Also prints a mnemonic phrase for the generated key, and conversely, can
+restore a keypair from a phrase previously printed this way via
+--from-mnemonic.
+
+--vanity adds extra hex characters that the key's public portion must end
+with (beyond the magic expiry suffix that's always required), at the cost of
+a 16x search time penalty per character.
 			`),
 			Run: func(cmd *cobra.Command, args []string) {
-				if err := runKeygen(ctx); err != nil {
+				if fromMnemonic != "" {
+					if err := runKeygenFromMnemonic(fromMnemonic, mnemonicPublicKey); err != nil {
+						abortErr(err)
+					}
+					return
+				}
+
+				if err := runKeygen(ctx, vanitySuffix); err != nil {
 					abortErr(err)
 				}
 			},
 		}
+		cmd.Flags().StringVar(&fromMnemonic, "from-mnemonic", "",
+			"Restore a keypair from a mnemonic phrase printed by a previous run of this command, instead of generating a new one")
+		cmd.Flags().StringVar(&mnemonicPublicKey, "public-key", "",
+			"Public key to pair with --from-mnemonic; not needed if the phrase was printed as a full mnemonic")
+		cmd.Flags().StringVar(&vanitySuffix, "vanity", "",
+			"Extra hex characters to target at the end of the public key, beyond the required expiry suffix")
 		rootCmd.AddCommand(cmd)
 	}
 
 	// neospring serve
 	{
+		var metricsPort int
+
 		cmd := &cobra.Command{
 			Use:   "serve",
 			Short: "Start Spring '83 server",
 			Long: strings.TrimSpace(fmt.Sprintf(`
 Starts a Spring '83 server, binding to $PORT, or default to %d. Allows boards to
 be posted and retrieved in accordance with protocol specification.
+
+By default, Prometheus metrics are exposed at /metrics on the main port
+alongside the rest of the API. --metrics-port (or $METRICS_PORT) moves them
+to their own listener instead, so they're not publicly reachable unless an
+operator explicitly exposes that port.
 			`, defaultPort)),
 			Run: func(cmd *cobra.Command, args []string) {
-				if err := runServe(ctx); err != nil {
+				if err := runServe(ctx, metricsPort); err != nil {
+					abortErr(err)
+				}
+			},
+		}
+		cmd.Flags().IntVar(&metricsPort, "metrics-port", 0,
+			"Port to serve Prometheus metrics on separately from the main API; if unset, metrics are served on the main port")
+		rootCmd.AddCommand(cmd)
+	}
+
+	// neospring crawl
+	{
+		var maxDepth, maxFanout int
+		var storePath, timeout string
+
+		cmd := &cobra.Command{
+			Use:   "crawl <seed-url>",
+			Short: "Discover Spring '83 boards from the open web",
+			Long: strings.TrimSpace(`
+Fetches a homepage URL, follows its <link rel="alternate" type="text/board+html">
+elements to discover boards, verifies each one's signature, and recurses into
+each board's own <link rel="next"> chain. Prints a JSON report of everything
+discovered, verified, rejected, and (if --store-path is given) stored.
+
+This gives an operator a way to bootstrap a new node's store from the open
+web, as an alternative to only ever learning about boards via peer pushes.
+			`),
+			Args: cobra.ExactArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				if err := runCrawl(ctx, args[0], storePath, maxDepth, maxFanout, timeout); err != nil {
 					abortErr(err)
 				}
 			},
 		}
+		cmd.Flags().IntVar(&maxDepth, "max-depth", crawler.DefaultMaxDepth,
+			"Maximum number of <link rel=\"next\"> hops to follow away from a discovered board")
+		cmd.Flags().IntVar(&maxFanout, "max-fanout", crawler.DefaultMaxFanout,
+			"Maximum number of board or \"next\" links a single page may contribute")
+		cmd.Flags().StringVar(&storePath, "store-path", "",
+			"If given, verified boards are PUT into a disk store at this path to seed this node; otherwise boards are only discovered and verified, not stored")
+		cmd.Flags().StringVar(&timeout, "timeout", crawler.DefaultTimeout.String(),
+			"Wall-clock time budget for the whole crawl, as a Go duration string")
 		rootCmd.AddCommand(cmd)
 	}
 
+	// neospring publish
+	{
+		var host, key, privateKey, contentFile string
+
+		cmd := &cobra.Command{
+			Use:   "publish",
+			Short: "Sign and publish a board to a Spring '83 host",
+			Long: strings.TrimSpace(`
+Reads board content from --content-file (or stdin if omitted), signs it with
+the keypair given by --key/--private, and PUTs it to --host, a base URL like
+https://example.com.
+			`),
+			Run: func(cmd *cobra.Command, args []string) {
+				if err := runPublish(ctx, host, key, privateKey, contentFile); err != nil {
+					abortErr(err)
+				}
+			},
+		}
+		cmd.Flags().StringVar(&host, "host", "", "Base URL of the Spring '83 host to publish to (required)")
+		cmd.Flags().StringVar(&key, "key", "", "Public key to publish under (required)")
+		cmd.Flags().StringVar(&privateKey, "private", "", "Private key to sign with (required)")
+		cmd.Flags().StringVar(&contentFile, "content-file", "", "File containing the board content to publish; reads stdin if omitted")
+		_ = cmd.MarkFlagRequired("host")
+		_ = cmd.MarkFlagRequired("key")
+		_ = cmd.MarkFlagRequired("private")
+		rootCmd.AddCommand(cmd)
+	}
+
+	// neospring get
+	{
+		var host, key, ifModifiedSince string
+		var verifyOnly bool
+
+		cmd := &cobra.Command{
+			Use:   "get",
+			Short: "Fetch and verify a board from a Spring '83 host",
+			Long: strings.TrimSpace(`
+Fetches --key from --host, verifying its signature and embedded <time
+datetime> tag before printing it. Exits non-zero if the host's response
+violates the protocol in any way. With --verify-only, the board's content is
+not printed -- only the verification result matters.
+			`),
+			Run: func(cmd *cobra.Command, args []string) {
+				if err := runGet(ctx, host, key, ifModifiedSince, verifyOnly); err != nil {
+					abortErr(err)
+				}
+			},
+		}
+		cmd.Flags().StringVar(&host, "host", "", "Base URL of the Spring '83 host to fetch from (required)")
+		cmd.Flags().StringVar(&key, "key", "", "Public key to fetch (required)")
+		cmd.Flags().StringVar(&ifModifiedSince, "if-modified-since", "",
+			"Only fetch the board if it's changed since this time (RFC1123 format)")
+		cmd.Flags().BoolVar(&verifyOnly, "verify-only", false,
+			"Don't print the board content; only verify it and set the exit code accordingly")
+		_ = cmd.MarkFlagRequired("host")
+		_ = cmd.MarkFlagRequired("key")
+		rootCmd.AddCommand(cmd)
+	}
+
+	// neospring denylist
+	{
+		denylistCmd := &cobra.Command{
+			Use:   "denylist",
+			Short: "Deny list administration tools",
+		}
+
+		// neospring denylist sign
+		{
+			var privateKey, outputPath string
+
+			cmd := &cobra.Command{
+				Use:   "sign <deny-list-file>",
+				Short: "Sign a deny list file for $DENYLIST_ADMIN_PUBKEY verification",
+				Long: strings.TrimSpace(`
+Signs the raw bytes of a deny list file (the same JSON-lines format read by
+FileDenyList and HTTPDenyList) with an ed25519 private key, producing the
+detached hex-encoded signature that a node configured with the corresponding
+$DENYLIST_ADMIN_PUBKEY expects to find alongside it at <path>.sig.
+				`),
+				Args: cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if err := runDenylistSign(args[0], privateKey, outputPath); err != nil {
+						abortErr(err)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&privateKey, "private", "", "Private key to sign with (required)")
+			cmd.Flags().StringVar(&outputPath, "output", "", "Where to write the signature; defaults to <deny-list-file>.sig")
+			_ = cmd.MarkFlagRequired("private")
+			denylistCmd.AddCommand(cmd)
+		}
+
+		rootCmd.AddCommand(denylistCmd)
+	}
+
 	if err := rootCmd.Execute(); err != nil {
 		abortErr(err)
 	}
@@ -103,11 +284,21 @@ func abortErr(err error) {
 	abort("error: %v", err)
 }
 
-func runKeygen(ctx context.Context) error {
+func runKeygen(ctx context.Context, vanitySuffix string) error {
 	t := time.Now()
-	fmt.Printf("Brute forcing a Spring '83 key (this could take a while)\n")
 
-	key, totalIterations, err := nskeygen.GenerateConformingKey(ctx, t)
+	opts := nskeygen.GenerateOptions{
+		Suffix: vanitySuffix,
+		Progress: func(iterations int64, elapsed time.Duration) {
+			fmt.Printf("... %d keys tried in %v (%.0f/s)\n",
+				iterations, elapsed.Round(time.Second), nskeygen.KeysPerSecond(int(iterations), elapsed))
+		},
+	}
+
+	difficulty, _ := nskeygen.EstimateDifficulty(opts).Float64()
+	fmt.Printf("Brute forcing a Spring '83 key (expected ~%.0f keys; this could take a while)\n", difficulty)
+
+	key, totalIterations, err := nskeygen.GenerateConformingKey(ctx, t, opts)
 	if err != nil {
 		return err
 	}
@@ -116,14 +307,290 @@ func runKeygen(ctx context.Context) error {
 	fmt.Printf("Private key: %s\n", key.PrivateKey)
 	fmt.Printf("Public  key: %s\n", key.PublicKey)
 
+	keyPair := nskey.KeyPairFromRaw(key.PrivateKey)
+
+	mnemonicPhrase, err := keyPair.Mnemonic()
+	if err != nil {
+		return xerrors.Errorf("error encoding mnemonic: %w", err)
+	}
+	fmt.Printf("Mnemonic: %s\n", mnemonicPhrase)
+
+	fullMnemonicPhrase, err := keyPair.FullMnemonic()
+	if err != nil {
+		return xerrors.Errorf("error encoding full mnemonic: %w", err)
+	}
+	fmt.Printf("Full mnemonic (encodes public key too): %s\n", fullMnemonicPhrase)
+
+	return nil
+}
+
+// runKeygenFromMnemonic restores a keypair from a previously printed
+// mnemonic phrase rather than generating a new one. publicKey is required
+// unless phrase is a full mnemonic, which encodes the public key itself.
+func runKeygenFromMnemonic(phrase, publicKey string) error {
+	var (
+		keyPair *nskey.KeyPair
+		err     error
+	)
+
+	if publicKey == "" {
+		keyPair, err = nskey.ParseKeyPairFromFullMnemonic(phrase)
+	} else {
+		keyPair, err = nskey.ParseKeyPairFromMnemonic(phrase, publicKey)
+	}
+	if err != nil {
+		return xerrors.Errorf("error restoring keypair from mnemonic: %w", err)
+	}
+
+	fmt.Printf("Private key: %s\n", keyPair.PrivateKey)
+	fmt.Printf("Public  key: %s\n", keyPair.PublicKey)
+
+	return nil
+}
+
+// runCrawl discovers boards starting from seedURL and prints the resulting
+// crawler.Report as JSON. If storePath is non-empty, verified boards are
+// also PUT into a disk store opened at that path, seeding it the way
+// runServe's own "disk" store would populate itself over time via peer
+// federation.
+func runCrawl(ctx context.Context, seedURL, storePath string, maxDepth, maxFanout int, timeout string) error {
+	timeoutDuration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return xerrors.Errorf("error parsing --timeout %q: %w", timeout, err)
+	}
+
+	logger := newLogger()
+
+	opts := crawler.Options{
+		MaxDepth:  maxDepth,
+		MaxFanout: maxFanout,
+		Timeout:   timeoutDuration,
+	}
+
+	if storePath != "" {
+		diskStore, err := nsdiskstore.NewDiskStore(logger, storePath)
+		if err != nil {
+			return xerrors.Errorf("error opening disk store: %w", err)
+		}
+		opts.Store = diskStore
+	}
+
+	report, err := crawler.NewCrawler(opts).Crawl(ctx, seedURL)
+	if err != nil {
+		return xerrors.Errorf("error crawling %q: %w", seedURL, err)
+	}
+
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding crawl report: %w", err)
+	}
+
+	fmt.Println(string(encoded))
+
+	return nil
+}
+
+// runPublish signs content read from contentFile (or stdin) with the keypair
+// given by key/privateKey, and PUTs it to host.
+func runPublish(ctx context.Context, host, key, privateKey, contentFile string) error {
+	keyPair, err := nskey.ParseKeyPairUnchecked(privateKey)
+	if err != nil {
+		return xerrors.Errorf("error parsing private key: %w", err)
+	}
+	if keyPair.PublicKey != key {
+		return xerrors.Errorf("--private key doesn't correspond to --key %q", key)
+	}
+
+	var content []byte
+	if contentFile != "" {
+		content, err = os.ReadFile(contentFile)
+	} else {
+		content, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return xerrors.Errorf("error reading content: %w", err)
+	}
+
+	if err := nsclient.NewClient(nil).Publish(ctx, host, keyPair, content, time.Time{}); err != nil {
+		return xerrors.Errorf("error publishing to %q: %w", host, err)
+	}
+
+	fmt.Println("Published successfully")
+
 	return nil
 }
 
-func runServe(ctx context.Context) error {
+// runGet fetches key from host, verifying its signature and embedded <time
+// datetime> tag, and prints it unless verifyOnly is set.
+func runGet(ctx context.Context, host, key, ifModifiedSinceStr string, verifyOnly bool) error {
+	var ifModifiedSince time.Time
+	if ifModifiedSinceStr != "" {
+		var err error
+		ifModifiedSince, err = time.Parse(time.RFC1123, ifModifiedSinceStr)
+		if err != nil {
+			return xerrors.Errorf("error parsing --if-modified-since %q: %w", ifModifiedSinceStr, err)
+		}
+	}
+
+	board, err := nsclient.NewClient(nil).Get(ctx, host, key, ifModifiedSince)
+	if err != nil {
+		return xerrors.Errorf("error getting %q from %q: %w", key, host, err)
+	}
+
+	if !verifyOnly {
+		fmt.Println(string(board.Content))
+	}
+
+	return nil
+}
+
+// runDenylistSign signs the deny list file at path with privateKey, writing
+// the detached hex-encoded signature to outputPath (defaulting to
+// path+denyListSigSuffix).
+func runDenylistSign(path, privateKey, outputPath string) error {
+	keyPair, err := nskey.ParseKeyPairUnchecked(privateKey)
+	if err != nil {
+		return xerrors.Errorf("error parsing private key: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return xerrors.Errorf("error reading %q: %w", path, err)
+	}
+
+	if outputPath == "" {
+		outputPath = path + denyListSigSuffix
+	}
+
+	if err := os.WriteFile(outputPath, []byte(hex.EncodeToString(keyPair.Sign(data))), 0o600); err != nil {
+		return xerrors.Errorf("error writing %q: %w", outputPath, err)
+	}
+
+	fmt.Printf("Wrote signature to %s\n", outputPath)
+
+	return nil
+}
+
+// loadOrGenerateLogKeyPair returns the Ed25519 keypair used to sign the
+// transparency log's tree heads. If privateKey is given (via $LOG_PRIVATE_KEY),
+// it's parsed and used; otherwise a fresh keypair is generated for the
+// lifetime of the process, which is fine for development but means tree heads
+// won't be verifiable against a previous run after a restart in production.
+func loadOrGenerateLogKeyPair(privateKey string) (*nskey.KeyPair, error) {
+	if privateKey != "" {
+		return nskey.ParseKeyPairUnchecked(privateKey)
+	}
+
+	_, privateKeyBytes, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, xerrors.Errorf("error generating transparency log key: %w", err)
+	}
+
+	return nskey.KeyPairFromRaw(privateKeyBytes), nil
+}
+
+// newLogger builds the process's logger, configured to emit structured JSON
+// (rather than logrus's default text format) so that log entries are easy to
+// grep and aggregate, with every entry enriched with a fixed set of
+// process-level fields -- the running binary's VCS revision and build time
+// (if available), its PID, and the Go version it was built with.
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+
+	fields := logrus.Fields{
+		"go_version": runtime.Version(),
+		"pid":        os.Getpid(),
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				fields["vcs_revision"] = setting.Value
+			case "vcs.time":
+				fields["vcs_time"] = setting.Value
+			}
+		}
+	}
+
+	logger.AddHook(&staticFieldsHook{fields: fields})
+
+	return logger
+}
+
+// staticFieldsHook injects a fixed set of fields into every log entry passed
+// through it. Used to attach process-level metadata that logrus has no
+// built-in way to set once on a *logrus.Logger and have carried onto every
+// entry it produces.
+type staticFieldsHook struct {
+	fields logrus.Fields
+}
+
+func (h *staticFieldsHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *staticFieldsHook) Fire(entry *logrus.Entry) error {
+	for k, v := range h.fields {
+		if _, ok := entry.Data[k]; !ok {
+			entry.Data[k] = v
+		}
+	}
+
+	return nil
+}
+
+// runServe starts the server. metricsPort, if non-zero, overrides
+// $METRICS_PORT and moves the /metrics endpoint to its own listener rather
+// than the main one; pass 0 to defer entirely to $METRICS_PORT (including
+// its unset default of serving metrics on the main port).
+func runServe(ctx context.Context, metricsPort int) error {
 	type Config struct {
-		GCPCredentialsJSON string `env:"GCP_CREDENTIALS_JSON"`
-		GCPStorageBucket   string `env:"GCP_STORAGE_BUCKET"`
-		Port               int    `env:"PORT" envDefault:"4434"`
+		AccessLogBackend         string        `env:"ACCESS_LOG_BACKEND" envDefault:"logrus"`
+		AccessLogSamplePercent   int           `env:"ACCESS_LOG_SAMPLE_PERCENT" envDefault:"100"`
+		BoltNoSync               bool          `env:"BOLT_NO_SYNC"`
+		BoltPath                 string        `env:"BOLT_PATH"`
+		ClusterPeers             []string      `env:"CLUSTER_PEERS" envSeparator:","`
+		ClusterReplicationFactor int           `env:"CLUSTER_REPLICATION_FACTOR" envDefault:"2"`
+		DenyListAdminPublicKey   string        `env:"DENYLIST_ADMIN_PUBKEY"`
+		DenyListCachePath        string        `env:"DENYLIST_CACHE_PATH"`
+		DenyListPath             string        `env:"DENYLIST_PATH"`
+		DenyListPollInterval     time.Duration `env:"DENYLIST_POLL_INTERVAL"`
+		DenyListURLs             []string      `env:"DENYLIST_URLS" envSeparator:","`
+		FederationPeers          []string      `env:"FEDERATION_PEERS" envSeparator:","`
+		FederationSelfURL        string        `env:"FEDERATION_SELF_URL"`
+		GCPCredentialsJSON       string        `env:"GCP_CREDENTIALS_JSON"`
+		GCPStorageBucket         string        `env:"GCP_STORAGE_BUCKET"`
+		LogPrivateKey            string        `env:"LOG_PRIVATE_KEY"`
+		LongRunningRoutePattern  string        `env:"LONG_RUNNING_ROUTE_PATTERN"`
+		MaxInFlightBuckets       int           `env:"MAX_IN_FLIGHT_BUCKETS"`
+		MaxMemoryCacheEntries    int           `env:"MAX_MEMORY_CACHE_ENTRIES"`
+		MaxReadInFlight          int           `env:"MAX_READ_IN_FLIGHT"`
+		MaxWriteInFlight         int           `env:"MAX_WRITE_IN_FLIGHT"`
+		MetricsPort              int           `env:"METRICS_PORT"`
+		Port                     int           `env:"PORT" envDefault:"4434"`
+		RateLimitIPBurst         int           `env:"RATE_LIMIT_IP_BURST"`
+		RateLimitIPPerSecond     float64       `env:"RATE_LIMIT_IP_PER_SECOND"`
+		RateLimitKeyBurst        int           `env:"RATE_LIMIT_KEY_BURST"`
+		RateLimitKeyPerSecond    float64       `env:"RATE_LIMIT_KEY_PER_SECOND"`
+		RateLimitShards          int           `env:"RATE_LIMIT_SHARDS"`
+		RedisCacheEnabled        bool          `env:"REDIS_CACHE_ENABLED" envDefault:"true"`
+		RedisURL                 string        `env:"REDIS_URL"`
+		S3AccessKeyID            string        `env:"S3_ACCESS_KEY_ID"`
+		S3Bucket                 string        `env:"S3_BUCKET"`
+		S3Endpoint               string        `env:"S3_ENDPOINT"`
+		S3Region                 string        `env:"S3_REGION" envDefault:"us-east-1"`
+		S3SecretAccessKey        string        `env:"S3_SECRET_ACCESS_KEY"`
+		Store                    string        `env:"STORE" envDefault:"memory"`
+		StorePath                string        `env:"STORE_PATH" envDefault:"neospring.db"`
+		VaultAddr                string        `env:"VAULT_ADDR"`
+		VaultDenyListCachePath   string        `env:"VAULT_DENYLIST_CACHE_PATH"`
+		VaultDenyListPath        string        `env:"VAULT_DENYLIST_PATH"`
+		VaultKVMount             string        `env:"VAULT_KV_MOUNT"`
+		VaultRoleID              string        `env:"VAULT_ROLE_ID"`
+		VaultSecretID            string        `env:"VAULT_SECRET_ID"`
+		VaultToken               string        `env:"VAULT_TOKEN"`
+		VaultTransitKeyName      string        `env:"VAULT_TRANSIT_KEY_NAME"`
+		VaultTransitMount        string        `env:"VAULT_TRANSIT_MOUNT"`
 	}
 
 	config := Config{}
@@ -131,8 +598,90 @@ func runServe(ctx context.Context) error {
 		return xerrors.Errorf("error parsing env config: %w", err)
 	}
 
-	denyList := NewMemoryDenyList()
-	logger := logrus.New()
+	if metricsPort != 0 {
+		config.MetricsPort = metricsPort
+	}
+
+	logKeyPair, err := loadOrGenerateLogKeyPair(config.LogPrivateKey)
+	if err != nil {
+		return xerrors.Errorf("error loading transparency log key: %w", err)
+	}
+
+	logger := newLogger()
+
+	// A single vault client, built lazily the first time either the deny
+	// list or the transparency log signer needs one, and shared between
+	// them since they'd otherwise duplicate the same auth/renewal setup.
+	var vaultClient *api.Client
+	getVaultClient := func() (*api.Client, error) {
+		if vaultClient == nil {
+			client, err := nsvault.NewClient(logger, nsvault.AuthConfig{
+				Address:  config.VaultAddr,
+				Token:    config.VaultToken,
+				RoleID:   config.VaultRoleID,
+				SecretID: config.VaultSecretID,
+			})
+			if err != nil {
+				return nil, xerrors.Errorf("error building vault client: %w", err)
+			}
+			vaultClient = client
+		}
+
+		return vaultClient, nil
+	}
+
+	var logSigner nskey.Signer = logKeyPair
+	if config.VaultTransitKeyName != "" {
+		client, err := getVaultClient()
+		if err != nil {
+			return err
+		}
+		logSigner = nsvault.NewTransitSigner(client, config.VaultTransitMount, config.VaultTransitKeyName)
+	}
+
+	var accessLogger AccessLogger
+	switch config.AccessLogBackend {
+	case "", "logrus":
+		accessLogger = NewLogrusAccessLogger(logger)
+
+	case "slog":
+		accessLogger = NewSlogAccessLogger()
+
+	default:
+		return xerrors.Errorf("unknown $ACCESS_LOG_BACKEND %q (expected one of: logrus, slog)", config.AccessLogBackend)
+	}
+
+	var denyList DenyList
+	switch {
+	case config.DenyListPath != "":
+		fileDenyList, err := NewFileDenyList(logger, config.DenyListPath, config.DenyListAdminPublicKey, config.DenyListPollInterval)
+		if err != nil {
+			return xerrors.Errorf("error loading deny list: %w", err)
+		}
+		denyList = fileDenyList
+
+	case len(config.DenyListURLs) > 0:
+		httpDenyList, err := NewHTTPDenyList(logger, nil, config.DenyListURLs, config.DenyListAdminPublicKey, config.DenyListCachePath, config.DenyListPollInterval)
+		if err != nil {
+			return xerrors.Errorf("error loading deny list: %w", err)
+		}
+		denyList = httpDenyList
+
+	case config.VaultDenyListPath != "":
+		client, err := getVaultClient()
+		if err != nil {
+			return err
+		}
+
+		vaultDenyList, err := nsvault.NewDenyList(logger, client, config.VaultKVMount, config.VaultDenyListPath, config.VaultDenyListCachePath, config.DenyListPollInterval) //nolint:lll
+		if err != nil {
+			return xerrors.Errorf("error loading deny list: %w", err)
+		}
+		denyList = vaultDenyList
+
+	default:
+		denyList = NewMemoryDenyList()
+	}
 
 	shutdown := make(chan struct{}, 1)
 
@@ -141,19 +690,98 @@ func runServe(ctx context.Context) error {
 	case config.GCPStorageBucket != "":
 		store = nsgcpstoragestore.NewGCPStorageStore(ctx, logger, config.GCPCredentialsJSON, config.GCPStorageBucket)
 
+	case config.S3Bucket != "":
+		store = nss3store.NewS3Store(ctx, logger, config.S3Endpoint, config.S3Region, config.S3AccessKeyID, config.S3SecretAccessKey, config.S3Bucket) //nolint:lll
+
+	case config.BoltPath != "":
+		boltStore, err := nsboltstore.NewBoltStore(logger, config.BoltPath, nsboltstore.BoltConfig{NoSync: config.BoltNoSync})
+		if err != nil {
+			return xerrors.Errorf("error opening bolt store: %w", err)
+		}
+		store = boltStore
+
+	case config.RedisURL != "":
+		redisOpts, err := redis.ParseURL(config.RedisURL)
+		if err != nil {
+			return xerrors.Errorf("error parsing $REDIS_URL: %w", err)
+		}
+
+		redisStore := nsredisstore.NewRedisStore(logger, redis.NewClient(redisOpts))
+
+		if config.RedisCacheEnabled {
+			cache := nsmemorystore.NewMemoryStore(logger, config.MaxMemoryCacheEntries)
+
+			go redisStore.SubscribeLoop(ctx, shutdown, func(key string, board *nsstore.Board) {
+				if err := cache.Put(ctx, key, board); err != nil {
+					logger.Warnf("Error refreshing cache for key %q: %v", key, err)
+				}
+			})
+
+			store = nsstore.NewCacheStore(logger, cache, redisStore)
+		} else {
+			store = redisStore
+		}
+
 	default:
-		store = nsmemorystore.NewMemoryStore(logger)
+		switch config.Store {
+		case "memory":
+			store = nsmemorystore.NewMemoryStore(logger, config.MaxMemoryCacheEntries)
+
+		case "disk":
+			diskStore, err := nsdiskstore.NewDiskStore(logger, config.StorePath)
+			if err != nil {
+				return xerrors.Errorf("error opening disk store: %w", err)
+			}
+			store = diskStore
+
+		case "sqlite":
+			sqliteStore, err := nssqlitestore.NewSQLiteStore(logger, config.StorePath)
+			if err != nil {
+				return xerrors.Errorf("error opening sqlite store: %w", err)
+			}
+			store = sqliteStore
+
+		default:
+			return xerrors.Errorf("unknown $STORE %q (expected one of: memory, disk, sqlite)", config.Store)
+		}
 	}
 
-	logger.Infof("Activating store: %s", reflect.TypeOf(store).Elem().Name())
+	if len(config.ClusterPeers) > 0 {
+		store = nsshardstore.NewShardStore(logger, store, config.ClusterPeers, config.ClusterReplicationFactor)
+	}
+
+	backend := reflect.TypeOf(store).Elem().Name()
+	logger.Infof("Activating store: %s", backend)
+	store = nsstore.NewInstrumentedStore(logger, store, backend)
+
 	go store.ReapLoop(ctx, shutdown)
 
-	server := NewServer(logger, store, denyList, config.Port)
+	if reloadLooper, ok := denyList.(reloadLooper); ok {
+		go reloadLooper.ReloadLoop(ctx, shutdown)
+	}
+
+	server := NewServer(logger, store, denyList, logSigner, config.FederationSelfURL, config.FederationPeers, config.Port, config.MetricsPort,
+		MaxInFlightConfig{
+			MaxReadInFlight:         config.MaxReadInFlight,
+			MaxWriteInFlight:        config.MaxWriteInFlight,
+			Buckets:                 config.MaxInFlightBuckets,
+			LongRunningRoutePattern: config.LongRunningRoutePattern,
+		},
+		AccessLogConfig{
+			Logger:        accessLogger,
+			SamplePercent: config.AccessLogSamplePercent,
+		},
+		RateLimitConfig{
+			IPRatePerSecond:  config.RateLimitIPPerSecond,
+			IPBurst:          config.RateLimitIPBurst,
+			KeyRatePerSecond: config.RateLimitKeyPerSecond,
+			KeyBurst:         config.RateLimitKeyBurst,
+			Shards:           config.RateLimitShards,
+		},
+		shutdown)
 	if err := server.Start(ctx); err != nil {
 		return err
 	}
 
-	close(shutdown)
-
 	return nil
 }
@@ -3,20 +3,46 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	"golang.org/x/xerrors"
 
+	"github.com/brandur/neospring/internal/util/randutil"
 	"github.com/brandur/neospring/internal/util/stringutil"
 )
 
+// RequestIDHeader is the response header a request's minted ID is echoed back
+// under, so a client or intermediary proxy can correlate its request against
+// our logs.
+const RequestIDHeader = "X-Request-ID"
+
+// TraceparentHeader is the W3C Trace Context header ContextContainerMiddleware
+// consults when minting a request's ID, so that a request already part of a
+// distributed trace gets its logs correlated against that trace's ID instead
+// of an unrelated one of our own making.
+//
+// https://www.w3.org/TR/trace-context/#traceparent-header
+const TraceparentHeader = "traceparent"
+
 //
 // CORSMiddleware
 //
@@ -43,7 +69,24 @@ type CanonicalLogLineMiddleware struct {
 	// data being generated.
 	logDataChan chan map[string]any
 
-	logger *logrus.Logger
+	// accessLogger is the structured-logging backend the canonical log line
+	// is written through. Defaults to an AccessLogger wrapping logrus if
+	// left nil, matching this middleware's original behavior.
+	accessLogger AccessLogger
+
+	// samplePercent is the percentage (0-100) of 2xx canonical log lines
+	// that are actually emitted; 4xx/5xx lines are always emitted regardless
+	// of this setting. Zero is treated the same as 100 (log every request).
+	samplePercent int
+}
+
+// AccessLogConfig configures CanonicalLogLineMiddleware's logging backend
+// and sampling rate. A nil Logger falls back to an AccessLogger wrapping the
+// server's own logrus logger; a zero SamplePercent is treated as 100 (log
+// every request).
+type AccessLogConfig struct {
+	Logger        AccessLogger
+	SamplePercent int
 }
 
 func (m *CanonicalLogLineMiddleware) Wrapper(next http.Handler) http.Handler {
@@ -51,6 +94,10 @@ func (m *CanonicalLogLineMiddleware) Wrapper(next http.Handler) http.Handler {
 		ctxContainer := ContextContainerFrom(r.Context())
 		requestStart := time.Now()
 
+		bodyCounter := &countingReadCloser{ReadCloser: r.Body}
+		r = r.Clone(r.Context())
+		r.Body = bodyCounter
+
 		next.ServeHTTP(w, r)
 
 		duration := PrettyDuration(time.Since(requestStart))
@@ -68,33 +115,132 @@ func (m *CanonicalLogLineMiddleware) Wrapper(next http.Handler) http.Handler {
 		}
 
 		logData := map[string]any{
-			"content_type": r.Header.Get("Content-Type"),
-			"duration":     duration,
-			"http_method":  r.Method,
-			"http_path":    r.URL.Path,
-			"http_route":   routeStr,
-			"ip":           m.getIP(r).String(),
-			"query_string": stringutil.SampleLong(r.URL.RawQuery),
-			"status":       ctxContainer.StatusCode,
-			"user_agent":   r.UserAgent(),
+			"bytes_in":       bodyCounter.n,
+			"content_length": r.ContentLength,
+			"content_type":   r.Header.Get("Content-Type"),
+			"denied":         ctxContainer.Denied,
+			"duration":       duration,
+			"duration_ms":    time.Duration(duration).Milliseconds(),
+			"http_method":    r.Method,
+			"http_path":      r.URL.Path,
+			"http_route":     routeStr,
+			"ip":             clientIP(r).String(),
+			"key":            mux.Vars(r)["key"],
+			"query_string":   stringutil.SampleLong(r.URL.RawQuery),
+			"referer":        r.Referer(),
+			"request_id":     ctxContainer.RequestID,
+			"status":         ctxContainer.StatusCode,
+			"user_agent":     r.UserAgent(),
+		}
+
+		if ctxContainer.SignatureValid != nil {
+			logData["signature_valid"] = *ctxContainer.SignatureValid
+		}
+
+		if peer := r.Header.Get(ForwardedByHeader); peer != "" {
+			logData["peer"] = peer
+		}
+
+		if ctxContainer.ErrorClass != "" {
+			logData["error_class"] = ctxContainer.ErrorClass
+		}
+
+		if ctxContainer.InFlightWait > 0 {
+			logData["in_flight_wait_ms"] = ctxContainer.InFlightWait.Milliseconds()
+		}
+
+		if ctxContainer.InFlightRejected {
+			logData["in_flight_rejected"] = true
+			logData["in_flight_rejected_count"] = ctxContainer.InFlightRejectedCount
+		}
+
+		if ctxContainer.RateLimited {
+			logData["rate_limited"] = true
+			logData["rate_limit_bucket"] = ctxContainer.RateLimitBucket
 		}
 
 		if inspectableWriter, ok := w.(*InspectableWriter); ok {
+			logData["bytes_out"] = inspectableWriter.BytesWritten
+
 			if inspectableWriter.StatusCode >= 400 {
 				logData["error_message"] = inspectableWriter.Body.String()
 			}
 		}
 
+		if r.TLS != nil {
+			logData["tls_version"] = tls.VersionName(r.TLS.Version)
+			logData["tls_cipher_suite"] = tls.CipherSuiteName(r.TLS.CipherSuite)
+		}
+
 		if m.logDataChan != nil {
 			m.logDataChan <- logData
 		}
 
-		m.logger.WithFields(logrus.Fields(logData)).
-			Infof("canonical_log_line %s %s -> %v (%s)", r.Method, routeOrPath, ctxContainer.StatusCode, duration)
+		if m.shouldLog(ctxContainer.StatusCode) {
+			m.accessLoggerOrDefault().LogAccess(logData,
+				fmt.Sprintf("canonical_log_line %s %s -> %v (%s)", r.Method, routeOrPath, ctxContainer.StatusCode, duration))
+		}
 	})
 }
 
-func (m *CanonicalLogLineMiddleware) getIP(r *http.Request) net.IP {
+// accessLoggerOrDefault returns m.accessLogger, falling back to a logrus
+// logger writing to the standard logger's output if one was never set --
+// which should only happen in tests that construct this middleware directly
+// without going through NewServer.
+func (m *CanonicalLogLineMiddleware) accessLoggerOrDefault() AccessLogger {
+	if m.accessLogger != nil {
+		return m.accessLogger
+	}
+
+	return NewLogrusAccessLogger(logrus.StandardLogger())
+}
+
+// shouldLog decides whether a response with statusCode should actually have
+// its canonical log line emitted: 4xx/5xx responses always are, while 2xx
+// responses are emitted only samplePercent percent of the time, so that a
+// high-traffic realm can down-sample its happy path without losing
+// visibility into errors.
+func (m *CanonicalLogLineMiddleware) shouldLog(statusCode int) bool {
+	if statusCode < 200 || statusCode >= 300 {
+		return true
+	}
+
+	samplePercent := m.samplePercent
+	if samplePercent <= 0 {
+		samplePercent = 100
+	}
+
+	if samplePercent >= 100 {
+		return true
+	}
+
+	return randutil.Intn(100) < int64(samplePercent)
+}
+
+// countingReadCloser wraps an http.Request's Body to tally how many bytes a
+// handler actually reads off it, since neither Content-Length (absent for
+// chunked requests) nor a fixed read afterward can say that reliably.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, xerrors.Errorf("error reading request body: %w", err)
+	}
+
+	return n, err
+}
+
+// clientIP extracts the originating client's IP from a request, preferring
+// X-Forwarded-For (set by a fronting proxy/load balancer) over the
+// connection's own remote address. Shared by CanonicalLogLineMiddleware (for
+// logging) and MaxInFlightMiddleware (for per-client fairness bucketing).
+func clientIP(r *http.Request) net.IP {
 	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
 		// `X-Forwarded-For` may contain a number of IP addresses, with the
 		// original client in the leftmost position, and each intermediary proxy
@@ -141,25 +287,115 @@ type contextContainerContextKey struct{}
 // various values.
 type ContextContainer struct {
 	StatusCode int
+
+	// RequestID is a per-request ID minted by ContextContainerMiddleware, echoed
+	// back to the client via RequestIDHeader, and included in every log line
+	// emitted while handling the request so they can all be correlated.
+	RequestID string
+
+	// Denied is set by a handler when it rejects a key because it's on the deny
+	// list, so CanonicalLogLineMiddleware can surface it without having to
+	// re-derive it from the response body.
+	Denied bool
+
+	// SignatureValid is set by handlePutKey once it's checked a submitted
+	// board's signature, nil for requests (like GET) that never get that far.
+	SignatureValid *bool
+
+	// ErrorClass is set by wrapEndpoint when a handler returns a ServerError, so
+	// the canonical log line can categorize the failure beyond its raw status
+	// code.
+	ErrorClass string
+
+	// InFlightWait is set by MaxInFlightMiddleware to how long it took to
+	// acquire (or fail to acquire) an in-flight slot, so the canonical log
+	// line can show any latency concurrency limiting added to the request.
+	InFlightWait time.Duration
+
+	// InFlightRejected and InFlightRejectedCount are set by
+	// MaxInFlightMiddleware when it rejects a request for exceeding its
+	// read/write concurrency limit; InFlightRejectedCount is the cumulative
+	// number of requests it's rejected so far, including this one.
+	InFlightRejected      bool
+	InFlightRejectedCount int64
+
+	// RateLimited and RateLimitBucket are set by RateLimitMiddleware when it
+	// rejects a request for exceeding its per-IP or per-key rate limit, so
+	// the canonical log line can show which bucket type triggered it.
+	RateLimited     bool
+	RateLimitBucket string
 }
 
 func ContextContainerFrom(ctx context.Context) *ContextContainer {
 	return ctx.Value(contextContainerContextKey{}).(*ContextContainer)
 }
 
+// contextContainerFromOptional is like ContextContainerFrom, but returns nil
+// instead of panicking if no ContextContainer has been embedded yet. Used by
+// code that a test may invoke directly, outside of ContextContainerMiddleware.
+func contextContainerFromOptional(ctx context.Context) *ContextContainer {
+	ctxContainer, _ := ctx.Value(contextContainerContextKey{}).(*ContextContainer)
+	return ctxContainer
+}
+
 // ContextContainerMiddleware embeds a context early in the request stack, which
 // can be used to set various values along a request's lifecycle that can then
-// be introspected by entities including other middleware.
+// be introspected by entities including other middleware. Also mints a unique
+// ID for the request and echoes it back via RequestIDHeader.
 type ContextContainerMiddleware struct{}
 
 func (m *ContextContainerMiddleware) Wrapper(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := requestIDFromHeaders(r)
+		if requestID == "" {
+			id, err := uuid.NewV7()
+			if err != nil {
+				// NewV7 only fails if the system's random source is broken, which
+				// we'd rather not take down the whole request over -- fall back to
+				// a random (but still unique) v4 ID instead.
+				id = uuid.New()
+			}
+			requestID = id.String()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+
 		ctx := r.Context()
-		ctx = context.WithValue(ctx, contextContainerContextKey{}, &ContextContainer{})
+		ctx = context.WithValue(ctx, contextContainerContextKey{}, &ContextContainer{RequestID: requestID})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// requestIDFromHeaders returns the request ID ContextContainerMiddleware
+// should adopt for r, preferring an ID the caller already supplied -- first
+// RequestIDHeader, then the trace-id segment of a W3C TraceparentHeader --
+// over minting a brand new one, so that a request forwarded through other
+// infrastructure keeps the same ID across every hop's logs. Returns "" if
+// neither header is present or parses.
+func requestIDFromHeaders(r *http.Request) string {
+	if requestID := r.Header.Get(RequestIDHeader); requestID != "" {
+		return requestID
+	}
+
+	return traceIDFromTraceparent(r.Header.Get(TraceparentHeader))
+}
+
+// traceIDFromTraceparent extracts the trace-id field from a W3C Trace
+// Context header of the form "version-trace_id-parent_id-trace_flags",
+// returning "" if header isn't a validly formed traceparent.
+func traceIDFromTraceparent(header string) string {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+
+	return parts[1]
+}
+
 //
 // InspectableWriterMiddleware
 //
@@ -174,8 +410,9 @@ func (m *ContextContainerMiddleware) Wrapper(next http.Handler) http.Handler {
 // response information.
 type InspectableWriter struct {
 	http.ResponseWriter
-	StatusCode int
-	Body       bytes.Buffer
+	StatusCode   int
+	Body         bytes.Buffer
+	BytesWritten int
 }
 
 func (w *InspectableWriter) WriteHeader(status int) {
@@ -193,6 +430,7 @@ func (w *InspectableWriter) Write(b []byte) (int, error) {
 	_, _ = w.Body.Write(b)
 
 	n, err := w.ResponseWriter.Write(b)
+	w.BytesWritten += n
 	if err != nil {
 		return n, xerrors.Errorf("error writing response body: %w", err)
 	}
@@ -201,8 +439,11 @@ func (w *InspectableWriter) Write(b []byte) (int, error) {
 }
 
 // InspectableWriterMiddleware injects an instance of InspectableWriter into
-// middlewares nested beneath it.
-type InspectableWriterMiddleware struct{}
+// middlewares nested beneath it, and tallies the number of requests
+// currently in flight so that Server.Shutdown can wait for them to drain.
+type InspectableWriterMiddleware struct {
+	activeRequests int64 // accessed atomically
+}
 
 // NewInspectableWriterMiddleware initializes a new middleware instance.
 func NewInspectableWriterMiddleware() *InspectableWriterMiddleware {
@@ -213,11 +454,20 @@ func NewInspectableWriterMiddleware() *InspectableWriterMiddleware {
 // stack.
 func (m *InspectableWriterMiddleware) Wrapper(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&m.activeRequests, 1)
+		defer atomic.AddInt64(&m.activeRequests, -1)
+
 		inspectableWriter := &InspectableWriter{ResponseWriter: w}
 		next.ServeHTTP(inspectableWriter, r)
 	})
 }
 
+// ActiveRequests returns the number of requests currently in flight beneath
+// this middleware.
+func (m *InspectableWriterMiddleware) ActiveRequests() int64 {
+	return atomic.LoadInt64(&m.activeRequests)
+}
+
 //
 // TimeoutMiddleware
 //
@@ -276,3 +526,418 @@ func (m *TimeoutMiddleware) Wrapper(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+//
+// MaxInFlightMiddleware
+//
+
+// Defaults used by MaxInFlightMiddleware when NewMaxInFlightMiddleware is
+// given a zero value for the corresponding parameter.
+const (
+	DefaultMaxReadInFlight  = 200
+	DefaultMaxWriteInFlight = 50
+	DefaultInFlightBuckets  = 16
+)
+
+// RetryAfterSeconds is sent as the Retry-After header value along with a 503
+// rejection from MaxInFlightMiddleware, telling the client roughly how long
+// to wait before retrying.
+const RetryAfterSeconds = "1"
+
+// inFlightLimiter is a concurrency limiter that enforces a global cap
+// further split into numBuckets per-client buckets, each given its own
+// fractional share of the global cap. A client hashed into a bucket that's
+// exhausted its share is rejected even if the global cap still has room,
+// which is what prevents a single high-volume client from starving
+// everyone else; the global cap is what bounds total concurrency across all
+// buckets combined.
+//
+// Acquisition never blocks: a request that can't immediately claim a slot in
+// both its bucket and the global cap is rejected outright, mirroring the
+// non-queueing behavior of Kubernetes' generic API server in-flight limiter.
+type inFlightLimiter struct {
+	global  chan struct{}
+	buckets []chan struct{}
+}
+
+func newInFlightLimiter(maxInFlight, numBuckets int) *inFlightLimiter {
+	if numBuckets < 1 {
+		numBuckets = 1
+	}
+
+	perBucket := maxInFlight / numBuckets
+	if perBucket < 1 {
+		perBucket = 1
+	}
+
+	buckets := make([]chan struct{}, numBuckets)
+	for i := range buckets {
+		buckets[i] = make(chan struct{}, perBucket)
+	}
+
+	return &inFlightLimiter{
+		global:  make(chan struct{}, maxInFlight),
+		buckets: buckets,
+	}
+}
+
+// tryAcquire attempts to reserve a slot in both the global cap and bucket's
+// share of it, returning false without blocking if either is already full.
+func (l *inFlightLimiter) tryAcquire(bucket int) bool {
+	select {
+	case l.global <- struct{}{}:
+	default:
+		return false
+	}
+
+	select {
+	case l.buckets[bucket%len(l.buckets)] <- struct{}{}:
+		return true
+	default:
+		<-l.global
+		return false
+	}
+}
+
+func (l *inFlightLimiter) release(bucket int) {
+	<-l.buckets[bucket%len(l.buckets)]
+	<-l.global
+}
+
+// MaxInFlightConfig configures MaxInFlightMiddleware. A zero value for
+// MaxReadInFlight, MaxWriteInFlight, or Buckets falls back to its respective
+// Default constant; an empty LongRunningRoutePattern excludes no routes from
+// the limiter.
+type MaxInFlightConfig struct {
+	MaxReadInFlight         int
+	MaxWriteInFlight        int
+	Buckets                 int
+	LongRunningRoutePattern string
+}
+
+// MaxInFlightMiddleware caps the number of concurrently processing requests,
+// rejecting with 503 Service Unavailable (and a Retry-After header) once
+// saturated rather than queueing -- modeled on Kubernetes' generic API
+// server (see MaxRequestsInFlight / LongRunningRequestRE). Reads (GET) and
+// writes (PUT) are tracked through independent limiters so a burst of writes
+// can't starve reads, and routes matching longRunningRequestRE (intended for
+// future streaming endpoints) are excluded from accounting entirely.
+//
+// Within each limiter, fairness across clients is approximated by hashing
+// the client's IP into a small number of buckets, each carrying its own
+// share of the limiter's total slots, so that one abusive publisher
+// hammering from a single IP can exhaust only its own bucket rather than the
+// whole limit.
+type MaxInFlightMiddleware struct {
+	longRunningRequestRE *regexp.Regexp
+	readLimiter          *inFlightLimiter
+	writeLimiter         *inFlightLimiter
+
+	rejectedCount int64 // accessed atomically
+}
+
+// NewMaxInFlightMiddleware initializes a new middleware instance. See
+// MaxInFlightConfig for the meaning of its fields and their defaults.
+func NewMaxInFlightMiddleware(config MaxInFlightConfig) *MaxInFlightMiddleware {
+	maxReadInFlight := config.MaxReadInFlight
+	if maxReadInFlight < 1 {
+		maxReadInFlight = DefaultMaxReadInFlight
+	}
+
+	maxWriteInFlight := config.MaxWriteInFlight
+	if maxWriteInFlight < 1 {
+		maxWriteInFlight = DefaultMaxWriteInFlight
+	}
+
+	buckets := config.Buckets
+	if buckets < 1 {
+		buckets = DefaultInFlightBuckets
+	}
+
+	var longRunningRequestRE *regexp.Regexp
+	if config.LongRunningRoutePattern != "" {
+		longRunningRequestRE = regexp.MustCompile(config.LongRunningRoutePattern)
+	}
+
+	return &MaxInFlightMiddleware{
+		longRunningRequestRE: longRunningRequestRE,
+		readLimiter:          newInFlightLimiter(maxReadInFlight, buckets),
+		writeLimiter:         newInFlightLimiter(maxWriteInFlight, buckets),
+	}
+}
+
+// Wrapper produces an http.HandlerFunc suitable to be placed into a middleware
+// stack.
+func (m *MaxInFlightMiddleware) Wrapper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.longRunningRequestRE != nil && m.longRunningRequestRE.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limiter := m.readLimiter
+		if r.Method == http.MethodPut {
+			limiter = m.writeLimiter
+		}
+
+		bucket := clientBucket(clientIP(r), len(limiter.buckets))
+
+		start := time.Now()
+		acquired := limiter.tryAcquire(bucket)
+		wait := time.Since(start)
+
+		ctxContainer := contextContainerFromOptional(r.Context())
+		if ctxContainer != nil {
+			ctxContainer.InFlightWait = wait
+		}
+
+		if !acquired {
+			rejectedCount := atomic.AddInt64(&m.rejectedCount, 1)
+
+			if ctxContainer != nil {
+				ctxContainer.InFlightRejected = true
+				ctxContainer.InFlightRejectedCount = rejectedCount
+			}
+
+			w.Header().Set("Retry-After", RetryAfterSeconds)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Too many requests are currently in flight. Please try again shortly."))
+
+			return
+		}
+		defer limiter.release(bucket)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientBucket hashes ip into one of numBuckets buckets. A nil IP (one that
+// couldn't be parsed) always hashes to bucket 0, which is an acceptable
+// fallback since that's already true of any other client sharing the same
+// unparseable address.
+func clientBucket(ip net.IP, numBuckets int) int {
+	if numBuckets < 1 {
+		numBuckets = 1
+	}
+
+	if ip == nil {
+		return 0
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(ip)
+
+	return int(h.Sum32() % uint32(numBuckets))
+}
+
+//
+// RateLimitMiddleware
+//
+
+// Defaults used by RateLimitMiddleware when NewRateLimitMiddleware's
+// RateLimitConfig leaves a field at its zero value.
+const (
+	DefaultIPRateLimit  = 5.0 // requests/sec
+	DefaultIPBurst      = 20
+	DefaultKeyRateLimit = 1.0 // requests/sec
+	DefaultKeyBurst     = 5
+
+	// DefaultRateLimitShards is the number of independent LRUs each bucket
+	// type (ip, key) is split across, both to spread lock contention and to
+	// bound how many limiters any single LRU has to evict from at once.
+	DefaultRateLimitShards = 16
+
+	// DefaultRateLimitEntriesPerShard bounds how many distinct limiters a
+	// single shard holds onto before evicting the least recently used, so
+	// a flood of distinct IPs or keys can't grow memory without bound.
+	DefaultRateLimitEntriesPerShard = 4096
+)
+
+var rateLimitDecisionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rate_limit_decisions_total",
+		Help: "Total number of RateLimitMiddleware decisions, labeled by bucket (ip or key) and result (accept or reject).",
+	},
+	[]string{"bucket", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitDecisionsTotal)
+}
+
+// RateLimitConfig configures RateLimitMiddleware. A zero value for any rate
+// or burst falls back to its respective Default constant.
+type RateLimitConfig struct {
+	IPRatePerSecond  float64
+	IPBurst          int
+	KeyRatePerSecond float64
+	KeyBurst         int
+	Shards           int
+}
+
+// RateLimitMiddleware protects against publish-flooding on individual keys
+// by capping request rate two independent ways: per source IP (via
+// clientIP, honoring X-Forwarded-For) and per published key (the pubkey
+// extracted from the request path). Each is its own token bucket
+// (golang.org/x/time/rate) held in a small sharded LRU so the limiter set
+// stays bounded in memory no matter how many distinct IPs or keys are seen
+// -- the same approach vulcand/oxy's ratelimit takes. A request that
+// exhausts either bucket is rejected with 429 and a Retry-After computed
+// from how long the bucket needs to refill.
+type RateLimitMiddleware struct {
+	ipLimiters  *shardedLimiterSet
+	keyLimiters *shardedLimiterSet
+}
+
+// NewRateLimitMiddleware initializes a new middleware instance. See
+// RateLimitConfig for the meaning of its fields and their defaults.
+func NewRateLimitMiddleware(config RateLimitConfig) *RateLimitMiddleware {
+	ipRate := config.IPRatePerSecond
+	if ipRate <= 0 {
+		ipRate = DefaultIPRateLimit
+	}
+
+	ipBurst := config.IPBurst
+	if ipBurst < 1 {
+		ipBurst = DefaultIPBurst
+	}
+
+	keyRate := config.KeyRatePerSecond
+	if keyRate <= 0 {
+		keyRate = DefaultKeyRateLimit
+	}
+
+	keyBurst := config.KeyBurst
+	if keyBurst < 1 {
+		keyBurst = DefaultKeyBurst
+	}
+
+	shards := config.Shards
+	if shards < 1 {
+		shards = DefaultRateLimitShards
+	}
+
+	return &RateLimitMiddleware{
+		ipLimiters:  newShardedLimiterSet(shards, rate.Limit(ipRate), ipBurst),
+		keyLimiters: newShardedLimiterSet(shards, rate.Limit(keyRate), keyBurst),
+	}
+}
+
+// Wrapper produces an http.HandlerFunc suitable to be placed into a middleware
+// stack.
+func (m *RateLimitMiddleware) Wrapper(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxContainer := contextContainerFromOptional(r.Context())
+
+		if delay, ok := tryAcquire(m.ipLimiters.limiterFor(clientIP(r).String())); !ok {
+			rateLimitDecisionsTotal.WithLabelValues("ip", "reject").Inc()
+			m.reject(w, ctxContainer, "ip", delay)
+			return
+		}
+		rateLimitDecisionsTotal.WithLabelValues("ip", "accept").Inc()
+
+		if key := mux.Vars(r)["key"]; key != "" {
+			if delay, ok := tryAcquire(m.keyLimiters.limiterFor(key)); !ok {
+				rateLimitDecisionsTotal.WithLabelValues("key", "reject").Inc()
+				m.reject(w, ctxContainer, "key", delay)
+				return
+			}
+			rateLimitDecisionsTotal.WithLabelValues("key", "accept").Inc()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reject responds 429 with a Retry-After derived from delay, and records the
+// decision on ctxContainer (if present) so CanonicalLogLineMiddleware can
+// surface which bucket type triggered it.
+func (m *RateLimitMiddleware) reject(w http.ResponseWriter, ctxContainer *ContextContainer, bucket string, delay time.Duration) {
+	if ctxContainer != nil {
+		ctxContainer.RateLimited = true
+		ctxContainer.RateLimitBucket = bucket
+	}
+
+	retryAfter := int(math.Ceil(delay.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(fmt.Sprintf("Too many requests for this %s. Please try again later.", bucket)))
+}
+
+// tryAcquire takes a token from limiter without blocking, returning true if
+// one was available. If not, it returns false along with how long the
+// caller would need to wait for the bucket to refill, suitable for a
+// Retry-After header; no token is consumed in that case.
+func tryAcquire(limiter *rate.Limiter) (time.Duration, bool) {
+	reservation := limiter.Reserve()
+	if !reservation.OK() {
+		return 0, false
+	}
+
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return delay, false
+	}
+
+	return 0, true
+}
+
+// shardedLimiterSet is a small sharded LRU of token-bucket limiters, keyed
+// by an arbitrary string (an IP or a pubkey). Sharding spreads lock
+// contention across several independent LRUs and bounds total memory, since
+// each shard independently evicts its least recently used limiter once it's
+// full.
+type shardedLimiterSet struct {
+	shards []*lru.Cache[string, *rate.Limiter]
+	limit  rate.Limit
+	burst  int
+}
+
+func newShardedLimiterSet(numShards int, limit rate.Limit, burst int) *shardedLimiterSet {
+	shards := make([]*lru.Cache[string, *rate.Limiter], numShards)
+	for i := range shards {
+		cache, err := lru.New[string, *rate.Limiter](DefaultRateLimitEntriesPerShard)
+		if err != nil {
+			// The only error New returns is for a non-positive size, which
+			// DefaultRateLimitEntriesPerShard never is.
+			panic(err)
+		}
+		shards[i] = cache
+	}
+
+	return &shardedLimiterSet{shards: shards, limit: limit, burst: burst}
+}
+
+// limiterFor returns the token bucket limiter for key, creating one on
+// first use. A benign race between concurrent first uses of the same key
+// may create and keep either of two fresh limiters rather than one -- an
+// acceptable approximation for a fairness mechanism rather than a security
+// boundary.
+func (s *shardedLimiterSet) limiterFor(key string) *rate.Limiter {
+	shard := s.shards[shardIndex(key, len(s.shards))]
+
+	if limiter, ok := shard.Get(key); ok {
+		return limiter
+	}
+
+	limiter := rate.NewLimiter(s.limit, s.burst)
+	shard.Add(key, limiter)
+
+	return limiter
+}
+
+// shardIndex hashes key into one of numShards shards.
+func shardIndex(key string, numShards int) int {
+	if numShards < 1 {
+		numShards = 1
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(numShards))
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
 func TestCORSMiddleware(t *testing.T) {
@@ -35,7 +38,7 @@ func TestCanonicalLogLineMiddleware(t *testing.T) {
 
 	router := mux.NewRouter()
 	router.Use((&ContextContainerMiddleware{}).Wrapper)
-	router.Use((&CanonicalLogLineMiddleware{logDataChan: logDataChan, logger: logrus.New()}).Wrapper)
+	router.Use((&CanonicalLogLineMiddleware{logDataChan: logDataChan, accessLogger: NewLogrusAccessLogger(logrus.New())}).Wrapper)
 	router.HandleFunc("/hello/{name}", func(w http.ResponseWriter, r *http.Request) {
 		ctxContainer := ContextContainerFrom(r.Context())
 		ctxContainer.StatusCode = http.StatusCreated
@@ -49,16 +52,26 @@ func TestCanonicalLogLineMiddleware(t *testing.T) {
 	router.ServeHTTP(recorder, r)
 
 	logData := <-logDataChan
+	require.NotEmpty(t, logData["request_id"])
+	require.Equal(t, logData["request_id"], recorder.Header().Get(RequestIDHeader))
+
 	require.Equal(t, map[string]any{
-		"content_type": "text/html",
-		"duration":     logData["duration"], // hard to assert on
-		"http_method":  http.MethodPost,
-		"http_path":    "/hello/dave",
-		"http_route":   "/hello/{name}",
-		"ip":           "<nil>",
-		"query_string": "",
-		"status":       http.StatusCreated,
-		"user_agent":   "test-agent",
+		"bytes_in":       int64(0),
+		"content_length": int64(0),
+		"content_type":   "text/html",
+		"denied":         false,
+		"duration":       logData["duration"],    // hard to assert on
+		"duration_ms":    logData["duration_ms"], // hard to assert on
+		"http_method":    http.MethodPost,
+		"http_path":      "/hello/dave",
+		"http_route":     "/hello/{name}",
+		"ip":             "<nil>",
+		"key":            "",
+		"query_string":   "",
+		"referer":        "",
+		"request_id":     logData["request_id"],
+		"status":         http.StatusCreated,
+		"user_agent":     "test-agent",
 	}, logData)
 }
 
@@ -78,6 +91,43 @@ func TestContextContainerMiddleware(t *testing.T) {
 	router.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodGet, "/hello", nil, nil))
 
 	require.Equal(t, http.StatusCreated, ctxContainer.StatusCode)
+	require.NotEmpty(t, ctxContainer.RequestID)
+	require.Equal(t, ctxContainer.RequestID, recorder.Header().Get(RequestIDHeader))
+}
+
+func TestRequestIDFromHeaders(t *testing.T) {
+	ctx := context.Background()
+
+	r := mustNewRequest(ctx, http.MethodGet, "/hello", nil, nil)
+	require.Empty(t, requestIDFromHeaders(r))
+
+	r.Header.Set(RequestIDHeader, "some-request-id")
+	require.Equal(t, "some-request-id", requestIDFromHeaders(r))
+
+	r.Header.Del(RequestIDHeader)
+	r.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", requestIDFromHeaders(r))
+
+	r.Header.Set(TraceparentHeader, "not-a-valid-traceparent")
+	require.Empty(t, requestIDFromHeaders(r))
+}
+
+func TestCanonicalLogLineMiddlewareShouldLog(t *testing.T) {
+	m := &CanonicalLogLineMiddleware{}
+	require.True(t, m.shouldLog(http.StatusOK)) // samplePercent zero defaults to logging everything
+
+	m = &CanonicalLogLineMiddleware{samplePercent: 0}
+	require.True(t, m.shouldLog(http.StatusBadRequest))
+	require.True(t, m.shouldLog(http.StatusInternalServerError))
+
+	m = &CanonicalLogLineMiddleware{samplePercent: 100}
+	for range 10 {
+		require.True(t, m.shouldLog(http.StatusOK))
+	}
+
+	m = &CanonicalLogLineMiddleware{samplePercent: 1}
+	// Errors are always logged regardless of sampling.
+	require.True(t, m.shouldLog(http.StatusNotFound))
 }
 
 func TestInspectableWriterMiddlewareWrapper(t *testing.T) {
@@ -203,3 +253,226 @@ func TestTimeoutMiddlewareWrapper(t *testing.T) {
 			recorder.Body.String())
 	}))
 }
+
+func TestMaxInFlightMiddleware(t *testing.T) {
+	newHandler := func(config MaxInFlightConfig) (http.Handler, *ContextContainer) {
+		ctxContainer := &ContextContainer{}
+
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		handler = NewMaxInFlightMiddleware(config).Wrapper(handler)
+
+		return handler, ctxContainer
+	}
+
+	withContextContainer := func(ctx context.Context, ctxContainer *ContextContainer) context.Context {
+		return context.WithValue(ctx, contextContainerContextKey{}, ctxContainer)
+	}
+
+	t.Run("AllowsRequestsUnderLimit", func(t *testing.T) {
+		handler, ctxContainer := newHandler(MaxInFlightConfig{MaxReadInFlight: 2, Buckets: 1})
+
+		ctx := withContextContainer(context.Background(), ctxContainer)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodGet, "/1", nil, nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.False(t, ctxContainer.InFlightRejected)
+	})
+
+	t.Run("RejectsWhenSaturated", func(t *testing.T) {
+		middleware := NewMaxInFlightMiddleware(MaxInFlightConfig{MaxReadInFlight: 1, Buckets: 1})
+
+		blockCh := make(chan struct{})
+		release := make(chan struct{})
+		handler := middleware.Wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(blockCh)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), mustNewRequest(context.Background(), http.MethodGet, "/1", nil, nil))
+		}()
+		<-blockCh
+
+		ctxContainer := &ContextContainer{}
+		ctx := withContextContainer(context.Background(), ctxContainer)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodGet, "/2", nil, nil))
+
+		require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		require.Equal(t, RetryAfterSeconds, recorder.Header().Get("Retry-After"))
+		require.True(t, ctxContainer.InFlightRejected)
+		require.Equal(t, int64(1), ctxContainer.InFlightRejectedCount)
+
+		close(release)
+	})
+
+	t.Run("TracksReadsAndWritesIndependently", func(t *testing.T) {
+		middleware := NewMaxInFlightMiddleware(MaxInFlightConfig{MaxReadInFlight: 1, MaxWriteInFlight: 1, Buckets: 1})
+
+		blockCh := make(chan struct{})
+		release := make(chan struct{})
+		handler := middleware.Wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				close(blockCh)
+				<-release
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), mustNewRequest(context.Background(), http.MethodPut, "/1", nil, nil))
+		}()
+		<-blockCh
+
+		// A concurrent write is saturated, but reads use their own limiter and
+		// should go through unaffected.
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(context.Background(), http.MethodGet, "/1", nil, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		close(release)
+	})
+
+	t.Run("ExcludesLongRunningRoutes", func(t *testing.T) {
+		middleware := NewMaxInFlightMiddleware(MaxInFlightConfig{
+			MaxReadInFlight:         1,
+			Buckets:                 1,
+			LongRunningRoutePattern: `^/stream`,
+		})
+
+		blockCh := make(chan struct{})
+		release := make(chan struct{})
+		var blocked atomic.Bool
+		handler := middleware.Wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if blocked.CompareAndSwap(false, true) {
+				close(blockCh)
+				<-release
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), mustNewRequest(context.Background(), http.MethodGet, "/stream", nil, nil))
+		}()
+		<-blockCh
+
+		// A second request against the same (excluded) route should never be
+		// counted toward the limit, even while the first is still in flight.
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(context.Background(), http.MethodGet, "/stream", nil, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		close(release)
+	})
+}
+
+func TestClientBucket(t *testing.T) {
+	require.Equal(t, 0, clientBucket(nil, 4))
+	require.Equal(t, 0, clientBucket(net.ParseIP("127.0.0.1"), 0))
+
+	bucket := clientBucket(net.ParseIP("127.0.0.1"), 4)
+	require.Equal(t, bucket, clientBucket(net.ParseIP("127.0.0.1"), 4))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	newHandler := func(config RateLimitConfig) (http.Handler, *ContextContainer) {
+		ctxContainer := &ContextContainer{}
+
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		handler = NewRateLimitMiddleware(config).Wrapper(handler)
+
+		return handler, ctxContainer
+	}
+
+	withContextContainer := func(ctx context.Context, ctxContainer *ContextContainer) context.Context {
+		return context.WithValue(ctx, contextContainerContextKey{}, ctxContainer)
+	}
+
+	t.Run("AllowsRequestsUnderLimit", func(t *testing.T) {
+		handler, ctxContainer := newHandler(RateLimitConfig{IPRatePerSecond: 10, IPBurst: 5})
+
+		ctx := withContextContainer(context.Background(), ctxContainer)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodGet, "/1", nil, nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.False(t, ctxContainer.RateLimited)
+	})
+
+	t.Run("RejectsIPOverBurst", func(t *testing.T) {
+		handler, _ := newHandler(RateLimitConfig{IPRatePerSecond: 1, IPBurst: 1})
+
+		for i := 0; i < 1; i++ {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, mustNewRequest(context.Background(), http.MethodGet, "/1", nil, nil))
+			require.Equal(t, http.StatusOK, recorder.Code)
+		}
+
+		ctxContainer := &ContextContainer{}
+		ctx := withContextContainer(context.Background(), ctxContainer)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodGet, "/1", nil, nil))
+
+		require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		require.NotEmpty(t, recorder.Header().Get("Retry-After"))
+		require.True(t, ctxContainer.RateLimited)
+		require.Equal(t, "ip", ctxContainer.RateLimitBucket)
+	})
+
+	t.Run("RejectsKeyOverBurst", func(t *testing.T) {
+		handler, _ := newHandler(RateLimitConfig{
+			IPRatePerSecond: 1000, IPBurst: 1000,
+			KeyRatePerSecond: 1, KeyBurst: 1,
+		})
+
+		muxVars := map[string]string{"key": samplePublicKey}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(context.Background(), http.MethodPut, "/"+samplePublicKey, muxVars, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		ctxContainer := &ContextContainer{}
+		ctx := withContextContainer(context.Background(), ctxContainer)
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodPut, "/"+samplePublicKey, muxVars, nil))
+
+		require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		require.True(t, ctxContainer.RateLimited)
+		require.Equal(t, "key", ctxContainer.RateLimitBucket)
+	})
+
+	t.Run("DistinctKeysHaveIndependentBuckets", func(t *testing.T) {
+		handler, _ := newHandler(RateLimitConfig{
+			IPRatePerSecond: 1000, IPBurst: 1000,
+			KeyRatePerSecond: 1, KeyBurst: 1,
+		})
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(context.Background(), http.MethodPut, "/key-a", map[string]string{"key": "key-a"}, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, mustNewRequest(context.Background(), http.MethodPut, "/key-b", map[string]string{"key": "key-b"}, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+}
+
+func TestShardedLimiterSet(t *testing.T) {
+	set := newShardedLimiterSet(4, rate.Limit(5), 5)
+
+	limiter := set.limiterFor("some-key")
+	require.Same(t, limiter, set.limiterFor("some-key"))
+	require.NotSame(t, limiter, set.limiterFor("some-other-key"))
+}
+
+func TestShardIndex(t *testing.T) {
+	require.Equal(t, 0, shardIndex("a", 0))
+
+	index := shardIndex("some-key", 4)
+	require.Equal(t, index, shardIndex("some-key", 4))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// renderContentSecurityPolicy is the Content-Security-Policy header sent
+// alongside the rendered HTML wrapper page. It forbids scripts and any
+// remote fetch entirely; the only thing a board is allowed to do is style
+// itself inline from within the sandboxed iframe.
+const renderContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; script-src 'none'"
+
+// renderBoardTemplate wraps a board's raw content in a sandboxed iframe so
+// that it can be browsed safely without a separate client. The iframe's
+// `srcdoc` is populated with `.Content`, which must be a plain (not
+// template.HTML) string so that html/template's contextual autoescaping
+// HTML-escapes it for inclusion in a quoted attribute rather than parsing
+// it as trusted markup -- this is what keeps a malicious board from
+// breaking out of the attribute and injecting something into the wrapper
+// page itself.
+//
+// The aspect-ratio on .board follows the spec's suggestion that boards be
+// displayed inside a 1:√2 (or √2:1) rectangle, evoking a sheet of paper.
+var renderBoardTemplate = template.Must(template.New("render").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Key}}</title>
+<style>
+  body { margin: 0; padding: 2rem; background: #222; display: flex; justify-content: center; }
+  .board { width: min(100%, 540px); aspect-ratio: 1 / 1.41421356; border: 0; background: #fff; }
+</style>
+</head>
+<body>
+<iframe class="board" sandbox="allow-top-navigation-by-user-activation" srcdoc="{{.Content}}"></iframe>
+</body>
+</html>
+`))
+
+// renderBoardTemplateData is the data passed to renderBoardTemplate.
+type renderBoardTemplateData struct {
+	Key string
+
+	// Content is the board's raw content, prefixed with a `<base>` tag so
+	// that links clicked inside the sandboxed iframe navigate the real top
+	// level page instead of the iframe itself, which the sandbox wouldn't
+	// otherwise allow without a user gesture.
+	Content string
+}
+
+// renderBoardHTML builds the sandboxed HTML wrapper page for a board's raw
+// content, as served by GET /{key}/render.
+func renderBoardHTML(key string, content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := renderBoardTemplate.Execute(&buf, renderBoardTemplateData{
+		Key:     key,
+		Content: `<base target="_top">` + string(content),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error executing render template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// handleRenderKey serves an HTML page embedding a board's content inside a
+// sandboxed iframe, so that boards can be browsed in an ordinary browser
+// without shipping a separate client. It defers to handleGetKey for all of
+// the usual key validation, deny list, and federation-miss handling, so
+// GET /{key} itself stays byte-exact with the spec: this is purely an
+// additional viewing surface layered on top.
+func (s *Server) handleRenderKey(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	getResp, err := s.handleGetKey(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := renderBoardHTML(mux.Vars(r)["key"], getResp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("error rendering board: %w", err)
+	}
+
+	return NewServerResponse(http.StatusOK, html, http.Header{
+		"Content-Security-Policy": []string{renderContentSecurityPolicy},
+		"Content-Type":            []string{"text/html;charset=utf-8"},
+	}), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brandur/neospring/internal/nskey"
+	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+)
+
+func TestRenderBoardHTML(t *testing.T) {
+	html, err := renderBoardHTML(samplePublicKey, []byte(`<p>hi "there" & friends</p>`))
+	require.NoError(t, err)
+
+	// The content should appear HTML-escaped within the srcdoc attribute
+	// (so the attribute isn't broken out of), but never have its tags
+	// stripped -- it needs to still be real markup once the browser
+	// unescapes the attribute and parses it as the iframe's document.
+	require.Contains(t, string(html), `srcdoc="&lt;base target=&#34;_top&#34;&gt;&lt;p&gt;hi &#34;there&#34; &amp; friends&lt;/p&gt;"`)
+	require.Contains(t, string(html), `<title>`+samplePublicKey+`</title>`)
+	require.Contains(t, string(html), `sandbox="allow-top-navigation-by-user-activation"`)
+}
+
+func TestServerHandleRenderKey(t *testing.T) {
+	ctx := context.Background()
+
+	newServer := func() (*Server, *nsmemorystore.MemoryStore) {
+		store := nsmemorystore.NewMemoryStore(logger, 0)
+		store.SetTimeNow(stableTimeFunc)
+		denyList := NewMemoryDenyList()
+		server := NewServer(logger, store, denyList, nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+		server.timeNow = stableTimeFunc
+		return server, store
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		server, store := newServer()
+
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		content := []byte("<p>some board content</p>")
+		err := store.Put(ctx, keyPair.PublicKey, &nsstore.Board{
+			Content:   content,
+			Signature: keyPair.SignHex(content),
+			Timestamp: stableTime,
+		})
+		require.NoError(t, err)
+
+		r := mustNewRequest(ctx, http.MethodGet, "/"+keyPair.PublicKey+"/render", map[string]string{"key": keyPair.PublicKey}, nil)
+		resp, err := server.handleRenderKey(ctx, r)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, renderContentSecurityPolicy, resp.Header.Get("Content-Security-Policy"))
+		require.Contains(t, string(resp.Body), "&lt;p&gt;some board content&lt;/p&gt;")
+	})
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		server, _ := newServer()
+
+		r := mustNewRequest(ctx, http.MethodGet, "/"+samplePublicKey+"/render", map[string]string{"key": samplePublicKey}, nil)
+		_, err := server.handleRenderKey(ctx, r)
+		requireServerError(t, NewServerError(http.StatusNotFound, (&BoardNotFoundError{samplePublicKey}).Error()), err)
+	})
+}
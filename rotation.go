@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/neospring/internal/nskey"
+)
+
+// RotationGraceWindow is how long past a key's own expiry the server will
+// still honor a successor attestation pointing away from it. Past this, the
+// old key is simply gone, the same as if no successor had ever been
+// published for it.
+const RotationGraceWindow = 30 * 24 * time.Hour
+
+// successorAttestationRE matches the HTML comment a board embeds to publish
+// its successor key, e.g.:
+//
+//	<!-- spring83-successor: <new_pub> <notBefore RFC3339> <notAfter RFC3339> <sig hex> -->
+var successorAttestationRE = regexp.MustCompile(
+	`<!--\s*spring83-successor:\s*([0-9a-f]{64})\s+(\S+)\s+(\S+)\s+([0-9a-f]+)\s*-->`)
+
+// SuccessorAttestation records a board's claim, extracted from its content
+// and already signature-verified, that a different key will replace it.
+type SuccessorAttestation struct {
+	OldPublicKey string
+	NewPublicKey string
+	NotBefore    time.Time
+	NotAfter     time.Time
+}
+
+// parseSuccessorAttestation extracts and verifies a successor attestation
+// embedded in a board's content, returning a nil attestation (and no error)
+// if the board doesn't contain one at all. An attestation that's present but
+// malformed or doesn't verify is an error -- that's most likely tampering,
+// as opposed to simply not publishing one, which is fine.
+func parseSuccessorAttestation(oldPublicKey string, content []byte) (*SuccessorAttestation, error) {
+	match := successorAttestationRE.FindSubmatch(content)
+	if match == nil {
+		return nil, nil
+	}
+
+	newPublicKey := string(match[1])
+
+	notBefore, err := time.Parse(time.RFC3339, string(match[2]))
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing successor attestation's notBefore: %w", err)
+	}
+
+	notAfter, err := time.Parse(time.RFC3339, string(match[3]))
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing successor attestation's notAfter: %w", err)
+	}
+
+	sig, err := hex.DecodeString(string(match[4]))
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing successor attestation's signature: %w", err)
+	}
+
+	if err := nskey.VerifySuccessor(oldPublicKey, newPublicKey, notBefore, notAfter, sig); err != nil {
+		return nil, xerrors.Errorf("error verifying successor attestation: %w", err)
+	}
+
+	return &SuccessorAttestation{
+		OldPublicKey: oldPublicKey,
+		NewPublicKey: newPublicKey,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}, nil
+}
+
+// RotationIndex tracks the most recently published successor attestation for
+// each key that's published one, so that a request for an expired key can be
+// redirected to its replacement instead of simply 404ing.
+type RotationIndex struct {
+	mu           sync.RWMutex
+	attestations map[string]*SuccessorAttestation
+}
+
+func NewRotationIndex() *RotationIndex {
+	return &RotationIndex{
+		attestations: make(map[string]*SuccessorAttestation),
+	}
+}
+
+// Put records attestation, indexed under its old public key, overwriting
+// whatever was previously stored for that key.
+func (i *RotationIndex) Put(attestation *SuccessorAttestation) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.attestations[attestation.OldPublicKey] = attestation
+}
+
+// Lookup returns the successor attestation published for key, or nil if none
+// has been recorded.
+func (i *RotationIndex) Lookup(key string) *SuccessorAttestation {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.attestations[key]
+}
+
+// successorResponse is the JSON body returned by the well-known successor
+// discovery endpoint.
+type successorResponse struct {
+	NewPublicKey string    `json:"new_public_key"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// handleRotationSuccessor serves GET /.well-known/spring83-successor/{key},
+// returning the successor attestation published for key, if any, once its
+// notBefore has passed. This is the non-redirecting counterpart to the 301
+// that handleGetKey issues for an expired key with a valid successor: a
+// client that wants to confirm a rotation without following it can hit this
+// instead.
+func (s *Server) handleRotationSuccessor(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	key := mux.Vars(r)["key"]
+
+	attestation := s.rotationIndex.Lookup(key)
+	if attestation == nil || s.timeNow().Before(attestation.NotBefore) {
+		return nil, NewServerError(http.StatusNotFound, (&BoardNotFoundError{key}).Error())
+	}
+
+	return newJSONResponse(http.StatusOK, &successorResponse{
+		NewPublicKey: attestation.NewPublicKey,
+		NotBefore:    attestation.NotBefore,
+		NotAfter:     attestation.NotAfter,
+	})
+}
@@ -4,23 +4,31 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
 
 	"github.com/brandur/neospring/internal/nskey"
 	"github.com/brandur/neospring/internal/nsstore"
+	"github.com/brandur/neospring/internal/nsstore/nstranslog"
 )
 
 const (
@@ -34,6 +42,18 @@ const (
 	// make the most difference as brand new posts are pushed to other servers
 	// whose clock might be a little behind.
 	TimestampTolerance = 5 * time.Minute
+
+	// DifficultyMaxKeys is the realm-capacity figure used to compute the
+	// current difficulty factor for gating first-time key PUTs:
+	// `(numKnownKeys / DifficultyMaxKeys)^4`. Chosen as a round number
+	// comfortably above what a single-realm server is expected to hold in
+	// practice; an operator running a much larger realm should raise it.
+	DifficultyMaxKeys = 1_000_000
+
+	// difficultyCacheTTL bounds how often the difficulty factor is
+	// recomputed from a fresh BoardStore.Count, since PUT is a hot path and
+	// counting can be O(n) against some store backends.
+	difficultyCacheTTL = 1 * time.Minute
 )
 
 // Error messages returned by various server errors.
@@ -42,6 +62,8 @@ const (
 var (
 	ErrMessageContentTooLarge           = fmt.Sprintf("Content is larger than the maximum allowed size of %d bytes.", MaxContentSize)
 	ErrMessageDeniedKey                 = "This key is denied."
+	ErrMessageDenyListNotReloadable     = "The configured deny list doesn't support reloading."
+	ErrMessageKeyDifficultyInsufficient = "This key does not meet the realm's current difficulty requirement. Try brute-forcing a new key with more leading or trailing hex zeros in its body."
 	ErrMessageInternalError             = "An internal error has occurred. Please report this to the server operator."
 	ErrMessageKeyExpired                = "The given key is expired. The last four digits `MMYY` represent a month and year number which is now allowed to exceed the current month and year."
 	ErrMessageKeyInvalid                = "The given key is invalid. It should be exactly 64 characters in length and be suffixed with `83eMMYY` where `MM` is a valid month number and `YY` are the last two digits of a year."
@@ -56,12 +78,33 @@ var (
 	ErrMessageTimestampOlderThanCurrent = "Content <time> timestamp is older than the timestamp already registered under the given key."
 	ErrMessageTimestampTooOld           = "Content <time> timestamp should not be more than 22 days old."
 	ErrMessageTimestampUnparseable      = "Could not parse timestamp tag. Tag should in standard format and UTC like `<time datetime=\"YYYY-MM-DDTHH:MM:SSZ\">`."
+
+	ErrMessageLogConsistencyFirstMissing    = "Query parameter `first` is required and must be a non-negative integer."
+	ErrMessageLogConsistencySecondMissing   = "Query parameter `second` is required and must be a non-negative integer."
+	ErrMessageLogCosignBodyUnparseable      = "Could not parse cosign request body as JSON."
+	ErrMessageLogCosignInvalid              = "Witness signature does not verify against the cosigned tree head."
+	ErrMessageLogCosignRootHashUnparseable  = "Cosign request's `root_hash` could not be decoded from hex to binary."
+	ErrMessageLogCosignSignatureUnparseable = "Cosign request's `signature` could not be decoded from hex to binary."
+	ErrMessageLogCosignStale                = "Cosigned tree head no longer matches the log's current tree head. Fetch a fresh tree head and try again."
+	ErrMessageLogKeyMissing                 = "Query parameter `key` is required."
+	ErrMessageLogKeyNotInLog                = "The given key has no entries in the transparency log."
+	ErrMessageLogTreeSizeInvalid            = "Query parameter `tree_size` must be a non-negative integer no larger than the log's current tree size."
 )
 
 const (
 	MessageKeyUpdated = "Content for the given key has been updated successfully."
 )
 
+// difficultyCache holds the last-computed difficulty factor so that
+// Server.difficultyFactor doesn't need to call BoardStore.Count on every PUT.
+// Its zero value is usable (an expired cache that computes fresh on first
+// use).
+type difficultyCache struct {
+	mu         sync.Mutex
+	computedAt time.Time
+	factor     float64
+}
+
 type BoardNotFoundError struct {
 	key string
 }
@@ -77,33 +120,154 @@ func (e *IfModifiedSinceParseError) Error() string {
 }
 
 type Server struct {
-	boardStore  nsstore.BoardStore
-	denyList    DenyList
-	httpServer  *http.Server
-	logger      *logrus.Logger
-	router      *mux.Router
+	boardStore      nsstore.BoardStore
+	denyList        DenyList
+	difficultyCache difficultyCache
+	federator       *Federator
+	httpServer      *http.Server
+	logger          *logrus.Logger
+
+	// inspectableWriterMiddleware tracks the number of requests currently in
+	// flight, so Shutdown can wait for them to drain before returning.
+	inspectableWriterMiddleware *InspectableWriterMiddleware
+
+	// metricsHTTPServer serves /metrics on its own listener when metricsPort
+	// is non-zero, keeping it off the main port so it isn't publicly
+	// reachable by default.
+	metricsHTTPServer *http.Server
+
+	rotationIndex *RotationIndex
+	router        *mux.Router
+
+	// shuttingDown is set by Shutdown as soon as it's invoked, before it's
+	// finished draining in-flight requests, so that handleReadyz can start
+	// failing readiness checks immediately and let an upstream load balancer
+	// stop routing new traffic here while the drain is still in progress.
+	shuttingDown atomic.Bool
+
+	// storeShutdown is closed by Shutdown once in-flight requests have
+	// drained, signaling background loops keyed off it (BoardStore.ReapLoop,
+	// a DenyList's ReloadLoop, etc.) that it's safe to stop.
+	storeShutdown     chan struct{}
+	storeShutdownOnce sync.Once
+
+	// sweepInterval configures how often the background Sweeper walks
+	// boardStore looking for expired boards. Defaults to
+	// nsstore.DefaultSweepInterval; overridable in tests.
+	sweepInterval time.Duration
+
 	testKeyPair *nskey.KeyPair
 	timeNow     func() time.Time
+	translog    *nstranslog.Log
 }
 
-func NewServer(logger *logrus.Logger, boardStore nsstore.BoardStore, denyList DenyList, port int) *Server {
+// NewServer initializes a new Server. logKeyPair signs the transparency
+// log's tree heads; it's usually a concrete *nskey.KeyPair, but may be any
+// other nskey.Signer -- e.g. nsvault's Transit-backed signer, for operators
+// who'd rather Vault hold the private key material. It doesn't need to be,
+// and normally wouldn't be, the same key used for anything else.
+//
+// selfURL and peers configure federation with other neospring servers: if
+// peers is non-empty, every successful PUT is forwarded to them and missing
+// boards are pulled from them on a GET miss. selfURL identifies this server
+// in the forwarded-by chain so that peers don't forward updates back here.
+// If peers is empty, federation is disabled entirely.
+//
+// metricsPort, if non-zero, moves the /metrics endpoint off the main router
+// and onto its own listener on that port instead, so that metrics -- which
+// may reveal operational detail like board counts and latencies -- aren't
+// publicly reachable alongside the rest of the API by default.
+//
+// maxInFlight configures MaxInFlightMiddleware, which is always installed to
+// protect the server from being overwhelmed by more concurrent requests than
+// it can handle.
+//
+// accessLog configures CanonicalLogLineMiddleware's logging backend and
+// sampling rate. See AccessLogConfig for its fields and their defaults.
+//
+// storeShutdown is closed by Shutdown once in-flight requests have drained,
+// and should be the same channel passed to boardStore's ReapLoop (and any
+// other background loop that should stop alongside it) so that they're all
+// coordinated through a single graceful shutdown. A nil storeShutdown gets
+// a channel of its own, which is fine for callers (e.g. tests) that never
+// invoke Shutdown.
+func NewServer(
+	logger *logrus.Logger,
+	boardStore nsstore.BoardStore,
+	denyList DenyList,
+	logKeyPair nskey.Signer,
+	selfURL string,
+	peers []string,
+	port int,
+	metricsPort int,
+	maxInFlight MaxInFlightConfig,
+	accessLog AccessLogConfig,
+	rateLimit RateLimitConfig,
+	storeShutdown chan struct{},
+) *Server {
+	if storeShutdown == nil {
+		storeShutdown = make(chan struct{})
+	}
+
 	server := &Server{
-		boardStore:  boardStore,
-		denyList:    denyList,
-		logger:      logger,
-		testKeyPair: nskey.MustParseKeyPairUnchecked(nskey.TestPrivateKey),
-		timeNow:     time.Now,
+		boardStore:                  boardStore,
+		denyList:                    denyList,
+		inspectableWriterMiddleware: NewInspectableWriterMiddleware(),
+		logger:                      logger,
+		rotationIndex:               NewRotationIndex(),
+		storeShutdown:               storeShutdown,
+		sweepInterval:               nsstore.DefaultSweepInterval,
+		testKeyPair:                 nskey.MustParseKeyPairUnchecked(nskey.TestPrivateKey),
+		timeNow:                     time.Now,
+		translog:                    nstranslog.NewLog(logKeyPair),
+	}
+
+	if len(peers) > 0 {
+		server.federator = NewFederator(logger, selfURL, peers, denyList)
 	}
 
 	router := mux.NewRouter()
 
+	accessLogger := accessLog.Logger
+	if accessLogger == nil {
+		accessLogger = NewLogrusAccessLogger(server.logger)
+	}
+
 	router.Use((&ContextContainerMiddleware{}).Wrapper)
-	router.Use((&CanonicalLogLineMiddleware{logger: server.logger}).Wrapper)
+	router.Use(server.inspectableWriterMiddleware.Wrapper)
+	router.Use((&CanonicalLogLineMiddleware{accessLogger: accessLogger, samplePercent: accessLog.SamplePercent}).Wrapper)
 	router.Use((&CORSMiddleware{}).Wrapper)
+	router.Use(NewRateLimitMiddleware(rateLimit).Wrapper)
+	router.Use(NewMaxInFlightMiddleware(maxInFlight).Wrapper)
 
 	router.Handle("/", server.wrapEndpoint(server.handleIndex)).Methods(http.MethodGet)
+	router.Handle("/livez", server.wrapEndpoint(server.handleLivez)).Methods(http.MethodGet)
+	router.Handle("/readyz", server.wrapEndpoint(server.handleReadyz)).Methods(http.MethodGet)
+	router.Handle("/federation/digest", server.wrapEndpoint(server.handleFederationDigest)).Methods(http.MethodGet)
+	router.Handle("/peers", server.wrapEndpoint(server.handlePeers)).Methods(http.MethodGet)
+	router.Handle("/difficulty", server.wrapEndpoint(server.handleDifficulty)).Methods(http.MethodGet)
+	router.Handle("/admin/denylist/reload", server.wrapEndpoint(server.handleAdminDenyListReload)).Methods(http.MethodPost)
+	router.Handle("/log/tree-head", server.wrapEndpoint(server.handleLogTreeHead)).Methods(http.MethodGet)
+	router.Handle("/log/consistency", server.wrapEndpoint(server.handleLogConsistency)).Methods(http.MethodGet)
+	router.Handle("/log/inclusion", server.wrapEndpoint(server.handleLogInclusion)).Methods(http.MethodGet)
+	router.Handle("/log/cosign", server.wrapEndpoint(server.handleLogCosign)).Methods(http.MethodPost)
+	router.Handle("/.well-known/spring83-successor/{key}", server.wrapEndpoint(server.handleRotationSuccessor)).Methods(http.MethodGet)
 	router.Handle("/{key}", server.wrapEndpoint(server.handleGetKey)).Methods(http.MethodGet)
 	router.Handle("/{key}", server.wrapEndpoint(server.handlePutKey)).Methods(http.MethodPut)
+	router.Handle("/{key}/render", server.wrapEndpoint(server.handleRenderKey)).Methods(http.MethodGet)
+
+	if metricsPort == 0 {
+		router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	} else {
+		metricsRouter := mux.NewRouter()
+		metricsRouter.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+		server.metricsHTTPServer = &http.Server{
+			Addr:              fmt.Sprintf(":%d", metricsPort),
+			Handler:           metricsRouter,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+	}
 
 	server.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
@@ -123,6 +287,27 @@ func NewServer(logger *logrus.Logger, boardStore nsstore.BoardStore, denyList De
 func (s *Server) Start(ctx context.Context) error {
 	s.logger.Infof("Listening on %s\n", s.httpServer.Addr)
 
+	sweepShutdown := make(chan struct{})
+	defer close(sweepShutdown)
+
+	if s.federator != nil {
+		go s.federator.SweepLoop(ctx, s.translog.Digest, s.storePulledBoard, sweepShutdown)
+	}
+
+	boardSweepShutdown := make(chan struct{})
+	defer close(boardSweepShutdown)
+
+	go nsstore.NewSweeper(s.logger, s.boardStore, s.sweepInterval).Run(ctx, boardSweepShutdown)
+
+	if s.metricsHTTPServer != nil {
+		go func() {
+			s.logger.Infof("Listening for metrics on %s\n", s.metricsHTTPServer.Addr)
+			if err := s.metricsHTTPServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Errorf("Metrics server error: %v", err)
+			}
+		}()
+	}
+
 	// On SIGTERM, try to shut the server down gracefully: stop accepting new
 	// connections, and wait for existing ones to finish.
 	//
@@ -136,8 +321,7 @@ func (s *Server) Start(ctx context.Context) error {
 		<-sigterm
 
 		s.logger.Infof("Performing graceful shutdown")
-		if err := s.httpServer.Shutdown(ctx); err != nil {
-			// Error from closing listeners, or context timeout
+		if err := s.Shutdown(ctx); err != nil {
 			s.logger.Errorf("Server shutdown error: %v", err)
 		}
 
@@ -153,6 +337,80 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
+// Shutdown coordinates a graceful shutdown, stopping the server from
+// accepting new connections, waiting for in-flight requests to drain, and
+// only then signaling storeShutdown so that background loops sharing its
+// lifecycle (BoardStore.ReapLoop, a DenyList's ReloadLoop, etc.) know it's
+// safe to stop too. handleReadyz starts failing as soon as Shutdown is
+// invoked, before the drain completes, so an upstream load balancer can stop
+// routing new traffic here while it's still in progress.
+//
+// ctx bounds the whole operation: if it's canceled or expires before
+// in-flight requests finish draining, Shutdown gives up and returns its
+// error rather than waiting forever.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return xerrors.Errorf("error shutting down http server: %w", err)
+	}
+
+	if s.metricsHTTPServer != nil {
+		if err := s.metricsHTTPServer.Shutdown(ctx); err != nil {
+			return xerrors.Errorf("error shutting down metrics server: %w", err)
+		}
+	}
+
+	if err := s.waitForActiveRequestsDrained(ctx); err != nil {
+		return err
+	}
+
+	s.storeShutdownOnce.Do(func() { close(s.storeShutdown) })
+
+	return nil
+}
+
+// waitForActiveRequestsDrained polls the in-flight request count tracked by
+// inspectableWriterMiddleware until it reaches zero or ctx is done. In
+// practice http.Server.Shutdown above will already have waited out most
+// in-flight work, but polling this count too gives an explicit, testable
+// signal that's independent of net/http's own internal bookkeeping.
+func (s *Server) waitForActiveRequestsDrained(ctx context.Context) error {
+	const pollInterval = 10 * time.Millisecond
+
+	for s.inspectableWriterMiddleware.ActiveRequests() > 0 {
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("context expired while draining %d in-flight request(s): %w",
+				s.inspectableWriterMiddleware.ActiveRequests(), ctx.Err())
+
+		case <-time.After(pollInterval):
+		}
+	}
+
+	return nil
+}
+
+// handleLivez reports liveness: whether the process is up and able to serve
+// requests at all. It stays 200 all the way through shutdown, since a
+// draining server is still alive -- handleReadyz is what signals a load
+// balancer to stop sending it new traffic.
+func (s *Server) handleLivez(_ context.Context, _ *http.Request) (*ServerResponse, error) {
+	return NewServerResponse(http.StatusOK, []byte("ok"), nil), nil
+}
+
+// handleReadyz reports readiness: whether this instance should currently be
+// receiving new traffic. It flips to 503 the moment Shutdown is invoked, so
+// that an upstream load balancer polling it can drain new requests away from
+// this instance well before in-flight ones finish and the process exits.
+func (s *Server) handleReadyz(_ context.Context, _ *http.Request) (*ServerResponse, error) {
+	if s.shuttingDown.Load() {
+		return nil, NewServerError(http.StatusServiceUnavailable, "Server is shutting down.")
+	}
+
+	return NewServerResponse(http.StatusOK, []byte("ok"), nil), nil
+}
+
 func (s *Server) handleGetKey(ctx context.Context, r *http.Request) (*ServerResponse, error) {
 	var (
 		board *nsstore.Board
@@ -186,6 +444,18 @@ func (s *Server) handleGetKey(ctx context.Context, r *http.Request) (*ServerResp
 	if err != nil {
 		switch {
 		case errors.Is(err, nskey.ErrKeyExpired):
+			// Before giving up, see whether the author published a successor
+			// attestation for this key: if so, and it's taken effect, send the
+			// client onward to the new key rather than dead-ending them here.
+			if attestation := s.rotationIndex.Lookup(key); attestation != nil {
+				if expiresAt, expiryErr := nskey.KeyExpiry(key, s.timeNow()); expiryErr == nil &&
+					!s.timeNow().Before(attestation.NotBefore) && s.timeNow().Before(expiresAt.Add(RotationGraceWindow)) {
+					return NewServerResponse(http.StatusMovedPermanently, nil, http.Header{
+						"Location": []string{"/" + attestation.NewPublicKey},
+					}), nil
+				}
+			}
+
 			return nil, NewServerError(http.StatusForbidden, ErrMessageKeyExpired)
 		case errors.Is(err, nskey.ErrKeyInvalid):
 			return nil, NewServerError(http.StatusForbidden, ErrMessageKeyInvalid)
@@ -197,16 +467,34 @@ func (s *Server) handleGetKey(ctx context.Context, r *http.Request) (*ServerResp
 	}
 
 	if s.denyList.Contains(key) {
+		if ctxContainer := contextContainerFromOptional(ctx); ctxContainer != nil {
+			ctxContainer.Denied = true
+		}
 		return nil, NewServerError(http.StatusForbidden, ErrMessageDeniedKey)
 	}
 
 	board, err = s.boardStore.Get(ctx, key)
 	if err != nil {
-		if errors.Is(err, nsstore.ErrKeyNotFound) {
+		if !errors.Is(err, nsstore.ErrKeyNotFound) {
+			return nil, xerrors.Errorf("error getting key %q from store: %w", key, err)
+		}
+
+		if s.federator == nil {
 			return nil, notFoundError()
 		}
 
-		return nil, xerrors.Errorf("error getting key %q from store: %w", key, err)
+		// We don't have this board locally -- see if a federation peer does
+		// before giving up on it.
+		pulled, err := s.federator.Pull(ctx, key)
+		if err != nil || s.timeNow().After(pulled.Timestamp.Add(nsstore.MaxContentAge)) {
+			return nil, notFoundError()
+		}
+
+		if err := s.storePulledBoard(ctx, key, pulled); err != nil {
+			return nil, err
+		}
+
+		board = pulled
 	}
 
 	// The Spring '83 spec stipulates that boards are never deleted, but can be
@@ -258,9 +546,28 @@ func (s *Server) handlePutKey(ctx context.Context, r *http.Request) (*ServerResp
 	}
 
 	if s.denyList.Contains(key) {
+		if ctxContainer := contextContainerFromOptional(ctx); ctxContainer != nil {
+			ctxContainer.Denied = true
+		}
 		return nil, NewServerError(http.StatusForbidden, ErrMessageDeniedKey)
 	}
 
+	// The difficulty check only gates first-time PUTs for a key the store has
+	// never seen before -- a key that's already live is grandfathered in
+	// regardless of how much the threshold has risen since.
+	if _, err := s.boardStore.Get(ctx, key); errors.Is(err, nsstore.ErrKeyNotFound) {
+		factor, err := s.difficultyFactor(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !nskey.MeetsDifficulty(key, factor) {
+			return nil, NewServerError(http.StatusForbidden, ErrMessageKeyDifficultyInsufficient)
+		}
+	} else if err != nil {
+		return nil, xerrors.Errorf("error getting key %q from store: %w", key, err)
+	}
+
 	content, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, xerrors.Errorf("error reading request body: %v", err)
@@ -287,7 +594,11 @@ func (s *Server) handlePutKey(ctx context.Context, r *http.Request) (*ServerResp
 
 	// Verify the signature early because it might prevent against other types
 	// of bad requests that might be more expensive to check.
-	if !keyObj.Verify(content, sig) {
+	signatureValid := keyObj.Verify(content, sig)
+	if ctxContainer := contextContainerFromOptional(ctx); ctxContainer != nil {
+		ctxContainer.SignatureValid = &signatureValid
+	}
+	if !signatureValid {
 		return nil, NewServerError(http.StatusUnauthorized, ErrMessageSignatureInvalid)
 	}
 
@@ -311,11 +622,34 @@ func (s *Server) handlePutKey(ctx context.Context, r *http.Request) (*ServerResp
 
 	// If we have a board with a timestamp newer than the given one, we're meant
 	// to return a 409 conflict to the requesting user indicating so.
-	board, err := s.boardStore.Get(ctx, key)
+	existingBoard, err := s.boardStore.Get(ctx, key)
 	if err == nil {
-		if board.Timestamp.After(timestamp) {
+		if existingBoard.Timestamp.After(timestamp) {
 			return nil, NewServerError(http.StatusConflict, ErrMessageTimestampOlderThanCurrent)
 		}
+
+		// Timestamps equal to what we already have are most often the result
+		// of a federation loop, or of more than one peer forwarding the same
+		// update to us. Treat the PUT as a no-op rather than storing and
+		// re-forwarding it again, which is what lets propagation terminate.
+		if existingBoard.Timestamp.Equal(timestamp) {
+			return NewServerResponse(http.StatusOK, []byte(MessageKeyUpdated), http.Header{
+				"Spring-Version": []string{"83"},
+			}), nil
+		}
+	}
+
+	if err := s.storeBoardAndLog(ctx, key, &nsstore.Board{
+		Content:   content,
+		Signature: sigStr,
+		Timestamp: timestamp,
+	}); err != nil {
+		return nil, err
+	}
+
+	if s.federator != nil {
+		forwardedBy := parseForwardedBy(r.Header.Get(ForwardedByHeader))
+		s.federator.Forward(key, sigStr, content, forwardedBy)
 	}
 
 	return NewServerResponse(http.StatusOK, []byte(MessageKeyUpdated), http.Header{
@@ -323,10 +657,364 @@ func (s *Server) handlePutKey(ctx context.Context, r *http.Request) (*ServerResp
 	}), nil
 }
 
+// storeBoardAndLog persists board under key and appends the corresponding
+// entry to the transparency log, synchronously, so that by the time a
+// client sees a successful response the update is already committed to the
+// log and reflected in the next tree head. Used both for boards submitted
+// directly via PUT and for ones pulled in from a federation peer.
+func (s *Server) storeBoardAndLog(ctx context.Context, key string, board *nsstore.Board) error {
+	if err := s.boardStore.Put(ctx, key, board); err != nil {
+		return xerrors.Errorf("error storing key %q: %w", key, err)
+	}
+
+	if _, err := s.translog.Append(key, board.Signature, board.Content, board.Timestamp); err != nil {
+		return xerrors.Errorf("error appending key %q to transparency log: %w", key, err)
+	}
+
+	// A board carrying a successor attestation doesn't need to do anything
+	// else to publish it -- we pick it up here and index it so that a
+	// request for the old key can be redirected once it expires. An
+	// attestation that's present but fails to verify is logged and
+	// otherwise ignored rather than rejecting the whole PUT, since the
+	// board's primary content is still perfectly valid.
+	attestation, err := parseSuccessorAttestation(key, board.Content)
+	if err != nil {
+		var logger logrus.FieldLogger = s.logger
+		if ctxContainer := contextContainerFromOptional(ctx); ctxContainer != nil {
+			logger = logger.WithField("request_id", ctxContainer.RequestID)
+		}
+		logger.Infof("Server: Error parsing successor attestation for key %q: %v", key, err)
+	} else if attestation != nil {
+		s.rotationIndex.Put(attestation)
+	}
+
+	return nil
+}
+
+// storePulledBoard is the store callback handed to the federator, both for a
+// one-off pull on a GET miss and for the periodic anti-entropy sweep. It
+// silently drops boards that have already expired by the time they're
+// pulled rather than storing them only to have the reap loop remove them.
+func (s *Server) storePulledBoard(ctx context.Context, key string, board *nsstore.Board) error {
+	if s.timeNow().After(board.Timestamp.Add(nsstore.MaxContentAge)) {
+		return nil
+	}
+
+	return s.storeBoardAndLog(ctx, key, board)
+}
+
 func (s *Server) handleIndex(ctx context.Context, r *http.Request) (*ServerResponse, error) {
 	return NewServerResponse(http.StatusOK, []byte("hello"), nil), nil
 }
 
+// logTreeHeadResponse is the JSON representation of a nstranslog.SignedTreeHead
+// returned from `GET /log/tree-head`, together with whatever witness
+// cosignatures have been collected for it so far.
+type logTreeHeadResponse struct {
+	TreeSize     int                      `json:"tree_size"`
+	RootHash     string                   `json:"root_hash"`
+	Timestamp    time.Time                `json:"timestamp"`
+	Signature    string                   `json:"signature"`
+	Cosignatures []logCosignatureResponse `json:"cosignatures"`
+}
+
+type logCosignatureResponse struct {
+	WitnessPublicKey string `json:"witness_public_key"`
+	Signature        string `json:"signature"`
+}
+
+type logConsistencyProofResponse struct {
+	First  int      `json:"first"`
+	Second int      `json:"second"`
+	Proof  []string `json:"proof"`
+}
+
+type logInclusionProofResponse struct {
+	LeafIndex int      `json:"leaf_index"`
+	TreeSize  int      `json:"tree_size"`
+	LeafHash  string   `json:"leaf_hash"`
+	Proof     []string `json:"proof"`
+}
+
+type logCosignRequest struct {
+	WitnessPublicKey string `json:"witness_public_key"`
+	TreeSize         int    `json:"tree_size"`
+	RootHash         string `json:"root_hash"`
+	Signature        string `json:"signature"`
+}
+
+// handleLogTreeHead returns the transparency log's current signed tree head,
+// along with any witness cosignatures collected for it, so that clients
+// requiring N-of-M witness agreement have everything they need in one call.
+func (s *Server) handleLogTreeHead(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	head := s.translog.TreeHead()
+
+	return newJSONResponse(http.StatusOK, &logTreeHeadResponse{
+		TreeSize:     head.TreeSize,
+		RootHash:     hex.EncodeToString(head.RootHash),
+		Timestamp:    head.Timestamp,
+		Signature:    hex.EncodeToString(head.Signature),
+		Cosignatures: logCosignaturesToResponse(s.translog.Cosignatures(head.TreeSize)),
+	})
+}
+
+// handleLogConsistency returns the audit path proving that the tree of size
+// `first` is a prefix of the tree of size `second`, so that a client that
+// trusts an earlier tree head can confirm the log hasn't rewritten history.
+func (s *Server) handleLogConsistency(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	first, err := parseTreeSizeParam(r, "first")
+	if err != nil {
+		return nil, NewServerError(http.StatusBadRequest, ErrMessageLogConsistencyFirstMissing)
+	}
+
+	second, err := parseTreeSizeParam(r, "second")
+	if err != nil {
+		return nil, NewServerError(http.StatusBadRequest, ErrMessageLogConsistencySecondMissing)
+	}
+
+	proof, err := s.translog.ConsistencyProof(first, second)
+	if err != nil {
+		if errors.Is(err, nstranslog.ErrTreeSizeInvalid) {
+			return nil, NewServerError(http.StatusBadRequest, ErrMessageLogTreeSizeInvalid)
+		}
+
+		return nil, xerrors.Errorf("error computing consistency proof: %w", err)
+	}
+
+	return newJSONResponse(http.StatusOK, &logConsistencyProofResponse{
+		First:  first,
+		Second: second,
+		Proof:  hashesToHex(proof),
+	})
+}
+
+// handleLogInclusion returns the audit path proving that the most recent
+// entry for the given board key is included in the tree of the given size
+// (defaulting to the log's current size).
+func (s *Server) handleLogInclusion(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		return nil, NewServerError(http.StatusBadRequest, ErrMessageLogKeyMissing)
+	}
+
+	treeSize := s.translog.TreeHead().TreeSize
+	if r.URL.Query().Has("tree_size") {
+		parsed, err := parseTreeSizeParam(r, "tree_size")
+		if err != nil {
+			return nil, NewServerError(http.StatusBadRequest, ErrMessageLogTreeSizeInvalid)
+		}
+		treeSize = parsed
+	}
+
+	index, ok := s.translog.IndexForKey(key)
+	if !ok {
+		return nil, NewServerError(http.StatusNotFound, ErrMessageLogKeyNotInLog)
+	}
+
+	proof, err := s.translog.InclusionProof(index, treeSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, nstranslog.ErrTreeSizeInvalid):
+			return nil, NewServerError(http.StatusBadRequest, ErrMessageLogTreeSizeInvalid)
+		case errors.Is(err, nstranslog.ErrLeafIndexInvalid):
+			return nil, NewServerError(http.StatusNotFound, ErrMessageLogKeyNotInLog)
+		}
+
+		return nil, xerrors.Errorf("error computing inclusion proof: %w", err)
+	}
+
+	leafHash, err := s.translog.LeafHash(index)
+	if err != nil {
+		return nil, xerrors.Errorf("error fetching leaf hash for key %q: %w", key, err)
+	}
+
+	return newJSONResponse(http.StatusOK, &logInclusionProofResponse{
+		LeafIndex: index,
+		TreeSize:  treeSize,
+		LeafHash:  hex.EncodeToString(leafHash),
+		Proof:     hashesToHex(proof),
+	})
+}
+
+// handleLogCosign lets an external witness record that it's observed the
+// log's current tree head and found it consistent with an earlier one it
+// already trusts. The witness is expected to have done that consistency
+// check itself; this only verifies the cosignature matches the tree head and
+// was genuinely produced by the claimed witness key.
+func (s *Server) handleLogCosign(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	var body logCosignRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, NewServerError(http.StatusBadRequest, ErrMessageLogCosignBodyUnparseable)
+	}
+
+	rootHash, err := hex.DecodeString(body.RootHash)
+	if err != nil {
+		return nil, NewServerError(http.StatusBadRequest, ErrMessageLogCosignRootHashUnparseable)
+	}
+
+	signature, err := hex.DecodeString(body.Signature)
+	if err != nil {
+		return nil, NewServerError(http.StatusBadRequest, ErrMessageLogCosignSignatureUnparseable)
+	}
+
+	if err := s.translog.AddCosignature(body.WitnessPublicKey, body.TreeSize, rootHash, signature); err != nil {
+		switch {
+		case errors.Is(err, nstranslog.ErrStaleTreeHead):
+			return nil, NewServerError(http.StatusConflict, ErrMessageLogCosignStale)
+		case errors.Is(err, nstranslog.ErrCosignatureInvalid):
+			return nil, NewServerError(http.StatusUnauthorized, ErrMessageLogCosignInvalid)
+		}
+
+		return nil, xerrors.Errorf("error adding witness cosignature: %w", err)
+	}
+
+	return newJSONResponse(http.StatusOK, &logTreeHeadResponse{
+		TreeSize:     body.TreeSize,
+		RootHash:     body.RootHash,
+		Cosignatures: logCosignaturesToResponse(s.translog.Cosignatures(body.TreeSize)),
+	})
+}
+
+// handleFederationDigest returns every (public_key, timestamp) tuple this
+// server's transparency log knows about, so that a federation peer can
+// compare it against its own and pull anything it's missing.
+func (s *Server) handleFederationDigest(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	digest := s.translog.Digest()
+
+	entries := make([]digestEntry, 0, len(digest))
+	for publicKey, timestamp := range digest {
+		entries = append(entries, digestEntry{PublicKey: publicKey, Timestamp: timestamp})
+	}
+
+	return newJSONResponse(http.StatusOK, entries)
+}
+
+// peerResponse is a single entry in the JSON array returned by handlePeers.
+type peerResponse struct {
+	URL string `json:"url"`
+	PeerStatus
+}
+
+// handlePeers returns the configured federation peer list along with this
+// server's last-observed health for each one, so operators can inspect the
+// realm graph without digging through logs. Returns an empty array (not an
+// error) if federation isn't configured.
+func (s *Server) handlePeers(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	if s.federator == nil {
+		return newJSONResponse(http.StatusOK, []peerResponse{})
+	}
+
+	statuses := s.federator.PeerStatuses()
+
+	entries := make([]peerResponse, 0, len(statuses))
+	for url, status := range statuses {
+		entries = append(entries, peerResponse{URL: url, PeerStatus: status})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+
+	return newJSONResponse(http.StatusOK, entries)
+}
+
+// difficultyResponse is the body returned by handleDifficulty.
+type difficultyResponse struct {
+	DifficultyFactor float64 `json:"difficulty_factor"`
+}
+
+// handleDifficulty returns the realm's current difficulty factor, as
+// required of new keys submitted via PUT.
+func (s *Server) handleDifficulty(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	factor, err := s.difficultyFactor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newJSONResponse(http.StatusOK, &difficultyResponse{DifficultyFactor: factor})
+}
+
+// handleAdminDenyListReload forces an immediate, out-of-band refresh of the
+// configured DenyList, for operators who don't want to wait out its poll
+// interval (or send it a SIGHUP) after pushing a new ban. Returns 501 if the
+// configured DenyList doesn't support reloading, e.g. a MemoryDenyList.
+func (s *Server) handleAdminDenyListReload(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+	reloadable, ok := s.denyList.(Reloadable)
+	if !ok {
+		return nil, NewServerError(http.StatusNotImplemented, ErrMessageDenyListNotReloadable)
+	}
+
+	if err := reloadable.Reload(ctx); err != nil {
+		return nil, xerrors.Errorf("error reloading deny list: %w", err)
+	}
+
+	return NewServerResponse(http.StatusOK, []byte("Deny list reloaded"), nil), nil
+}
+
+// difficultyFactor returns the realm's current difficulty factor, computed as
+// `(numKnownKeys / DifficultyMaxKeys)^4` from a BoardStore.Count, and clamped
+// to 1. The result is cached for difficultyCacheTTL so that the count isn't
+// recomputed on every PUT.
+func (s *Server) difficultyFactor(ctx context.Context) (float64, error) {
+	s.difficultyCache.mu.Lock()
+	defer s.difficultyCache.mu.Unlock()
+
+	if s.timeNow().Sub(s.difficultyCache.computedAt) < difficultyCacheTTL {
+		return s.difficultyCache.factor, nil
+	}
+
+	count, err := s.boardStore.Count(ctx)
+	if err != nil {
+		return 0, xerrors.Errorf("error counting boards for difficulty factor: %w", err)
+	}
+
+	factor := math.Pow(float64(count)/float64(DifficultyMaxKeys), 4)
+	if factor > 1 {
+		factor = 1
+	}
+
+	s.difficultyCache.factor = factor
+	s.difficultyCache.computedAt = s.timeNow()
+
+	return factor, nil
+}
+
+func parseTreeSizeParam(r *http.Request, name string) (int, error) {
+	return strconv.Atoi(r.URL.Query().Get(name))
+}
+
+func logCosignaturesToResponse(cosignatures []nstranslog.Cosignature) []logCosignatureResponse {
+	out := make([]logCosignatureResponse, len(cosignatures))
+	for i, c := range cosignatures {
+		out[i] = logCosignatureResponse{
+			WitnessPublicKey: c.WitnessPublicKey,
+			Signature:        hex.EncodeToString(c.Signature),
+		}
+	}
+	return out
+}
+
+func hashesToHex(hashes [][]byte) []string {
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		out[i] = hex.EncodeToString(h)
+	}
+	return out
+}
+
+// newJSONResponse marshals body to JSON and wraps it in a ServerResponse with
+// an appropriate Content-Type, for use by the transparency log endpoints
+// which (unlike the Spring '83 endpoints proper) aren't constrained by the
+// protocol spec to return raw board content.
+func newJSONResponse(statusCode int, body any) (*ServerResponse, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, xerrors.Errorf("error marshaling JSON response: %w", err)
+	}
+
+	return NewServerResponse(statusCode, encoded, http.Header{
+		"Content-Type": []string{"application/json"},
+	}), nil
+}
+
 // Randomizes board contents for the test key, as recommended by the Spring '83
 // while fulfilling test key requests.
 func (s *Server) randomizeTestKeyBoard(ctx context.Context) (*nsstore.Board, error) {
@@ -360,26 +1048,53 @@ func (s *Server) wrapEndpoint(h func(ctx context.Context, r *http.Request) (*Ser
 
 		w.Header().Set("Content-Type", "text/html;charset=utf-8")
 
+		// A handler can panic on a dependency failure that has no way to
+		// surface as an error -- nskey.Signer implementations backed by a
+		// remote signer (e.g. nsvault's Transit-backed one) panic on a
+		// Vault outage, since nstranslog.Log.treeHeadLocked calls Sign
+		// synchronously with no error return to propagate. Recover here so
+		// that ends in a 500 like any other internal error, rather than an
+		// abrupt connection reset via net/http's bare per-request recovery.
+		defer func() {
+			if rec := recover(); rec != nil {
+				s.logger.WithField("request_id", ctxContainer.RequestID).
+					Errorf("Internal server error (recovered panic): %v", rec)
+				writeStatus(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(ErrMessageInternalError))
+			}
+		}()
+
 		resp, err := h(r.Context(), r)
 		if err != nil {
 			var serverErr *ServerError
 			if errors.As(err, &serverErr) {
-				s.logger.Infof("User error [status %d]: %v", serverErr.StatusCode, serverErr)
+				ctxContainer.ErrorClass = http.StatusText(serverErr.StatusCode)
+				s.logger.WithField("request_id", ctxContainer.RequestID).
+					Infof("User error [status %d]: %v", serverErr.StatusCode, serverErr)
 				writeStatus(serverErr.StatusCode)
 				_, _ = w.Write([]byte(serverErr.Error()))
 				return
 			}
 
-			s.logger.Errorf("Internal server error: %v", err)
+			s.logger.WithField("request_id", ctxContainer.RequestID).
+				Errorf("Internal server error: %v", err)
 			writeStatus(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(ErrMessageInternalError))
 			return
 		}
 
+		// Use Set rather than Add for a header's first value so that, e.g., a
+		// handler setting its own Content-Type (as the transparency log
+		// endpoints do, to return JSON instead of the default) replaces ours
+		// rather than being appended alongside it.
 		if len(resp.Header) > 0 {
 			for k, vs := range resp.Header {
-				for _, v := range vs {
-					w.Header().Add(k, v)
+				for i, v := range vs {
+					if i == 0 {
+						w.Header().Set(k, v)
+					} else {
+						w.Header().Add(k, v)
+					}
 				}
 			}
 		}
@@ -392,22 +1107,6 @@ func (s *Server) wrapEndpoint(h func(ctx context.Context, r *http.Request) (*Ser
 	})
 }
 
-// Implements the error interface and provides an easy way to return a
-// particular status code and error message that's interpreted by `wrapEndpoint`
-// and written back to an `http.ResponseWriter`.
-type ServerError struct {
-	Message    string
-	StatusCode int
-}
-
-func NewServerError(statusCode int, message string) *ServerError {
-	return &ServerError{StatusCode: statusCode, Message: message}
-}
-
-func (e *ServerError) Error() string {
-	return e.Message
-}
-
 // Wraps up an HTTP status code, headers, and body and which can be returned by
 // handlers as a more testable alternative to a HTTP response. Interpreted by
 // `wrapEndpoint` and written back to an `http.ResponseWriter`.
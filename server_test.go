@@ -3,7 +3,10 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,11 +23,14 @@ import (
 	"github.com/brandur/neospring/internal/nskey"
 	"github.com/brandur/neospring/internal/nsstore"
 	"github.com/brandur/neospring/internal/nsstore/nsmemorystore"
+	"github.com/brandur/neospring/internal/nsstore/nstranslog"
 )
 
 const (
 	samplePrivateKey = "90ba51828ecc30132d4707d55d24456fbd726514cf56ab4668b62392798e2540"
 	samplePublicKey  = "e90e9091b13a6e5194c1fed2728d1fdb6de7df362497d877b8c0b8f0883e1124"
+
+	sampleLogPrivateKey = "298b10b10e2603ad07c29d80d1867b59aaa2f4d254e2f7cae3e74225689ef038"
 )
 
 var logger = logrus.New()
@@ -64,9 +70,10 @@ func TestServerHandleGetKey(t *testing.T) {
 			t.Helper()
 
 			ctx = context.Background()
-			store = nsmemorystore.NewMemoryStore(logger)
+			store = nsmemorystore.NewMemoryStore(logger, 0)
+			store.SetTimeNow(stableTimeFunc)
 			denyList = NewMemoryDenyList()
-			server = NewServer(logger, store, denyList, defaultPort)
+			server = NewServer(logger, store, denyList, nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
 			server.timeNow = stableTimeFunc
 
 			test(t)
@@ -191,9 +198,10 @@ func TestServerHandlePutKey(t *testing.T) {
 			t.Helper()
 
 			ctx = context.Background()
-			store = nsmemorystore.NewMemoryStore(logger)
+			store = nsmemorystore.NewMemoryStore(logger, 0)
+			store.SetTimeNow(stableTimeFunc)
 			denyList = NewMemoryDenyList()
-			server = NewServer(logger, store, denyList, defaultPort)
+			server = NewServer(logger, store, denyList, nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
 			server.timeNow = stableTimeFunc
 
 			test(t)
@@ -253,6 +261,15 @@ func TestServerHandlePutKey(t *testing.T) {
 		requireServerError(t, NewServerError(http.StatusForbidden, ErrMessageDeniedKey), err)
 	}))
 
+	t.Run("DenyListRecordsDeniedOnContextContainer", setup(func(t *testing.T) {
+		ctxContainer := &ContextContainer{}
+		ctx := context.WithValue(ctx, contextContainerContextKey{}, ctxContainer)
+
+		_, err := server.handlePutKey(ctx, requestForKey(InfernalPublicKey, timestampTag(stableTime)+" some other content"))
+		requireServerError(t, NewServerError(http.StatusForbidden, ErrMessageDeniedKey), err)
+		require.True(t, ctxContainer.Denied)
+	}))
+
 	t.Run("ContentTooLarge", setup(func(t *testing.T) {
 		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
 
@@ -303,6 +320,21 @@ func TestServerHandlePutKey(t *testing.T) {
 		requireServerError(t, NewServerError(http.StatusUnauthorized, ErrMessageSignatureInvalid), err)
 	}))
 
+	t.Run("SignatureResultRecordedOnContextContainer", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+		ctxContainer := &ContextContainer{}
+		ctx := context.WithValue(ctx, contextContainerContextKey{}, ctxContainer)
+
+		r := requestForKey(samplePublicKey, timestampTag(stableTime)+" some other content")
+		r.Header.Set("Spring-Signature", hex.EncodeToString(keyPair.Sign([]byte("other content"))))
+
+		_, err := server.handlePutKey(ctx, r)
+		requireServerError(t, NewServerError(http.StatusUnauthorized, ErrMessageSignatureInvalid), err)
+		require.NotNil(t, ctxContainer.SignatureValid)
+		require.False(t, *ctxContainer.SignatureValid)
+	}))
+
 	t.Run("TimestampMissing", setup(func(t *testing.T) {
 		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
 
@@ -338,12 +370,207 @@ func TestServerHandlePutKey(t *testing.T) {
 		_, err := server.handlePutKey(ctx, signedRequestForKey(keyPair, timestampTag(stableTime.Add(-5*time.Minute))+" some other content")) //nolint:lll
 		requireServerError(t, NewServerError(http.StatusConflict, ErrMessageTimestampOlderThanCurrent), err)
 	}))
+
+	t.Run("DifficultyInsufficient", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		server.difficultyCache = difficultyCache{factor: 1, computedAt: stableTime}
+
+		_, err := server.handlePutKey(ctx, signedRequestForKey(keyPair, timestampTag(stableTime)+" some other content"))
+		requireServerError(t, NewServerError(http.StatusForbidden, ErrMessageKeyDifficultyInsufficient), err)
+	}))
+
+	t.Run("DifficultyExemptForAlreadyKnownKey", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		_ = storeKeyContent(keyPair, stableTime)
+		server.difficultyCache = difficultyCache{factor: 1, computedAt: stableTime}
+
+		_, err := server.handlePutKey(ctx, signedRequestForKey(keyPair, timestampTag(stableTime.Add(time.Minute))+" some other content")) //nolint:lll
+		require.NoError(t, err)
+	}))
 }
 
-func TestParseTemplates(t *testing.T) {
-	server := NewServer(logger, nil, nil, defaultPort)
-	err := server.parseTemplates()
-	require.NoError(t, err)
+func TestServerDifficultyFactor(t *testing.T) {
+	ctx := context.Background()
+	store := nsmemorystore.NewMemoryStore(logger, 0)
+	store.SetTimeNow(stableTimeFunc)
+	denyList := NewMemoryDenyList()
+	server := NewServer(logger, store, denyList, nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+	server.timeNow = stableTimeFunc
+
+	t.Run("EmptyStore", func(t *testing.T) {
+		factor, err := server.difficultyFactor(ctx)
+		require.NoError(t, err)
+		require.Zero(t, factor)
+	})
+
+	t.Run("CachedUntilTTLExpires", func(t *testing.T) {
+		server.difficultyCache = difficultyCache{factor: 0.5, computedAt: stableTime}
+
+		factor, err := server.difficultyFactor(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 0.5, factor)
+	})
+
+	t.Run("HandleDifficultyReturnsCurrentFactor", func(t *testing.T) {
+		server.difficultyCache = difficultyCache{factor: 0.25, computedAt: stableTime}
+
+		resp, err := server.handleDifficulty(ctx, mustNewRequest(ctx, http.MethodGet, "/difficulty", nil, nil))
+		require.NoError(t, err)
+		requireServerResponse(t, NewServerResponse(http.StatusOK, []byte(`{"difficulty_factor":0.25}`), http.Header{
+			"Content-Type": []string{"application/json"},
+		}), resp)
+	})
+}
+
+func TestServerTransparencyLog(t *testing.T) {
+	var (
+		ctx    context.Context
+		server *Server
+		store  *nsmemorystore.MemoryStore
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			ctx = context.Background()
+			store = nsmemorystore.NewMemoryStore(logger, 0)
+			store.SetTimeNow(stableTimeFunc)
+			server = NewServer(logger, store, NewMemoryDenyList(), nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil) //nolint:lll
+			server.timeNow = stableTimeFunc
+
+			test(t)
+		}
+	}
+
+	putBoard := func(t *testing.T, keyPair *nskey.KeyPair, content string) {
+		t.Helper()
+
+		r := mustNewRequest(ctx, http.MethodPut, "/"+keyPair.PublicKey, map[string]string{"key": keyPair.PublicKey}, strings.NewReader(content)) //nolint:lll
+		r.Header.Set("Spring-Signature", hex.EncodeToString(keyPair.Sign([]byte(content))))
+
+		_, err := server.handlePutKey(ctx, r)
+		require.NoError(t, err)
+	}
+
+	t.Run("HandlePutKeyAppendsToLog", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		putBoard(t, keyPair, timestampTag(stableTime)+" some content")
+
+		head := server.translog.TreeHead()
+		require.Equal(t, 1, head.TreeSize)
+
+		index, ok := server.translog.IndexForKey(keyPair.PublicKey)
+		require.True(t, ok)
+		require.Equal(t, 0, index)
+	}))
+
+	t.Run("HandleLogTreeHead", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		putBoard(t, keyPair, timestampTag(stableTime)+" some content")
+
+		resp, err := server.handleLogTreeHead(ctx, mustNewRequest(ctx, http.MethodGet, "/log/tree-head", nil, nil))
+		require.NoError(t, err)
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var body logTreeHeadResponse
+		require.NoError(t, json.Unmarshal(resp.Body, &body))
+		require.Equal(t, 1, body.TreeSize)
+		require.Empty(t, body.Cosignatures)
+	}))
+
+	t.Run("HandleLogConsistency", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		putBoard(t, keyPair, timestampTag(stableTime)+" v1")
+		putBoard(t, keyPair, timestampTag(stableTime.Add(time.Minute))+" v2")
+
+		resp, err := server.handleLogConsistency(ctx, mustNewRequest(ctx, http.MethodGet, "/log/consistency?first=1&second=2", nil, nil)) //nolint:lll
+		require.NoError(t, err)
+
+		var body logConsistencyProofResponse
+		require.NoError(t, json.Unmarshal(resp.Body, &body))
+		require.Equal(t, 1, body.First)
+		require.Equal(t, 2, body.Second)
+	}))
+
+	t.Run("HandleLogConsistencyMissingParam", setup(func(t *testing.T) {
+		_, err := server.handleLogConsistency(ctx, mustNewRequest(ctx, http.MethodGet, "/log/consistency?second=2", nil, nil))
+		requireServerError(t, NewServerError(http.StatusBadRequest, ErrMessageLogConsistencyFirstMissing), err)
+	}))
+
+	t.Run("HandleLogInclusion", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		putBoard(t, keyPair, timestampTag(stableTime)+" some content")
+
+		resp, err := server.handleLogInclusion(ctx, mustNewRequest(ctx, http.MethodGet, "/log/inclusion?key="+keyPair.PublicKey, nil, nil)) //nolint:lll
+		require.NoError(t, err)
+
+		var body logInclusionProofResponse
+		require.NoError(t, json.Unmarshal(resp.Body, &body))
+		require.Equal(t, 0, body.LeafIndex)
+		require.Equal(t, 1, body.TreeSize)
+
+		leafHash, err := server.translog.LeafHash(0)
+		require.NoError(t, err)
+		require.Equal(t, hex.EncodeToString(leafHash), body.LeafHash)
+	}))
+
+	t.Run("HandleLogInclusionUnknownKey", setup(func(t *testing.T) {
+		_, err := server.handleLogInclusion(ctx, mustNewRequest(ctx, http.MethodGet, "/log/inclusion?key="+samplePublicKey, nil, nil))
+		requireServerError(t, NewServerError(http.StatusNotFound, ErrMessageLogKeyNotInLog), err)
+	}))
+
+	t.Run("HandleLogInclusionMissingKey", setup(func(t *testing.T) {
+		_, err := server.handleLogInclusion(ctx, mustNewRequest(ctx, http.MethodGet, "/log/inclusion", nil, nil))
+		requireServerError(t, NewServerError(http.StatusBadRequest, ErrMessageLogKeyMissing), err)
+	}))
+
+	t.Run("HandleLogCosign", setup(func(t *testing.T) {
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		putBoard(t, keyPair, timestampTag(stableTime)+" some content")
+
+		head := server.translog.TreeHead()
+
+		witnessPublic, witnessPrivate, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		witnessSig := ed25519.Sign(witnessPrivate, nstranslog.TreeHeadPayload(head.TreeSize, head.RootHash))
+
+		reqBody, err := json.Marshal(&logCosignRequest{
+			WitnessPublicKey: hex.EncodeToString(witnessPublic),
+			TreeSize:         head.TreeSize,
+			RootHash:         hex.EncodeToString(head.RootHash),
+			Signature:        hex.EncodeToString(witnessSig),
+		})
+		require.NoError(t, err)
+
+		resp, err := server.handleLogCosign(ctx, mustNewRequest(ctx, http.MethodPost, "/log/cosign", nil, bytes.NewReader(reqBody)))
+		require.NoError(t, err)
+
+		var body logTreeHeadResponse
+		require.NoError(t, json.Unmarshal(resp.Body, &body))
+		require.Len(t, body.Cosignatures, 1)
+		require.Equal(t, hex.EncodeToString(witnessPublic), body.Cosignatures[0].WitnessPublicKey)
+	}))
+
+	t.Run("HandleLogCosignStale", setup(func(t *testing.T) {
+		witnessPublic, witnessPrivate, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		head := server.translog.TreeHead()
+		witnessSig := ed25519.Sign(witnessPrivate, nstranslog.TreeHeadPayload(head.TreeSize, head.RootHash))
+
+		reqBody, err := json.Marshal(&logCosignRequest{
+			WitnessPublicKey: hex.EncodeToString(witnessPublic),
+			TreeSize:         head.TreeSize + 1,
+			RootHash:         hex.EncodeToString(head.RootHash),
+			Signature:        hex.EncodeToString(witnessSig),
+		})
+		require.NoError(t, err)
+
+		_, err = server.handleLogCosign(ctx, mustNewRequest(ctx, http.MethodPost, "/log/cosign", nil, bytes.NewReader(reqBody)))
+		requireServerError(t, NewServerError(http.StatusConflict, ErrMessageLogCosignStale), err)
+	}))
 }
 
 // High-level integration tests that exercise the entire stack including
@@ -353,9 +580,10 @@ func TestServerRouter(t *testing.T) {
 	ctx := context.Background()
 	denyList := NewMemoryDenyList()
 	keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
-	store := nsmemorystore.NewMemoryStore(logger)
+	store := nsmemorystore.NewMemoryStore(logger, 0)
+	store.SetTimeNow(stableTimeFunc)
 
-	server := NewServer(logger, store, denyList, defaultPort)
+	server := NewServer(logger, store, denyList, nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
 	server.timeNow = stableTimeFunc
 
 	serveReq := func(ctx context.Context, method, path string, header http.Header, body []byte) {
@@ -384,6 +612,202 @@ func TestServerRouter(t *testing.T) {
 	serveReq(ctx, http.MethodGet, "/", nil, nil)
 	serveReq(ctx, http.MethodPut, "/"+keyPair.PublicKey, http.Header{"Spring-Signature": []string{keyPair.SignHex(content)}}, content) //nolint:lll
 	serveReq(ctx, http.MethodGet, "/"+keyPair.PublicKey, nil, nil)
+	serveReq(ctx, http.MethodGet, "/difficulty", nil, nil)
+	serveReq(ctx, http.MethodGet, "/peers", nil, nil)
+}
+
+// Spins up two in-process servers, each with their own memory store,
+// configured as each other's only federation peer, and exercises forwarding
+// and pull-on-miss across the pair.
+func TestServerFederation(t *testing.T) {
+	newFederatedPair := func(t *testing.T) (tsA, tsB *httptest.Server, serverA, serverB *Server) {
+		t.Helper()
+
+		tsA = httptest.NewUnstartedServer(nil)
+		tsB = httptest.NewUnstartedServer(nil)
+		t.Cleanup(tsA.Close)
+		t.Cleanup(tsB.Close)
+
+		urlA := "http://" + tsA.Listener.Addr().String()
+		urlB := "http://" + tsB.Listener.Addr().String()
+
+		storeA := nsmemorystore.NewMemoryStore(logger, 0)
+		storeB := nsmemorystore.NewMemoryStore(logger, 0)
+		storeA.SetTimeNow(stableTimeFunc)
+		storeB.SetTimeNow(stableTimeFunc)
+
+		serverA = NewServer(logger, storeA, NewMemoryDenyList(),
+			nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), urlA, []string{urlB}, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+		serverB = NewServer(logger, storeB, NewMemoryDenyList(),
+			nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), urlB, []string{urlA}, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+		serverA.timeNow = stableTimeFunc
+		serverB.timeNow = stableTimeFunc
+		serverA.federator.timeNow = stableTimeFunc
+		serverB.federator.timeNow = stableTimeFunc
+
+		tsA.Config.Handler = serverA.router
+		tsB.Config.Handler = serverB.router
+		tsA.Start()
+		tsB.Start()
+
+		return tsA, tsB, serverA, serverB
+	}
+
+	t.Run("ForwardsPutToPeer", func(t *testing.T) {
+		tsA, tsB, _, _ := newFederatedPair(t)
+
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		content := []byte(timestampTag(stableTime) + " some content")
+
+		req, err := http.NewRequest(http.MethodPut, tsA.URL+"/"+keyPair.PublicKey, bytes.NewReader(content)) //nolint:noctx
+		require.NoError(t, err)
+		req.Header.Set("Spring-Signature", keyPair.SignHex(content))
+
+		resp, err := tsA.Client().Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+
+		require.Eventually(t, func() bool {
+			resp, err := tsB.Client().Get(tsB.URL + "/" + keyPair.PublicKey)
+			if err != nil {
+				return false
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode == http.StatusOK && string(body) == string(content)
+		}, 2*time.Second, 10*time.Millisecond, "PUT to server A was never forwarded to server B")
+	})
+
+	t.Run("PullsMissingBoardFromPeer", func(t *testing.T) {
+		tsA, _, _, serverB := newFederatedPair(t)
+
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		content := []byte(timestampTag(stableTime) + " some content only B has")
+
+		// B has the board, but A doesn't -- store it directly in B rather
+		// than going through a PUT so that this only exercises the pull path,
+		// not forwarding.
+		err := serverB.boardStore.Put(context.Background(), keyPair.PublicKey, &nsstore.Board{
+			Content:   content,
+			Signature: keyPair.SignHex(content),
+			Timestamp: stableTime,
+		})
+		require.NoError(t, err)
+
+		// Requesting it from A, which doesn't have it locally, should trigger
+		// a pull from B.
+		resp, err := tsA.Client().Get(tsA.URL + "/" + keyPair.PublicKey)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, content, body)
+	})
+
+	t.Run("RejectsForgedBoardFromPeer", func(t *testing.T) {
+		tsA, _, serverA, serverB := newFederatedPair(t)
+
+		keyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+		content := []byte(timestampTag(stableTime) + " some forged content")
+
+		// B has a board for this key, but the signature doesn't match the
+		// content -- as if a peer (malicious or compromised) had forged it.
+		// A must not trust or store this just because B is willing to serve
+		// it.
+		err := serverB.boardStore.Put(context.Background(), keyPair.PublicKey, &nsstore.Board{
+			Content:   content,
+			Signature: hex.EncodeToString(make([]byte, ed25519.SignatureSize)),
+			Timestamp: stableTime,
+		})
+		require.NoError(t, err)
+
+		resp, err := tsA.Client().Get(tsA.URL + "/" + keyPair.PublicKey)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		_, err = serverA.boardStore.Get(context.Background(), keyPair.PublicKey)
+		require.ErrorIs(t, err, nsstore.ErrKeyNotFound, "forged board pulled from a peer must not be stored")
+	})
+}
+
+func TestServerRotation(t *testing.T) {
+	var (
+		ctx    context.Context
+		server *Server
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			ctx = context.Background()
+			store := nsmemorystore.NewMemoryStore(logger, 0)
+			store.SetTimeNow(stableTimeFunc)
+			server = NewServer(logger, store, NewMemoryDenyList(),
+				nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+			server.timeNow = stableTimeFunc
+
+			test(t)
+		}
+	}
+
+	t.Run("IndexesAttestationFromPut", setup(func(t *testing.T) {
+		oldKeyPair := nskey.MustParseKeyPairUnchecked(samplePrivateKey)
+
+		_, newPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		newKeyPair := nskey.KeyPairFromRaw(newPrivateKey)
+
+		notBefore := stableTime
+		notAfter := stableTime.Add(2 * nskey.MaxLifetime)
+
+		sig, err := oldKeyPair.SignSuccessor(newKeyPair, notBefore, notAfter)
+		require.NoError(t, err)
+
+		content := fmt.Sprintf("%s some content\n<!-- spring83-successor: %s %s %s %s -->",
+			timestampTag(stableTime), newKeyPair.PublicKey, notBefore.Format(time.RFC3339), notAfter.Format(time.RFC3339), hex.EncodeToString(sig)) //nolint:lll
+
+		r := mustNewRequest(ctx, http.MethodPut, "/"+oldKeyPair.PublicKey, map[string]string{"key": oldKeyPair.PublicKey}, bytes.NewReader([]byte(content))) //nolint:lll
+		r.Header.Set("Spring-Signature", oldKeyPair.SignHex([]byte(content)))
+
+		_, err = server.handlePutKey(ctx, r)
+		require.NoError(t, err)
+
+		attestation := server.rotationIndex.Lookup(oldKeyPair.PublicKey)
+		require.NotNil(t, attestation)
+		require.Equal(t, newKeyPair.PublicKey, attestation.NewPublicKey)
+	}))
+
+	t.Run("RedirectsExpiredKeyWithAttestation", setup(func(t *testing.T) {
+		// Expired at the end of October 2022, a few days before stableTime --
+		// within the default rotation grace window.
+		const expiredKey = "ab589f4dde9fce4180fcf42c7b05185b0a02a5d682e353fa39177995083e1022"
+
+		server.rotationIndex.Put(&SuccessorAttestation{
+			OldPublicKey: expiredKey,
+			NewPublicKey: samplePublicKey,
+			NotBefore:    stableTime.Add(-time.Hour),
+			NotAfter:     stableTime.Add(nskey.MaxLifetime),
+		})
+
+		r := mustNewRequest(ctx, http.MethodGet, "/"+expiredKey, map[string]string{"key": expiredKey}, nil)
+		resp, err := server.handleGetKey(ctx, r)
+		require.NoError(t, err)
+		requireServerResponse(t, NewServerResponse(http.StatusMovedPermanently, nil, http.Header{
+			"Location": []string{"/" + samplePublicKey},
+		}), resp)
+	}))
+
+	t.Run("NoAttestationStillRejectsExpiredKey", setup(func(t *testing.T) {
+		const expiredKey = "ab589f4dde9fce4180fcf42c7b05185b0a02a5d682e353fa39177995083e0519"
+
+		r := mustNewRequest(ctx, http.MethodGet, "/"+expiredKey, map[string]string{"key": expiredKey}, nil)
+		_, err := server.handleGetKey(ctx, r)
+		requireServerError(t, NewServerError(http.StatusForbidden, ErrMessageKeyExpired), err)
+	}))
 }
 
 func TestServerWrapEndpoint(t *testing.T) {
@@ -402,7 +826,7 @@ func TestServerWrapEndpoint(t *testing.T) {
 			ctxContainer = &ContextContainer{}
 			ctx = context.WithValue(ctx, contextContainerContextKey{}, ctxContainer)
 			recorder = httptest.NewRecorder()
-			server = NewServer(logger, nil, nil, defaultPort)
+			server = NewServer(logger, nil, nil, nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey), "", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
 
 			test(t)
 		}
@@ -435,6 +859,7 @@ func TestServerWrapEndpoint(t *testing.T) {
 		require.Equal(t, "text/html;charset=utf-8", recorder.Header().Get("Content-Type"))
 
 		require.Equal(t, http.StatusBadRequest, ctxContainer.StatusCode)
+		require.Equal(t, "Bad Request", ctxContainer.ErrorClass)
 	}))
 
 	t.Run("InternalError", setup(func(t *testing.T) {
@@ -450,6 +875,144 @@ func TestServerWrapEndpoint(t *testing.T) {
 
 		require.Equal(t, http.StatusInternalServerError, ctxContainer.StatusCode)
 	}))
+
+	t.Run("RecoversPanic", setup(func(t *testing.T) {
+		handler := server.wrapEndpoint(func(ctx context.Context, r *http.Request) (*ServerResponse, error) {
+			panic("something went wrong deep in a dependency")
+		})
+
+		handler.ServeHTTP(recorder, mustNewRequest(ctx, http.MethodGet, "/", nil, nil))
+
+		require.Equal(t, http.StatusInternalServerError, recorder.Code)
+		require.Equal(t, ErrMessageInternalError, recorder.Body.String())
+
+		require.Equal(t, http.StatusInternalServerError, ctxContainer.StatusCode)
+	}))
+}
+
+func TestServerHandleLivezAndReadyz(t *testing.T) {
+	var (
+		ctx    context.Context
+		server *Server
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			ctx = context.Background()
+			store := nsmemorystore.NewMemoryStore(logger, 0)
+			store.SetTimeNow(stableTimeFunc)
+			server = NewServer(logger, store, NewMemoryDenyList(), nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey),
+				"", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+
+			test(t)
+		}
+	}
+
+	t.Run("LivezAlwaysOK", setup(func(t *testing.T) {
+		resp, err := server.handleLivez(ctx, mustNewRequest(ctx, http.MethodGet, "/livez", nil, nil))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		server.shuttingDown.Store(true)
+
+		resp, err = server.handleLivez(ctx, mustNewRequest(ctx, http.MethodGet, "/livez", nil, nil))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}))
+
+	t.Run("ReadyzOKUntilShutdown", setup(func(t *testing.T) {
+		resp, err := server.handleReadyz(ctx, mustNewRequest(ctx, http.MethodGet, "/readyz", nil, nil))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		server.shuttingDown.Store(true)
+
+		_, err = server.handleReadyz(ctx, mustNewRequest(ctx, http.MethodGet, "/readyz", nil, nil))
+		requireServerError(t, NewServerError(http.StatusServiceUnavailable, "Server is shutting down."), err)
+	}))
+}
+
+func TestServerShutdown(t *testing.T) {
+	var (
+		server    *Server
+		requestFn func(w http.ResponseWriter, r *http.Request)
+	)
+
+	setup := func(test func(*testing.T)) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+
+			store := nsmemorystore.NewMemoryStore(logger, 0)
+			store.SetTimeNow(stableTimeFunc)
+			server = NewServer(logger, store, NewMemoryDenyList(), nskey.MustParseKeyPairUnchecked(sampleLogPrivateKey),
+				"", nil, defaultPort, 0, MaxInFlightConfig{}, AccessLogConfig{}, RateLimitConfig{}, nil)
+
+			test(t)
+		}
+	}
+
+	t.Run("DrainsInFlightRequestsBeforeReturning", setup(func(t *testing.T) {
+		unblock := make(chan struct{})
+		requestFn = func(_ http.ResponseWriter, _ *http.Request) {
+			<-unblock
+		}
+
+		requestDone := make(chan struct{})
+		go func() {
+			handler := server.inspectableWriterMiddleware.Wrapper(http.HandlerFunc(requestFn))
+			handler.ServeHTTP(httptest.NewRecorder(), mustNewRequest(context.Background(), http.MethodGet, "/", nil, nil))
+			close(requestDone)
+		}()
+
+		// Give the goroutine above a chance to register as in flight before we
+		// ask Shutdown to wait for it.
+		require.Eventually(t, func() bool { return server.inspectableWriterMiddleware.ActiveRequests() > 0 },
+			time.Second, time.Millisecond)
+
+		close(unblock)
+
+		require.NoError(t, server.Shutdown(context.Background()))
+		<-requestDone
+
+		select {
+		case <-server.storeShutdown:
+		default:
+			require.Fail(t, "expected storeShutdown to be closed")
+		}
+	}))
+
+	t.Run("HandlesCanceled", setup(func(t *testing.T) {
+		unblock := make(chan struct{})
+		t.Cleanup(func() { close(unblock) })
+
+		requestFn = func(_ http.ResponseWriter, _ *http.Request) {
+			<-unblock
+		}
+
+		requestDone := make(chan struct{})
+		go func() {
+			handler := server.inspectableWriterMiddleware.Wrapper(http.HandlerFunc(requestFn))
+			handler.ServeHTTP(httptest.NewRecorder(), mustNewRequest(context.Background(), http.MethodGet, "/", nil, nil))
+			close(requestDone)
+		}()
+
+		require.Eventually(t, func() bool { return server.inspectableWriterMiddleware.ActiveRequests() > 0 },
+			time.Second, time.Millisecond)
+
+		cancelCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := server.Shutdown(cancelCtx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		select {
+		case <-server.storeShutdown:
+			require.Fail(t, "expected storeShutdown to remain open")
+		default:
+		}
+	}))
 }
 
 func TestIsTimestampOnly(t *testing.T) {